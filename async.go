@@ -0,0 +1,242 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// ErrQueueFull is returned by AsyncWriter.Write when an event is rejected
+// because the queue is full.
+var ErrQueueFull = errors.New("sse: queue full")
+
+// OverflowPolicy decides what an AsyncWriter does when its queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes Write wait until there is room in the queue.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropNewest discards the event being written. Write returns
+	// ErrQueueFull.
+	OverflowDropNewest
+
+	// OverflowDropOldest discards the oldest queued event to make room.
+	OverflowDropOldest
+
+	// OverflowClose closes the stream, so that a client that cannot keep up
+	// reconnects. Write returns ErrQueueFull.
+	OverflowClose
+)
+
+// AsyncWriter is a Writer whose Write enqueues events and returns immediately.
+// A single goroutine drains the queue to the client, so a producer is not
+// blocked by a slow client. With Options.Coalesce, a queued event is replaced
+// by a newer event with the same key while the client is behind.
+type AsyncWriter struct {
+	rw       *responseWriter
+	overflow OverflowPolicy
+	size     int
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []message
+	closing bool
+
+	errs    chan error
+	stopped chan struct{}
+}
+
+// NewAsyncWriter creates a new asynchronous Writer for Server-Sent Events
+// that holds up to queueSize events. A queueSize below 1 is treated as 1.
+// What happens when the queue is full is decided by opts.Overflow.
+//
+// The handler must close the writer before it returns.
+func NewAsyncWriter(w http.ResponseWriter, opts Options, queueSize int) *AsyncWriter {
+	return newAsyncWriter(newResponseWriter(context.Background(), w, opts), queueSize)
+}
+
+// newAsyncWriter creates an AsyncWriter draining to rw.
+func newAsyncWriter(rw *responseWriter, queueSize int) *AsyncWriter {
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	aw := &AsyncWriter{
+		rw:       rw,
+		overflow: rw.options.Overflow,
+		size:     queueSize,
+		queue:    make([]message, 0, queueSize),
+		errs:     make(chan error, 1),
+		stopped:  make(chan struct{}),
+	}
+	aw.cond = sync.NewCond(&aw.mu)
+	go aw.drain()
+	return aw
+}
+
+// Write marshals the data and enqueues the event.
+func (aw *AsyncWriter) Write(event string, data interface{}) error {
+	return aw.WriteContext(aw.rw.ctx, event, data)
+}
+
+// WriteContext marshals the data and enqueues the event on behalf of ctx.
+func (aw *AsyncWriter) WriteContext(ctx context.Context, event string, data interface{}) error {
+	m, err := aw.rw.newMessage(ctx, event, data)
+	if err != nil {
+		return err
+	}
+	return aw.write(m)
+}
+
+// WriteEvent enqueues an event as is.
+func (aw *AsyncWriter) WriteEvent(e Event) error {
+	m, err := aw.rw.newEventMessage(e)
+	if err != nil {
+		return err
+	}
+	return aw.write(m)
+}
+
+// write enqueues a message, ending its span if it is rejected.
+func (aw *AsyncWriter) write(m message) error {
+	if err := aw.enqueue(m); err != nil {
+		m.end(err)
+		return err
+	}
+	return nil
+}
+
+// Close sends the remaining queued events and closes the stream.
+func (aw *AsyncWriter) Close() error {
+	aw.mu.Lock()
+	aw.closing = true
+	aw.cond.Broadcast()
+	aw.mu.Unlock()
+
+	<-aw.stopped
+	return aw.rw.Close()
+}
+
+// Done returns a channel that is closed once the stream has been closed.
+func (aw *AsyncWriter) Done() <-chan struct{} {
+	return aw.rw.Done()
+}
+
+// Err returns a channel that receives the error that stopped the writer.
+// After an error, queued events are discarded and further writes return
+// ErrClosed.
+func (aw *AsyncWriter) Err() <-chan error {
+	return aw.errs
+}
+
+// Len returns the number of queued events.
+func (aw *AsyncWriter) Len() int {
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+	return len(aw.queue)
+}
+
+// enqueue adds a message to the queue, applying the overflow policy.
+func (aw *AsyncWriter) enqueue(m message) error {
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+
+	for {
+		if aw.closing || aw.isDone() {
+			return ErrClosed
+		}
+		if aw.rw.coalesce(aw.queue, m) {
+			return nil
+		}
+		if len(aw.queue) < aw.size {
+			break
+		}
+		switch aw.overflow {
+		case OverflowDropNewest:
+			aw.rw.reportDrop(m.event, DropReasonOverflow)
+			return ErrQueueFull
+		case OverflowDropOldest:
+			aw.rw.reportDrop(aw.queue[0].event, DropReasonOverflow)
+			aw.queue[0].drop(DropReasonOverflow)
+			aw.queue = append(aw.queue[:0], aw.queue[1:]...)
+		case OverflowClose:
+			aw.rw.reportDrop(m.event, DropReasonOverflow)
+			aw.stop()
+			go func() { _ = aw.rw.closeReason(CloseReasonOverflow) }()
+			return ErrQueueFull
+		default:
+			aw.cond.Wait()
+		}
+	}
+
+	aw.queue = append(aw.queue, m)
+	aw.cond.Broadcast()
+	return nil
+}
+
+// drain writes queued messages to the client until the writer is closed.
+func (aw *AsyncWriter) drain() {
+	defer close(aw.stopped)
+	for {
+		aw.mu.Lock()
+		for len(aw.queue) == 0 && !aw.closing {
+			aw.cond.Wait()
+		}
+		if len(aw.queue) == 0 {
+			aw.mu.Unlock()
+			return
+		}
+		m := aw.queue[0]
+		aw.queue = append(aw.queue[:0], aw.queue[1:]...)
+		aw.cond.Broadcast()
+		aw.mu.Unlock()
+
+		err := aw.send(m)
+		if err == nil {
+			continue
+		}
+		if err != ErrClosed {
+			aw.errs <- err
+		}
+		aw.mu.Lock()
+		aw.stop()
+		aw.mu.Unlock()
+		return
+	}
+}
+
+// send writes a single message to the underlying writer.
+func (aw *AsyncWriter) send(m message) error {
+	aw.rw.mu.Lock()
+	defer aw.rw.mu.Unlock()
+	if aw.rw.closed {
+		m.end(ErrClosed)
+		return ErrClosed
+	}
+	if aw.rw.options.FlushInterval > 0 {
+		return aw.rw.buffer(m)
+	}
+	return aw.rw.send(m)
+}
+
+// stop discards the queue and rejects further writes. The caller must hold
+// aw.mu.
+func (aw *AsyncWriter) stop() {
+	aw.closing = true
+	for _, m := range aw.queue {
+		m.end(ErrClosed)
+	}
+	aw.queue = aw.queue[:0]
+	aw.cond.Broadcast()
+}
+
+// isDone reports whether the underlying stream has been closed.
+func (aw *AsyncWriter) isDone() bool {
+	select {
+	case <-aw.rw.Done():
+		return true
+	default:
+		return false
+	}
+}
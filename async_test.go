@@ -0,0 +1,143 @@
+package sse
+
+import (
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestAsyncWriter_Write(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writer := NewAsyncWriter(rec, Options{}, 4)
+
+	for i := 0; i < 3; i++ {
+		if err := writer.Write("test", i); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	expected := "id: 1\nevent: test\ndata: 0\n\n" +
+		"id: 2\nevent: test\ndata: 1\n\n" +
+		"id: 3\nevent: test\ndata: 2\n\n"
+	if rec.Body.String() != expected {
+		t.Fatalf("expected data: %q, got: %q", expected, rec.Body.String())
+	}
+	if err := writer.Write("test", nil); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestAsyncWriter_Overflow(t *testing.T) {
+	tests := []struct {
+		name          string
+		overflow      OverflowPolicy
+		expectedError error
+		expectedData  string
+	}{
+		{
+			name:          "Drop Newest",
+			overflow:      OverflowDropNewest,
+			expectedError: ErrQueueFull,
+			expectedData:  "id: 1\ndata: 1\n\nid: 2\ndata: 2\n\nid: 3\ndata: 3\n\n",
+		},
+		{
+			name:         "Drop Oldest",
+			overflow:     OverflowDropOldest,
+			expectedData: "id: 1\ndata: 1\n\nid: 2\ndata: 3\n\nid: 3\ndata: 4\n\n",
+		},
+		{
+			name:          "Close",
+			overflow:      OverflowClose,
+			expectedError: ErrQueueFull,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writer := NewAsyncWriter(rec, Options{Overflow: tt.overflow}, 2)
+
+			// Hold the stream's lock so that the queue cannot drain past the
+			// first event.
+			writer.rw.mu.Lock()
+			if err := writer.Write("", 1); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			waitForEmptyQueue(writer)
+
+			var err error
+			for i := 2; i <= 4; i++ {
+				if e := writer.Write("", i); e != nil {
+					err = e
+				}
+			}
+			writer.rw.mu.Unlock()
+
+			if err != tt.expectedError {
+				t.Fatalf("expected error: %v, got: %v", tt.expectedError, err)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.expectedData != "" && rec.Body.String() != tt.expectedData {
+				t.Fatalf("expected data: %q, got: %q", tt.expectedData, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAsyncWriter_Err(t *testing.T) {
+	writer := NewAsyncWriter(&nonFlusherWriter{ResponseWriter: httptest.NewRecorder()}, Options{}, 1)
+
+	if err := writer.Write("test", nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := <-writer.Err(); err == nil || errors.Is(err, ErrClosed) {
+		t.Fatalf("expected a write error, got %v", err)
+	}
+	if err := writer.Write("test", nil); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestAsyncWriter_Concurrent(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writer := NewAsyncWriter(rec, Options{}, 8)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				if err := writer.Write("test", j); err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := writer.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := writer.rw.nonce; got != 100 {
+		t.Fatalf("expected 100 events, got %d", got)
+	}
+}
+
+// waitForEmptyQueue waits until the drain goroutine has taken every event from
+// the queue.
+func waitForEmptyQueue(aw *AsyncWriter) {
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+	for len(aw.queue) != 0 {
+		aw.cond.Wait()
+	}
+}
@@ -0,0 +1,67 @@
+package sse
+
+import "sync"
+
+// Backplane carries the events of a Broker between the instances of a
+// service, so that an event published on one instance reaches the
+// subscribers connected to every instance.
+//
+// The ssenet package implements a Backplane over TCP or Unix sockets, and
+// the sseredis package one over Redis. A backplane may replace the ids of the
+// events it carries, for example with the ids of its own log.
+type Backplane interface {
+	// Publish sends an event to the subscribers of every instance,
+	// including this one.
+	Publish(e Event) error
+
+	// Subscribe calls fn with every event published on the backplane, until
+	// cancel is called. Events are passed one at a time, in the order the
+	// backplane received them; fn must not block.
+	Subscribe(fn func(Event)) (cancel func())
+}
+
+// BackplaneHistory is implemented by a Backplane that keeps past events. A
+// Broker asks it for the events a reconnecting client missed when they are no
+// longer in its own buffer, so that clients can resume on any instance, even
+// one that just started.
+type BackplaneHistory interface {
+	// Since returns the events published after the one with the given id,
+	// oldest first, and whether that event was found. If there are more
+	// than limit events, it returns the latest ones and false.
+	Since(lastEventID string, limit int) ([]Event, bool, error)
+}
+
+// Loopback is a Backplane that connects the brokers of a single process. It
+// is meant for tests: brokers sharing a Loopback behave like instances
+// sharing a network backplane. The zero value is ready to use.
+type Loopback struct {
+	mu   sync.Mutex
+	subs map[*func(Event)]struct{}
+}
+
+// Publish passes the event to every subscriber before it returns.
+func (l *Loopback) Publish(e Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for fn := range l.subs {
+		(*fn)(e)
+	}
+	return nil
+}
+
+// Subscribe calls fn with every event published on the loopback. fn must not
+// publish on the loopback itself.
+func (l *Loopback) Subscribe(fn func(Event)) (cancel func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.subs == nil {
+		l.subs = make(map[*func(Event)]struct{})
+	}
+	key := &fn
+	l.subs[key] = struct{}{}
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.subs, key)
+	}
+}
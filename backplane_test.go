@@ -0,0 +1,101 @@
+package sse
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLoopback(t *testing.T) {
+	var backplane Loopback
+	a := NewBroker(BrokerOptions{Backplane: &backplane})
+	b := NewBroker(BrokerOptions{Backplane: &backplane})
+	defer b.Close()
+
+	var onA, onB eventCollector
+	a.Subscribe(&onA, "")
+	b.Subscribe(&onB, "")
+	if err := a.Publish("add", 1); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := b.PublishEvent(Event{ID: "x", Event: "add", Data: "2"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if !reflect.DeepEqual(onA.events, onB.events) || len(onA.events) != 2 {
+		t.Fatalf("expected both brokers to receive both events, got %+v and %+v", onA.events, onB.events)
+	}
+	if id := onA.events[0].ID; !strings.HasSuffix(id, "-1") || id == "-1" {
+		t.Errorf("expected an id prefixed with the instance, got %q", id)
+	}
+
+	// A client moving to the other instance resumes from its buffer.
+	var resumed eventCollector
+	_, complete := b.Subscribe(&resumed, onA.events[0].ID)
+	if !complete || len(resumed.events) != 1 || resumed.events[0].ID != "x" {
+		t.Errorf("expected event x to be replayed, got %+v (complete %v)", resumed.events, complete)
+	}
+
+	a.Close()
+	if err := b.Publish("add", 3); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(onA.events) != 2 || len(onB.events) != 3 {
+		t.Errorf("expected a closed broker to stop receiving, got %d and %d events", len(onA.events), len(onB.events))
+	}
+}
+
+// historyLoopback is a Loopback that keeps every event, as a
+// BackplaneHistory.
+type historyLoopback struct {
+	Loopback
+	events []Event
+}
+
+func (h *historyLoopback) Publish(e Event) error {
+	h.events = append(h.events, e)
+	return h.Loopback.Publish(e)
+}
+
+func (h *historyLoopback) Since(lastEventID string, limit int) ([]Event, bool, error) {
+	for i := len(h.events) - 1; i >= 0; i-- {
+		if h.events[i].ID == lastEventID {
+			missed := h.events[i+1:]
+			if len(missed) > limit {
+				return missed[len(missed)-limit:], false, nil
+			}
+			return missed, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func TestBroker_History(t *testing.T) {
+	backplane := &historyLoopback{}
+	broker := NewBroker(BrokerOptions{Backplane: backplane, ReplaySize: 2})
+	defer broker.Close()
+	for i := 1; i <= 5; i++ {
+		if err := broker.PublishEvent(Event{ID: strconv.Itoa(i), Data: "x"}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	tests := []struct {
+		lastEventID string
+		expected    []string
+		complete    bool
+	}{
+		{"4", []string{"5"}, true},
+		{"1", []string{"2", "3", "4", "5"}, true},
+		{"0", []string{"4", "5"}, false},
+	}
+	for _, test := range tests {
+		var w eventCollector
+		_, complete := broker.Subscribe(&w, test.lastEventID)
+		if !reflect.DeepEqual(w.ids(), test.expected) || complete != test.complete {
+			t.Errorf("%s: expected %v (complete %v), got %v (complete %v)",
+				test.lastEventID, test.expected, test.complete, w.ids(), complete)
+		}
+	}
+}
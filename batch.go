@@ -0,0 +1,61 @@
+package sse
+
+import "time"
+
+// CoalesceByEvent is a Coalesce function that keeps only the newest pending
+// event of each name.
+func CoalesceByEvent(event string, _ interface{}) string {
+	return event
+}
+
+// coalesce replaces the message in queue with the same key as m, if any, and
+// reports whether it did.
+func (rw *responseWriter) coalesce(queue []message, m message) bool {
+	if m.key == "" {
+		return false
+	}
+	for i := range queue {
+		if queue[i].key == m.key {
+			rw.reportDrop(queue[i].event, DropReasonCoalesced)
+			queue[i].drop(DropReasonCoalesced)
+			queue[i] = m
+			return true
+		}
+	}
+	return false
+}
+
+// buffer adds a message to the pending batch. The batch is sent once it holds
+// MaxBatch messages or when FlushInterval has elapsed. The caller must hold
+// rw.mu.
+func (rw *responseWriter) buffer(m message) error {
+	if !rw.coalesce(rw.pending, m) {
+		rw.pending = append(rw.pending, m)
+	}
+	if limit := rw.options.MaxBatch; limit > 0 && len(rw.pending) >= limit {
+		return rw.send()
+	}
+	if len(rw.pending) == 1 {
+		if rw.batch == nil {
+			rw.batch = time.AfterFunc(rw.options.FlushInterval, rw.flushPending)
+			rw.timers = append(rw.timers, rw.batch)
+		} else {
+			rw.batch.Reset(rw.options.FlushInterval)
+		}
+	}
+	return nil
+}
+
+// flushPending sends the pending batch. If that fails, the stream is closed
+// and the error is returned by the next write.
+func (rw *responseWriter) flushPending() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.closed {
+		return
+	}
+	if err := rw.send(); err != nil {
+		rw.err = err
+		rw.close(CloseReasonError)
+	}
+}
@@ -0,0 +1,175 @@
+package sse
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseWriter_MaxBatch(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writer := NewResponseWriter(rec, Options{
+		FlushInterval: time.Hour,
+		MaxBatch:      2,
+	}).(CloseWriter)
+
+	if err := writer.Write("test", 1); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if rec.Body.Len() != 0 {
+		t.Fatalf("expected event to be buffered, got %q", rec.Body.String())
+	}
+	if err := writer.Write("test", 2); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	expected := "id: 1\nevent: test\ndata: 1\n\nid: 2\nevent: test\ndata: 2\n\n"
+	if rec.Body.String() != expected {
+		t.Fatalf("expected data: %q, got: %q", expected, rec.Body.String())
+	}
+
+	// Close sends the remainder of the batch.
+	if err := writer.Write("test", 3); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected += "id: 3\nevent: test\ndata: 3\n\n"
+	if rec.Body.String() != expected {
+		t.Fatalf("expected data: %q, got: %q", expected, rec.Body.String())
+	}
+}
+
+func TestResponseWriter_FlushInterval(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writer := NewResponseWriter(rec, Options{
+		FlushInterval: 10 * time.Millisecond,
+		Encoding:      EncodeGzip,
+	}).(*responseWriter)
+	defer func() {
+		if err := writer.Close(); err != nil {
+			t.Errorf("writer.Close() error = %v", err)
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		if err := writer.Write("test", i); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		writer.mu.Lock()
+		written, pending := rec.Body.Len(), len(writer.pending)
+		writer.mu.Unlock()
+		if written > 0 && pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected batch to be flushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	expected, err := encode(EncodeGzip, "id: 1\nevent: test\ndata: 0\n\n"+
+		"id: 2\nevent: test\ndata: 1\n\n"+
+		"id: 3\nevent: test\ndata: 2\n\n")
+	if err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+	if rec.Body.String() != string(expected) {
+		t.Fatalf("expected the batch to be encoded as a single write")
+	}
+}
+
+func TestResponseWriter_BatchHeartbeat(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writer := NewResponseWriter(rec, Options{
+		FlushInterval: time.Hour,
+		Heartbeat:     10 * time.Millisecond,
+	}).(*responseWriter)
+
+	// Heartbeats do not send the pending batch early.
+	if err := writer.Write("test", 1); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	writer.mu.Lock()
+	body := rec.Body.String()
+	writer.mu.Unlock()
+	if !strings.Contains(body, ": heartbeat\n\n") || strings.Contains(body, "event: test") {
+		t.Fatalf("expected heartbeats without the event, got %q", body)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if body := rec.Body.String(); !strings.HasSuffix(body, "id: 1\nevent: test\ndata: 1\n\n") {
+		t.Errorf("expected the batch to be sent on close, got %q", body)
+	}
+}
+
+func TestResponseWriter_Coalesce(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writer := NewResponseWriter(rec, Options{
+		FlushInterval: time.Hour,
+		Coalesce:      CoalesceByEvent,
+	}).(CloseWriter)
+
+	for _, w := range []struct {
+		event string
+		data  int
+	}{{"price", 1}, {"volume", 10}, {"price", 2}, {"price", 3}} {
+		if err := writer.Write(w.event, w.data); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	expected := "id: 1\nevent: price\ndata: 3\n\nid: 2\nevent: volume\ndata: 10\n\n"
+	if rec.Body.String() != expected {
+		t.Fatalf("expected data: %q, got: %q", expected, rec.Body.String())
+	}
+}
+
+func TestAsyncWriter_Coalesce(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writer := NewAsyncWriter(rec, Options{
+		Coalesce: func(_ string, data interface{}) string {
+			return data.(map[string]string)["symbol"]
+		},
+	}, 4)
+
+	// Hold the stream's lock so that the queue cannot drain past the first
+	// event.
+	writer.rw.mu.Lock()
+	if err := writer.Write("tick", map[string]string{"symbol": "A", "price": "1"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	waitForEmptyQueue(writer)
+	for _, price := range []string{"2", "3", "4"} {
+		if err := writer.Write("tick", map[string]string{"symbol": "A", "price": price}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if writer.Len() != 1 {
+		t.Errorf("expected 1 queued event, got %d", writer.Len())
+	}
+	writer.rw.mu.Unlock()
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := "id: 1\nevent: tick\ndata: {\"price\":\"1\",\"symbol\":\"A\"}\n\n" +
+		"id: 2\nevent: tick\ndata: {\"price\":\"4\",\"symbol\":\"A\"}\n\n"
+	if rec.Body.String() != expected {
+		t.Fatalf("expected data: %q, got: %q", expected, rec.Body.String())
+	}
+}
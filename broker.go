@@ -0,0 +1,466 @@
+package sse
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/floriscornel/sse/internal/slogdiscard"
+)
+
+// DefaultReplaySize is the number of events a Broker keeps for replay when
+// BrokerOptions.ReplaySize is zero.
+const DefaultReplaySize = 1024
+
+// BrokerOptions holds configuration for a Broker.
+type BrokerOptions struct {
+	// Options configure the writer of every subscriber. The Encoding is
+	// negotiated per client instead, see Encodings. Options.Overflow decides
+	// what happens to a subscriber that falls behind; OverflowBlock is
+	// treated as OverflowClose, so that a slow client cannot stall the others.
+	Options Options
+
+	// Encodings the broker may use, negotiated with each client's
+	// Accept-Encoding header. If nil, the encodings of NegotiateEncoding are
+	// used. An empty, non-nil slice disables encoding.
+	Encodings []string
+
+	// ReplaySize is the number of recent events kept to replay to clients
+	// that reconnect with a Last-Event-ID. If zero, DefaultReplaySize is used;
+	// if negative, nothing is kept.
+	ReplaySize int
+
+	// QueueSize is the number of live events queued for each subscriber.
+	// If zero, 64 is used.
+	QueueSize int
+
+	// Envelope wraps the data of events that have a topic in a JSON object,
+	// {"topic":"orders.42","data":...}, for clients such as EventSource that
+	// ignore the topic field. Data that is not JSON is wrapped as a string.
+	Envelope bool
+
+	// LongPollTimeout is how long a long poll is held when there are no
+	// events to return, see IsLongPoll. If zero, DefaultLongPollTimeout is
+	// used.
+	LongPollTimeout time.Duration
+
+	// Backplane, if set, carries published events to the brokers of other
+	// instances. Events are delivered to subscribers, and buffered for
+	// replay, when they come back from the backplane, so every instance sees
+	// them in the same order. The ids the broker assigns are prefixed with a
+	// random instance id, so that they are unique across instances. If the
+	// backplane implements BackplaneHistory, clients whose Last-Event-ID is
+	// no longer buffered resume from its history.
+	Backplane Backplane
+}
+
+// Broker publishes events to any number of subscribers. It keeps a buffer of
+// recent events, so that clients reconnecting with a Last-Event-ID receive the
+// events they missed. Broker implements http.Handler, serving every request as
+// a subscriber.
+type Broker struct {
+	options BrokerOptions
+	log     *slog.Logger
+
+	mu       sync.Mutex
+	seq      uint64
+	replay   []Event // a ring of recent events, oldest at start
+	start    int
+	subs     map[*subscriber]struct{}
+	anyTopic map[*subscriber]struct{} // subscribers without topic patterns
+	topics   topicTrie                // subscribers by topic pattern
+	closed   bool
+	done     chan struct{}
+
+	instance          string // prefix of assigned ids, if there is a backplane
+	cancelSubscribing func()
+}
+
+// subscriber is a writer receiving the events of a Broker.
+type subscriber struct {
+	w      EventWriter
+	filter *Filter
+}
+
+// NewBroker creates a new Broker.
+func NewBroker(opts BrokerOptions) *Broker {
+	if opts.ReplaySize == 0 {
+		opts.ReplaySize = DefaultReplaySize
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 64
+	}
+	if opts.Options.Overflow == OverflowBlock {
+		opts.Options.Overflow = OverflowClose
+	}
+	log := slogdiscard.Logger()
+	if opts.Options.Logger != nil {
+		log = opts.Options.Logger
+	}
+	b := &Broker{
+		options:  opts,
+		log:      log,
+		subs:     make(map[*subscriber]struct{}),
+		anyTopic: make(map[*subscriber]struct{}),
+		done:     make(chan struct{}),
+	}
+	if opts.Backplane != nil {
+		b.instance = strconv.FormatUint(rand.Uint64()>>32, 36) + "-"
+		b.cancelSubscribing = opts.Backplane.Subscribe(b.receive)
+	}
+	return b
+}
+
+// Publish marshals data to JSON and publishes it as an event with the given
+// type and the next id of the broker.
+func (b *Broker) Publish(event string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return b.PublishEvent(Event{Event: event, Data: string(encoded)})
+}
+
+// PublishTopic is like Publish, for an event on the given topic.
+func (b *Broker) PublishTopic(topic, event string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return b.PublishEvent(Event{Event: event, Topic: topic, Data: string(encoded)})
+}
+
+// PublishEvent publishes an event as is. If the event has no id, it is given
+// the next id of the broker. Ids are opaque: a client's Last-Event-ID is only
+// looked up among the buffered events.
+//
+// An event with a Topic reaches the subscribers whose topic patterns match
+// it, found through an index of the patterns, and the subscribers without
+// topic patterns. A topic must not contain empty segments or wildcards.
+func (b *Broker) PublishEvent(e Event) error {
+	if _, err := newEventMessage(e); err != nil {
+		return err
+	}
+	if e.Topic != "" && !validTopic(e.Topic) {
+		return ErrInvalidEvent
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	b.seq++
+	if e.ID == "" {
+		e.ID = b.instance + strconv.FormatUint(b.seq, 10)
+	}
+	if b.options.Backplane != nil {
+		b.mu.Unlock()
+		return b.options.Backplane.Publish(e)
+	}
+	defer b.mu.Unlock()
+	b.dispatch(e)
+	return nil
+}
+
+// receive dispatches an event received from the backplane.
+func (b *Broker) receive(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.dispatch(e)
+	}
+}
+
+// dispatch buffers an event and writes it to the subscribers. The caller must
+// hold b.mu.
+func (b *Broker) dispatch(e Event) {
+	if n := b.options.ReplaySize; n > 0 {
+		if len(b.replay) < n {
+			b.replay = append(b.replay, e)
+		} else {
+			b.replay[b.start] = e
+			b.start = (b.start + 1) % n
+		}
+	}
+	fe, wire := &filterEvent{Event: e}, b.wire(e)
+	for sub := range b.anyTopic {
+		b.deliver(sub, fe, wire)
+	}
+	if e.Topic != "" {
+		matched := make(map[*subscriber]struct{})
+		b.topics.match(e.Topic, matched)
+		for sub := range matched {
+			b.deliver(sub, fe, wire)
+		}
+	}
+}
+
+// deliver writes an event to a subscriber if its filter selects the event,
+// removing the subscriber if the write fails. Topics are matched by the
+// caller. The caller must hold b.mu.
+func (b *Broker) deliver(sub *subscriber, e *filterEvent, wire Event) {
+	if !sub.filter.matchData(e) {
+		return
+	}
+	if err := sub.w.WriteEvent(wire); err != nil && !errors.Is(err, ErrQueueFull) {
+		b.remove(sub)
+	}
+}
+
+// wire returns the event as written to subscribers.
+func (b *Broker) wire(e Event) Event {
+	if !b.options.Envelope || e.Topic == "" {
+		return e
+	}
+	data := json.RawMessage(e.Data)
+	if !json.Valid(data) {
+		data, _ = json.Marshal(e.Data)
+	}
+	encoded, _ := json.Marshal(struct {
+		Topic string          `json:"topic"`
+		Data  json.RawMessage `json:"data"`
+	}{e.Topic, data})
+	e.Data = string(encoded)
+	return e
+}
+
+// add adds a subscriber. The caller must hold b.mu.
+func (b *Broker) add(sub *subscriber) {
+	b.subs[sub] = struct{}{}
+	if sub.filter == nil || sub.filter.topics == nil {
+		b.anyTopic[sub] = struct{}{}
+		return
+	}
+	for _, pattern := range sub.filter.topics {
+		b.topics.add(pattern, sub)
+	}
+}
+
+// remove removes a subscriber, if it was added. The caller must hold b.mu.
+func (b *Broker) remove(sub *subscriber) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	delete(b.anyTopic, sub)
+	if sub.filter != nil {
+		for _, pattern := range sub.filter.topics {
+			b.topics.remove(pattern, sub)
+		}
+	}
+}
+
+// Subscribe writes the buffered events after lastEventID to w and then every
+// published event, until the returned function is called or a write fails.
+// Writes happen while the broker is locked, so w must not block; an
+// AsyncWriter with a non-blocking Overflow policy is a good fit.
+//
+// If lastEventID is empty, no events are replayed. If it is not among the
+// buffered events, every buffered event is replayed and complete is false:
+// the subscriber may have missed events.
+func (b *Broker) Subscribe(w EventWriter, lastEventID string) (unsubscribe func(), complete bool) {
+	return b.subscribe(w, lastEventID, nil, nil)
+}
+
+// SubscribeFilter is like Subscribe, but only writes the events selected by
+// filter, both replayed and live. Events are matched before they are
+// written, so unwanted events cost no encoding or bandwidth.
+func (b *Broker) SubscribeFilter(w EventWriter, lastEventID string, filter *Filter) (unsubscribe func(), complete bool) {
+	return b.subscribe(w, lastEventID, filter, nil)
+}
+
+// subscribe is SubscribeFilter, with resume deciding which events are written
+// before the live ones, given the missed events and whether they are
+// complete. If resume is nil, the missed events are written.
+func (b *Broker) subscribe(w EventWriter, lastEventID string, filter *Filter,
+	resume func(missed []Event, complete bool) []Event,
+) (unsubscribe func(), complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &subscriber{w: w, filter: filter}
+	unsubscribe = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if !b.closed {
+			b.remove(sub)
+		}
+	}
+	if b.closed {
+		return unsubscribe, false
+	}
+
+	missed, complete := b.since(lastEventID)
+	if history, ok := b.options.Backplane.(BackplaneHistory); ok && !complete {
+		missed, complete = b.sinceHistory(history, lastEventID)
+		if b.closed {
+			return unsubscribe, false
+		}
+	}
+	if resume != nil {
+		missed = resume(missed, complete)
+	}
+	for _, e := range missed {
+		if !filter.Match(e) {
+			continue
+		}
+		if err := w.WriteEvent(b.wire(e)); err != nil && !errors.Is(err, ErrQueueFull) {
+			return unsubscribe, complete
+		}
+	}
+	b.add(sub)
+	return unsubscribe, complete
+}
+
+// since returns the buffered events after the one with the given id, and
+// whether that event was found. The caller must hold b.mu.
+func (b *Broker) since(lastEventID string) ([]Event, bool) {
+	if lastEventID == "" {
+		return nil, true
+	}
+	ordered := append(b.replay[b.start:len(b.replay):len(b.replay)], b.replay[:b.start]...)
+	for i := len(ordered) - 1; i >= 0; i-- {
+		if ordered[i].ID == lastEventID {
+			return ordered[i+1:], true
+		}
+	}
+	return ordered, false
+}
+
+// sinceHistory is since for an event that is no longer buffered, looked up in
+// the history of the backplane. The caller must hold b.mu, which is released
+// while the backplane is queried.
+func (b *Broker) sinceHistory(history BackplaneHistory, lastEventID string) ([]Event, bool) {
+	b.mu.Unlock()
+	missed, complete, err := history.Since(lastEventID, b.historySize())
+	b.mu.Lock()
+	if err != nil {
+		b.log.Warn("sse history unavailable", slog.Any("error", err))
+		return b.since(lastEventID)
+	}
+	if !complete && len(missed) == 0 {
+		return b.since(lastEventID)
+	}
+
+	// Add the events dispatched while the backplane was queried.
+	last := lastEventID
+	if len(missed) > 0 {
+		last = missed[len(missed)-1].ID
+	}
+	if rest, ok := b.since(last); ok {
+		missed = append(missed, rest...)
+	}
+	return missed, complete
+}
+
+// historySize returns the number of events to ask the history of the
+// backplane for: at least DefaultReplaySize.
+func (b *Broker) historySize() int {
+	return max(b.options.ReplaySize, DefaultReplaySize)
+}
+
+// ServeHTTP streams the broker's events to a client, starting with the events
+// it missed according to its Last-Event-ID header, or its lastEventId query
+// parameter. The client receives the events selected by the filter of the
+// request, see ParseFilter; a request with an invalid filter gets a 400 Bad
+// Request response. With Options.WebSocket, clients that ask to upgrade are
+// served over WebSocket. Long polls, see IsLongPoll, get the events after
+// their last event id as soon as there is one, or none after
+// LongPollTimeout, in a JSON object or a finite event stream:
+//
+//	{"events":[{"id":"42","event":"add","data":"{\"id\":7}"}]}
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	filter, err := ParseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b.serve(w, r, func(w EventWriter, lastEventID string) (func(), bool) {
+		return b.SubscribeFilter(w, lastEventID, filter)
+	})
+}
+
+// serve streams the events of subscribe to a client.
+func (b *Broker) serve(w http.ResponseWriter, r *http.Request,
+	subscribe func(w EventWriter, lastEventID string) (unsubscribe func(), complete bool),
+) {
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		// WebSocket and long-poll clients may not be able to set headers.
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	if IsLongPoll(r) {
+		b.longPoll(w, r, lastEventID, subscribe)
+		return
+	}
+
+	opts := b.options.Options
+	opts.Encoding = negotiateEncoding(r.Header.Get("Accept-Encoding"), b.encodings())
+
+	b.mu.Lock()
+	queueSize := b.options.QueueSize + len(b.replay)
+	if _, ok := b.options.Backplane.(BackplaneHistory); ok {
+		queueSize = b.options.QueueSize + b.historySize()
+	}
+	b.mu.Unlock()
+	writer, err := NewAsyncRequestWriter(w, r, opts, queueSize)
+	if err != nil {
+		b.log.Warn("sse upgrade failed", slog.Any("error", err), slog.String("remote_addr", r.RemoteAddr))
+		return
+	}
+	defer writer.Close()
+
+	unsubscribe, complete := subscribe(writer, lastEventID)
+	defer unsubscribe()
+	if !complete {
+		b.log.Warn("sse replay incomplete",
+			slog.String("last_event_id", lastEventID), slog.String("remote_addr", r.RemoteAddr))
+	}
+
+	select {
+	case <-r.Context().Done():
+	case <-writer.Done():
+	case <-b.done:
+	}
+}
+
+// encodings returns the encodings the broker may use.
+func (b *Broker) encodings() []string {
+	if b.options.Encodings == nil {
+		return negotiatedEncodings
+	}
+	return b.options.Encodings
+}
+
+// Len returns the number of subscribers.
+func (b *Broker) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// Close ends the streams of all subscribers served by ServeHTTP and rejects
+// further events with ErrClosed. It stops receiving events from the
+// backplane, but does not close it.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	closed := b.closed
+	if !closed {
+		b.closed = true
+		b.subs, b.anyTopic, b.topics = nil, nil, topicTrie{}
+		close(b.done)
+	}
+	b.mu.Unlock()
+
+	// The backplane may be delivering an event, which needs b.mu.
+	if !closed && b.cancelSubscribing != nil {
+		b.cancelSubscribing()
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// eventCollector is an EventWriter that collects events.
+type eventCollector struct {
+	events []Event
+	err    error
+}
+
+func (c *eventCollector) Write(event string, data interface{}) error {
+	m, err := newMessage(event, data)
+	if err != nil {
+		return err
+	}
+	return c.WriteEvent(Event{Event: event, Data: string(m.data)})
+}
+
+func (c *eventCollector) WriteEvent(e Event) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.events = append(c.events, e)
+	return nil
+}
+
+// ids returns the ids of the collected events.
+func (c *eventCollector) ids() []string {
+	var ids []string
+	for _, e := range c.events {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+// waitForSubscribers waits until the broker has n subscribers.
+func waitForSubscribers(t *testing.T, b *Broker, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for b.Len() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d subscribers, got %d", n, b.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBroker(t *testing.T) {
+	broker := NewBroker(BrokerOptions{})
+	defer broker.Close()
+	server := httptest.NewServer(broker)
+	defer server.Close()
+
+	var clients []*Client
+	for _, encoding := range []string{EncodeGzip, EncodeBrotli} {
+		client := NewClient(server.URL, ClientOptions{Encodings: []string{encoding}})
+		defer client.Close()
+		clients = append(clients, client)
+		go func() { _, _ = client.Next(context.Background()) }()
+	}
+	waitForSubscribers(t, broker, 2)
+	for _, client := range clients {
+		client.Close()
+	}
+	waitForSubscribers(t, broker, 0)
+
+	client := NewClient(server.URL, ClientOptions{})
+	defer client.Close()
+	events := make(chan Event)
+	go func() {
+		for {
+			event, err := client.Next(context.Background())
+			if err != nil {
+				close(events)
+				return
+			}
+			events <- event
+		}
+	}()
+	waitForSubscribers(t, broker, 1)
+
+	if err := broker.Publish("add", map[string]int{"id": 1}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := broker.PublishEvent(Event{ID: "custom", Event: "note", Data: "hello\nworld"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := broker.PublishEvent(Event{Event: "bad\nname"}); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("expected ErrInvalidEvent, got %v", err)
+	}
+
+	expected := []Event{
+		{ID: "1", Event: "add", Data: `{"id":1}`},
+		{ID: "custom", Event: "note", Data: "hello\nworld"},
+	}
+	for _, e := range expected {
+		select {
+		case event := <-events:
+			if event != e {
+				t.Errorf("expected %+v, got %+v", e, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %+v, got nothing", e)
+		}
+	}
+}
+
+func TestBroker_Replay(t *testing.T) {
+	broker := NewBroker(BrokerOptions{ReplaySize: 3})
+	for i := 0; i < 5; i++ {
+		if err := broker.Publish("tick", i); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	tests := []struct {
+		lastEventID string
+		ids         []string
+		complete    bool
+	}{
+		{"", nil, true},
+		{"5", nil, true},
+		{"3", []string{"4", "5"}, true},
+		{"1", []string{"3", "4", "5"}, false},
+	}
+	for _, tc := range tests {
+		var c eventCollector
+		unsubscribe, complete := broker.Subscribe(&c, tc.lastEventID)
+		if complete != tc.complete || !reflect.DeepEqual(c.ids(), tc.ids) {
+			t.Errorf("%q: expected %v (complete %v), got %v (complete %v)",
+				tc.lastEventID, tc.ids, tc.complete, c.ids(), complete)
+		}
+		unsubscribe()
+	}
+
+	var live eventCollector
+	unsubscribe, _ := broker.Subscribe(&live, "4")
+	defer unsubscribe()
+	if err := broker.Publish("tick", 5); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if ids := live.ids(); !reflect.DeepEqual(ids, []string{"5", "6"}) {
+		t.Errorf("expected the replay followed by live events, got %v", ids)
+	}
+}
+
+func TestBroker_FailingSubscriber(t *testing.T) {
+	broker := NewBroker(BrokerOptions{})
+	full := &eventCollector{err: ErrQueueFull}
+	failing := &eventCollector{}
+	broker.Subscribe(full, "")
+	broker.Subscribe(failing, "")
+
+	failing.err = ErrClosed
+	if err := broker.Publish("tick", 1); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if broker.Len() != 1 {
+		t.Errorf("expected the failing subscriber to be removed, got %d subscribers", broker.Len())
+	}
+}
+
+func TestBroker_Encodings(t *testing.T) {
+	tests := []struct {
+		encodings      []string
+		acceptEncoding string
+		expected       string
+	}{
+		{[]string{}, "gzip, br", ""},
+		{nil, "gzip, br;q=0.5", EncodeGzip},
+		{nil, "deflate, compress", ""},
+		{[]string{EncodeDeflate}, "deflate", EncodeDeflate},
+	}
+	for _, test := range tests {
+		broker := NewBroker(BrokerOptions{Encodings: test.encodings})
+		server := httptest.NewServer(broker)
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		res, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		res.Body.Close()
+		if encoding := res.Header.Get("Content-Encoding"); encoding != test.expected {
+			t.Errorf("%v, %q: expected encoding %q, got %q", test.encodings, test.acceptEncoding, test.expected, encoding)
+		}
+		broker.Close()
+		server.Close()
+	}
+}
+
+func TestBroker_Close(t *testing.T) {
+	broker := NewBroker(BrokerOptions{})
+	server := httptest.NewServer(broker)
+	defer server.Close()
+
+	client := NewClient(server.URL, ClientOptions{MaxRetries: -1})
+	defer client.Close()
+	done := make(chan error)
+	go func() {
+		_, err := client.Next(context.Background())
+		done <- err
+	}()
+	waitForSubscribers(t, broker, 1)
+
+	broker.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Close to end the stream")
+	}
+	if err := broker.Publish("tick", 1); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestBroker_Filter(t *testing.T) {
+	broker := NewBroker(BrokerOptions{})
+	defer broker.Close()
+	publish := []Event{
+		{Event: "add", Topic: "orders.1", Data: `{"id":1}`},
+		{Event: "add", Topic: "users.1", Data: `{"id":1}`},
+		{Event: "remove", Topic: "orders.1", Data: `{"id":1}`},
+	}
+	for _, e := range publish[:2] {
+		if err := broker.PublishEvent(e); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	filter, err := NewFilter([]string{"add"}, []string{"orders.*"}, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var c eventCollector
+	unsubscribe, _ := broker.SubscribeFilter(&c, "1", filter)
+	defer unsubscribe()
+	unsubscribe, _ = broker.SubscribeFilter(&c, "0", filter)
+	defer unsubscribe()
+	for _, e := range publish {
+		if err := broker.PublishEvent(e); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if ids := c.ids(); !reflect.DeepEqual(ids, []string{"1", "3", "3"}) {
+		t.Errorf("expected the replayed and live orders.* add events, got %v", ids)
+	}
+
+	server := httptest.NewServer(broker)
+	defer server.Close()
+	res, err := http.Get(server.URL + "?topic=orders..1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 Bad Request for an invalid filter, got %d", res.StatusCode)
+	}
+
+	client := NewClient(server.URL+"?where=id%3E1", ClientOptions{})
+	defer client.Close()
+	events := make(chan Event, 1)
+	go func() {
+		event, _ := client.Next(context.Background())
+		events <- event
+	}()
+	waitForSubscribers(t, broker, 3)
+	for _, data := range []string{`{"id":1}`, `{"id":2}`} {
+		if err := broker.PublishEvent(Event{Data: data}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	select {
+	case event := <-events:
+		if event.Data != `{"id":2}` {
+			t.Errorf("expected only the event with id 2, got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected an event, got nothing")
+	}
+}
+
+func TestBroker_Topics(t *testing.T) {
+	broker := NewBroker(BrokerOptions{})
+	defer broker.Close()
+
+	subscribe := func(topics ...string) *eventCollector {
+		t.Helper()
+		filter, err := NewFilter(nil, topics, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		var c eventCollector
+		broker.SubscribeFilter(&c, "", filter)
+		return &c
+	}
+	dashboard := subscribe("tenant.42.orders.*", "tenant.42.users.>", "tenant.42.orders.>")
+	orders := subscribe("tenant.*.orders.*")
+	all := subscribe()
+
+	for _, topic := range []string{"tenant.42.orders.1", "tenant.42.users.7.profile", "tenant.7.orders.2", "system"} {
+		if err := broker.PublishTopic(topic, "change", nil); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := broker.PublishEvent(Event{Data: "no topic"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := broker.PublishTopic("tenant.*", "change", nil); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("expected ErrInvalidEvent for a wildcard topic, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		c   *eventCollector
+		ids []string
+	}{
+		{dashboard, []string{"1", "2"}},
+		{orders, []string{"1", "3"}},
+		{all, []string{"1", "2", "3", "4", "5"}},
+	} {
+		if ids := tc.c.ids(); !reflect.DeepEqual(ids, tc.ids) {
+			t.Errorf("expected %v, got %v", tc.ids, ids)
+		}
+	}
+	if topic := dashboard.events[1].Topic; topic != "tenant.42.users.7.profile" {
+		t.Errorf("expected the concrete topic, got %q", topic)
+	}
+}
+
+func TestBroker_Envelope(t *testing.T) {
+	broker := NewBroker(BrokerOptions{Envelope: true})
+	defer broker.Close()
+	var c eventCollector
+	broker.Subscribe(&c, "")
+	for _, e := range []Event{
+		{Topic: "orders.1", Data: `{"id": 1}`},
+		{Topic: "orders.2", Data: "plain text"},
+		{Data: "no topic"},
+	} {
+		if err := broker.PublishEvent(e); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	expected := []string{
+		`{"topic":"orders.1","data":{"id":1}}`,
+		`{"topic":"orders.2","data":"plain text"}`,
+		"no topic",
+	}
+	for i, e := range c.events {
+		if e.Data != expected[i] {
+			t.Errorf("expected %s, got %s", expected[i], e.Data)
+		}
+	}
+
+	// Filters and replays see the data as published.
+	filter, _ := NewFilter(nil, nil, []string{"id=1"})
+	var replayed eventCollector
+	broker.SubscribeFilter(&replayed, "0", filter)
+	if len(replayed.events) != 1 || replayed.events[0].Data != expected[0] {
+		t.Errorf("expected the first event to be replayed in its envelope, got %+v", replayed.events)
+	}
+}
@@ -0,0 +1,343 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/floriscornel/sse/internal/slogdiscard"
+)
+
+// ErrBadResponse is returned by a Client when the server does not respond
+// with an event stream. The client does not reconnect after it.
+var ErrBadResponse = errors.New("sse: unexpected response")
+
+// Encodings lists every encoding supported by the writer, from the most to the
+// least preferred by a Client.
+var Encodings = []string{EncodeZstd, EncodeBrotli, EncodeGzip, EncodeDeflate, EncodeCompress}
+
+// ClientOptions holds configuration for a Client.
+type ClientOptions struct {
+	// HTTPClient sends the requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Header is added to every request.
+	Header http.Header
+
+	// LastEventID is sent with the first request to resume a stream.
+	LastEventID string
+
+	// Encodings are offered to the server in the Accept-Encoding header, in
+	// order of preference. If nil, all Encodings are offered. An empty,
+	// non-nil slice asks for an unencoded stream.
+	Encodings []string
+
+	// Retry is the reconnection delay until the stream sets one with a retry
+	// field. If zero, DefaultRetry is used.
+	Retry time.Duration
+
+	// MaxRetries is the number of reconnection attempts in a row that may
+	// fail before the client gives up. Zero means no limit; if negative, the
+	// client does not reconnect.
+	MaxRetries int
+
+	// Logger receives records about connections. If nil, nothing is logged.
+	Logger *slog.Logger
+}
+
+// Client reads events from a Server-Sent Events endpoint. Like EventSource,
+// it reconnects when the stream ends or the connection fails, sending the
+// last event id, and stops when the server responds with 204 No Content.
+type Client struct {
+	url     string
+	options ClientOptions
+	log     *slog.Logger
+
+	mu          sync.Mutex
+	cancel      context.CancelFunc
+	body        io.ReadCloser
+	decoder     *Decoder
+	lastEventID string
+	retry       time.Duration
+	failures    int
+	closed      bool
+}
+
+// NewClient creates a Client for the given URL. It connects on the first call
+// to Next.
+func NewClient(url string, opts ClientOptions) *Client {
+	c := &Client{
+		url:         url,
+		options:     opts,
+		log:         slogdiscard.Logger(),
+		lastEventID: opts.LastEventID,
+		retry:       opts.Retry,
+	}
+	if opts.Logger != nil {
+		c.log = opts.Logger.With(slog.String("url", url))
+	}
+	if c.retry == 0 {
+		c.retry = DefaultRetry
+	}
+	return c
+}
+
+// Next returns the next event, connecting or reconnecting as needed. It
+// returns io.EOF when the server ends the stream with 204 No Content, an error
+// wrapping ErrBadResponse when the server does not respond with an event
+// stream, and the last connection error once MaxRetries is exceeded. If ctx is
+// done, the current connection is dropped and ctx.Err() is returned; a later
+// call reconnects.
+func (c *Client) Next(ctx context.Context) (Event, error) {
+	for {
+		c.mu.Lock()
+		closed, decoder := c.closed, c.decoder
+		c.mu.Unlock()
+		if closed {
+			return Event{}, ErrClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return Event{}, err
+		}
+
+		if decoder == nil {
+			var err error
+			if decoder, err = c.connect(ctx); err != nil {
+				if ctx.Err() != nil {
+					return Event{}, ctx.Err()
+				}
+				if !c.retryable(err) {
+					return Event{}, err
+				}
+				if err := c.wait(ctx, err); err != nil {
+					return Event{}, err
+				}
+				continue
+			}
+		}
+
+		stop := context.AfterFunc(ctx, c.disconnect)
+		event, err := decoder.Decode()
+		stop()
+
+		c.mu.Lock()
+		c.lastEventID = decoder.LastEventID()
+		if retry := decoder.Retry(); retry > 0 {
+			c.retry = retry
+		}
+		if err == nil {
+			c.failures = 0
+		}
+		c.mu.Unlock()
+		if err == nil {
+			return event, nil
+		}
+
+		c.disconnect()
+		if ctx.Err() != nil {
+			return Event{}, ctx.Err()
+		}
+		c.mu.Lock()
+		closed = c.closed
+		c.mu.Unlock()
+		if closed {
+			return Event{}, ErrClosed
+		}
+		if err == io.EOF {
+			c.log.Info("sse stream ended")
+		} else {
+			c.log.Warn("sse stream failed", slog.Any("error", err))
+		}
+		if err := c.wait(ctx, err); err != nil {
+			return Event{}, err
+		}
+	}
+}
+
+// LastEventID returns the id of the last event received, which is sent when
+// reconnecting.
+func (c *Client) LastEventID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastEventID
+}
+
+// Close closes the current connection. Subsequent calls to Next return
+// ErrClosed.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.disconnect()
+	return nil
+}
+
+// connect sends a request and returns a decoder for the response.
+func (c *Client) connect(ctx context.Context) (*Decoder, error) {
+	// The connection outlives ctx, which only bounds this call to Next, so
+	// the request gets a context of its own, cancelled by disconnect.
+	connCtx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(connCtx, http.MethodGet, c.url, nil)
+	if err != nil {
+		cancel()
+		return nil, fatalError{err}
+	}
+	for name, values := range c.options.Header {
+		req.Header[name] = append([]string(nil), values...)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	encodings := c.options.Encodings
+	if encodings == nil {
+		encodings = Encodings
+	}
+	// Setting Accept-Encoding, even to identity, keeps the transport from
+	// negotiating and decoding gzip on its own.
+	req.Header.Set("Accept-Encoding", acceptEncoding(encodings))
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		cancel()
+		return nil, ErrClosed
+	}
+	c.cancel = cancel
+	lastEventID := c.lastEventID
+	c.mu.Unlock()
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	client := c.options.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	stop := context.AfterFunc(ctx, c.disconnect)
+	defer stop()
+	res, err := client.Do(req)
+	if err != nil {
+		c.disconnect()
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusNoContent {
+		res.Body.Close()
+		c.disconnect()
+		return nil, io.EOF
+	}
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if res.StatusCode != http.StatusOK || mediaType != "text/event-stream" {
+		res.Body.Close()
+		c.disconnect()
+		return nil, fmt.Errorf("%w: %s with content type %q",
+			ErrBadResponse, res.Status, res.Header.Get("Content-Type"))
+	}
+	encoding := res.Header.Get("Content-Encoding")
+	reader, err := NewDecodingReader(encoding, res.Body)
+	if err != nil {
+		res.Body.Close()
+		c.disconnect()
+		return nil, fmt.Errorf("%w: %v", ErrBadResponse, err)
+	}
+
+	decoder := NewDecoder(reader)
+	decoder.lastEventID = lastEventID
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if connCtx.Err() != nil {
+		// Closed, or ctx was done, while connecting.
+		res.Body.Close()
+		return nil, ErrClosed
+	}
+	c.body, c.decoder = res.Body, decoder
+	c.log.Info("sse stream connected",
+		slog.String("encoding", encoding), slog.String("last_event_id", lastEventID))
+	return decoder, nil
+}
+
+// retryable reports whether the client reconnects after a failed attempt to
+// connect.
+func (c *Client) retryable(err error) bool {
+	var fatal fatalError
+	return err != io.EOF && !errors.Is(err, ErrBadResponse) && !errors.Is(err, ErrClosed) &&
+		!errors.As(err, &fatal)
+}
+
+// fatalError wraps an error that retrying cannot fix, such as an invalid URL.
+type fatalError struct {
+	error
+}
+
+func (e fatalError) Unwrap() error {
+	return e.error
+}
+
+// wait counts a failure and waits for the reconnection delay, unless the
+// client must give up, in which case it returns err.
+func (c *Client) wait(ctx context.Context, err error) error {
+	c.mu.Lock()
+	c.failures++
+	failures, retry := c.failures, c.retry
+	c.mu.Unlock()
+
+	max := c.options.MaxRetries
+	if max < 0 || (max > 0 && failures > max) {
+		return err
+	}
+	c.log.Debug("sse reconnecting", slog.Duration("delay", retry), slog.Int("attempt", failures))
+
+	timer := time.NewTimer(retry)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// disconnect closes the current connection, if any.
+func (c *Client) disconnect() {
+	c.mu.Lock()
+	cancel, body := c.cancel, c.body
+	c.cancel, c.body, c.decoder = nil, nil, nil
+	c.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	if body != nil {
+		body.Close()
+	}
+}
+
+// acceptEncoding formats an Accept-Encoding header preferring the encodings in
+// order.
+func acceptEncoding(encodings []string) string {
+	var parts []string
+	for i, encoding := range encodings {
+		if encoding == EncodeNone {
+			continue
+		}
+		q := 1 - float64(i)/10
+		if q < 0.1 {
+			q = 0.1
+		}
+		if i == 0 {
+			parts = append(parts, encoding)
+		} else {
+			parts = append(parts, fmt.Sprintf("%s;q=%.1f", encoding, q))
+		}
+	}
+	if len(parts) == 0 {
+		return "identity"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,262 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// requestLog records the headers of the requests a test server receives.
+type requestLog struct {
+	mu      sync.Mutex
+	headers []http.Header
+}
+
+func (l *requestLog) add(r *http.Request) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.headers = append(l.headers, r.Header.Clone())
+	return len(l.headers) - 1
+}
+
+func (l *requestLog) get() []http.Header {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.headers
+}
+
+func TestClient(t *testing.T) {
+	var requests requestLog
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch requests.add(r) {
+		case 0:
+			writer := NewResponseWriter(w, Options{Encoding: NegotiateEncoding(r.Header.Get("Accept-Encoding"))})
+			_ = writer.Write("add", 1)
+			_ = writer.Write("add", 2)
+		case 1:
+			writer := NewResponseWriter(w, Options{}).(EventWriter)
+			_ = writer.WriteEvent(Event{Event: "remove", Data: "1"})
+			panic(http.ErrAbortHandler)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, ClientOptions{
+		Header: http.Header{"Authorization": {"Bearer token"}},
+		Retry:  time.Millisecond,
+	})
+	defer client.Close()
+
+	var events []Event
+	for {
+		event, err := client.Next(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		events = append(events, event)
+	}
+
+	expected := []Event{
+		{ID: "1", Event: "add", Data: "1"},
+		{ID: "2", Event: "add", Data: "2"},
+		{ID: "1", Event: "remove", Data: "1"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %+v", len(expected), events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("event %d: expected %+v, got %+v", i, expected[i], events[i])
+		}
+	}
+
+	headers := requests.get()
+	if len(headers) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(headers))
+	}
+	if headers[0].Get("Last-Event-ID") != "" || headers[1].Get("Last-Event-ID") != "2" ||
+		headers[2].Get("Last-Event-ID") != "1" {
+		t.Errorf("expected Last-Event-ID to follow the stream, got %q, %q and %q",
+			headers[0].Get("Last-Event-ID"), headers[1].Get("Last-Event-ID"), headers[2].Get("Last-Event-ID"))
+	}
+	if headers[0].Get("Authorization") != "Bearer token" {
+		t.Errorf("expected the custom header to be sent, got %v", headers[0])
+	}
+	if headers[0].Get("Accept") != "text/event-stream" {
+		t.Errorf("expected Accept: text/event-stream, got %q", headers[0].Get("Accept"))
+	}
+}
+
+func TestClient_Encodings(t *testing.T) {
+	for _, encoding := range append([]string{EncodeNone}, Encodings...) {
+		t.Run("Encoding "+encoding, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writer := NewResponseWriter(w, Options{Encoding: negotiateEncoding(r.Header.Get("Accept-Encoding"), Encodings)})
+				for i := 0; i < 3; i++ {
+					_ = writer.Write("tick", i)
+				}
+				<-r.Context().Done()
+			}))
+			defer server.Close()
+
+			encodings := []string{}
+			if encoding != EncodeNone {
+				encodings = []string{encoding}
+			}
+			client := NewClient(server.URL, ClientOptions{Encodings: encodings})
+			defer client.Close()
+
+			for i := 0; i < 3; i++ {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				event, err := client.Next(ctx)
+				cancel()
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if event.Event != "tick" || event.Data != string(rune('0'+i)) {
+					t.Errorf("expected tick %d, got %+v", i, event)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_BadResponse(t *testing.T) {
+	var requests requestLog
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.add(r)
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, ClientOptions{Retry: time.Millisecond})
+	if _, err := client.Next(context.Background()); !errors.Is(err, ErrBadResponse) {
+		t.Errorf("expected ErrBadResponse, got %v", err)
+	}
+	if len(requests.get()) != 1 {
+		t.Errorf("expected no reconnection, got %d requests", len(requests.get()))
+	}
+}
+
+func TestClient_MaxRetries(t *testing.T) {
+	var requests requestLog
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.add(r)
+		NewResponseWriter(w, Options{})
+		panic(http.ErrAbortHandler)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, ClientOptions{Retry: time.Millisecond, MaxRetries: 2})
+	if _, err := client.Next(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(requests.get()) != 3 {
+		t.Errorf("expected 3 requests, got %d", len(requests.get()))
+	}
+}
+
+func TestClient_Context(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		NewResponseWriter(w, Options{})
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, ClientOptions{})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.Next(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		client.Close()
+	}()
+	if _, err := client.Next(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+// roundTripFunc is an http.RoundTripper implemented by a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// trackedBody is a response body that records whether it was closed.
+type trackedBody struct {
+	io.Reader
+	closed chan struct{}
+}
+
+func (b *trackedBody) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func TestClient_ClosesBody(t *testing.T) {
+	responses := []struct {
+		status      int
+		contentType string
+		body        io.Reader
+	}{
+		{http.StatusNoContent, "", strings.NewReader("")},
+		{http.StatusNotFound, "text/plain", strings.NewReader("not found")},
+		{http.StatusOK, "text/event-stream", strings.NewReader("data: 1\n\n")},
+	}
+	for _, response := range responses {
+		body := &trackedBody{Reader: response.body, closed: make(chan struct{})}
+		transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: response.status,
+				Header:     http.Header{"Content-Type": {response.contentType}},
+				Body:       body,
+			}, nil
+		})
+		client := NewClient("http://example.com/", ClientOptions{
+			HTTPClient: &http.Client{Transport: transport},
+			MaxRetries: -1,
+		})
+		_, _ = client.Next(context.Background())
+		client.Close()
+		select {
+		case <-body.closed:
+		default:
+			t.Errorf("expected the body of the %d response to be closed", response.status)
+		}
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                            EncodeNone,
+		"identity":                    EncodeNone,
+		"gzip":                        EncodeGzip,
+		"gzip, deflate, br":           EncodeBrotli,
+		"gzip;q=1.0, br;q=0.5":        EncodeGzip,
+		"zstd;q=0, gzip;q=0.1":        EncodeGzip,
+		"*":                           EncodeZstd,
+		"BR":                          EncodeBrotli,
+		"compress, deflate;q=invalid": EncodeNone,
+		"deflate, gzip;q=0.5":         EncodeGzip,
+	}
+	for header, expected := range tests {
+		if actual := NegotiateEncoding(header); actual != expected {
+			t.Errorf("%q: expected %q, got %q", header, expected, actual)
+		}
+	}
+}
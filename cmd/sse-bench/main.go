@@ -0,0 +1,303 @@
+// Command sse-bench load tests a Server-Sent Events endpoint. It opens many
+// concurrent streams and reports the time to the first event, the event rate,
+// the end-to-end latency, the bytes received and the number of reconnections.
+//
+// Usage:
+//
+//	sse-bench [flags] URL
+//	sse-bench [flags] -self
+//
+// End-to-end latency is measured from a timestamp in the event data, which
+// must be a JSON object with the send time in Unix nanoseconds in the field
+// named by -ts-field. Events without it count towards everything else.
+//
+// With -self, sse-bench serves events itself, from an in-process server that
+// writes them with sse.NewResponseWriter, so that the library can be measured
+// for each encoding without any other moving part.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/floriscornel/sse"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "sse-bench:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// config holds the settings of a benchmark.
+type config struct {
+	url       string
+	clients   int
+	duration  time.Duration
+	ramp      time.Duration
+	encodings []string
+	tsField   string
+	retry     time.Duration
+	logger    *slog.Logger
+}
+
+// run parses the arguments, runs the benchmark and prints its summary.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("sse-bench", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: sse-bench [flags] URL | sse-bench [flags] -self")
+		flags.PrintDefaults()
+	}
+	var cfg config
+	flags.IntVar(&cfg.clients, "c", 10, "number of concurrent `clients`")
+	flags.DurationVar(&cfg.duration, "d", 10*time.Second, "`duration` of the benchmark")
+	flags.DurationVar(&cfg.ramp, "ramp", 0, "spread the start of the clients over this `duration`")
+	encoding := flags.String("encoding", sse.EncodeNone,
+		"`encoding` to accept, or a comma separated list in order of preference")
+	flags.StringVar(&cfg.tsField, "ts-field", "ts", "JSON `field` of the event data holding the send time in Unix nanoseconds")
+	flags.DurationVar(&cfg.retry, "retry", time.Second, "reconnection `delay` until the stream sets one")
+	verbose := flags.Bool("v", false, "log connections and errors to stderr")
+	self := flags.Bool("self", false, "benchmark an in-process server instead of URL")
+	rate := flags.Float64("rate", 10, "events per second per stream sent by the -self server")
+	size := flags.Int("size", 64, "payload `bytes` per event sent by the -self server")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *self == (flags.NArg() == 1) || flags.NArg() > 1 || cfg.clients < 1 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	// An empty, non-nil list asks for an unencoded stream.
+	cfg.encodings = []string{}
+	for _, e := range strings.Split(*encoding, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			cfg.encodings = append(cfg.encodings, e)
+		}
+	}
+
+	cfg.url = flags.Arg(0)
+	if *self {
+		if *rate <= 0 {
+			return fmt.Errorf("invalid rate %v", *rate)
+		}
+		server := httptest.NewServer(selfHandler(*rate, *size, cfg.tsField))
+		defer server.Close()
+		cfg.url = server.URL
+	}
+
+	if *verbose {
+		cfg.logger = slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+
+	result := bench(ctx, cfg)
+	result.print(stdout, cfg)
+	return nil
+}
+
+// selfHandler serves streams of events carrying a timestamp and a payload of
+// size bytes, at the given rate per stream, in the encoding the client prefers.
+func selfHandler(rate float64, size int, tsField string) http.Handler {
+	payload := strings.Repeat("x", size)
+	interval := time.Duration(float64(time.Second) / rate)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writer := sse.NewResponseWriter(w, sse.Options{
+			Encoding: sse.NegotiateEncoding(r.Header.Get("Accept-Encoding")),
+		})
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			data := map[string]interface{}{tsField: time.Now().UnixNano(), "payload": payload}
+			if err := writer.Write("tick", data); err != nil {
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
+
+// bench runs the clients until the duration has passed or ctx is done.
+func bench(ctx context.Context, cfg config) *result {
+	ctx, cancel := context.WithTimeout(ctx, cfg.duration)
+	defer cancel()
+
+	res := &result{}
+	transport := &countingTransport{
+		base: &http.Transport{MaxIdleConnsPerHost: cfg.clients, DisableCompression: true},
+	}
+	httpClient := &http.Client{Transport: transport}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.clients; i++ {
+		var delay time.Duration
+		if cfg.ramp > 0 {
+			delay = cfg.ramp * time.Duration(i) / time.Duration(cfg.clients)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+			res.client(ctx, cfg, httpClient)
+		}()
+	}
+	wg.Wait()
+
+	// Clients take a moment to stop; they are not measured after the end.
+	res.elapsed = min(time.Since(start), cfg.duration)
+	res.bytes = transport.bytes.Load()
+	res.requests = transport.requests.Load()
+	res.negotiated = transport.negotiated()
+	transport.base.CloseIdleConnections()
+	return res
+}
+
+// result collects the measurements of all clients.
+type result struct {
+	mu         sync.Mutex
+	elapsed    time.Duration
+	events     int64
+	bytes      int64
+	requests   int64
+	negotiated map[string]int64
+	errors     int64
+	firstEvent []time.Duration
+	latency    []time.Duration
+}
+
+// client reads events from a single stream until ctx is done.
+func (res *result) client(ctx context.Context, cfg config, httpClient *http.Client) {
+	client := sse.NewClient(cfg.url, sse.ClientOptions{
+		HTTPClient: httpClient,
+		Encodings:  cfg.encodings,
+		Retry:      cfg.retry,
+		Logger:     cfg.logger,
+	})
+	defer client.Close()
+
+	start := time.Now()
+	var (
+		events  int64
+		first   time.Duration
+		latency []time.Duration
+	)
+	for {
+		event, err := client.Next(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				res.mu.Lock()
+				res.errors++
+				res.mu.Unlock()
+			}
+			break
+		}
+		received := time.Now()
+		if events == 0 {
+			first = received.Sub(start)
+		}
+		events++
+		if sent, ok := timestamp(event.Data, cfg.tsField); ok {
+			latency = append(latency, received.Sub(sent))
+		}
+	}
+
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	res.events += events
+	if events > 0 {
+		res.firstEvent = append(res.firstEvent, first)
+	}
+	res.latency = append(res.latency, latency...)
+}
+
+// timestamp extracts the send time from event data.
+func timestamp(data, field string) (time.Time, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		return time.Time{}, false
+	}
+	var ns int64
+	if err := json.Unmarshal(fields[field], &ns); err != nil || ns <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ns), true
+}
+
+// countingTransport counts requests, the encodings of successful responses
+// and the bytes of response bodies as received, before decoding.
+type countingTransport struct {
+	base     *http.Transport
+	requests atomic.Int64
+	bytes    atomic.Int64
+
+	mu        sync.Mutex
+	encodings map[string]int64
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusOK {
+		encoding := res.Header.Get("Content-Encoding")
+		if encoding == "" {
+			encoding = "identity"
+		}
+		t.mu.Lock()
+		if t.encodings == nil {
+			t.encodings = make(map[string]int64)
+		}
+		t.encodings[encoding]++
+		t.mu.Unlock()
+	}
+	res.Body = &countingBody{ReadCloser: res.Body, bytes: &t.bytes}
+	return res, nil
+}
+
+// negotiated returns the number of responses per content encoding.
+func (t *countingTransport) negotiated() map[string]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return maps.Clone(t.encodings)
+}
+
+// countingBody counts the bytes read from a response body.
+type countingBody struct {
+	io.ReadCloser
+	bytes *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes.Add(int64(n))
+	return n, err
+}
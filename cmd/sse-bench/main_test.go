@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun_Self(t *testing.T) {
+	for _, encoding := range []string{"", "gzip", "br", "zstd"} {
+		t.Run("Encoding "+encoding, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			args := []string{"-self", "-c", "3", "-d", "200ms", "-rate", "100", "-encoding", encoding}
+			if err := run(context.Background(), args, &stdout, &stderr); err != nil {
+				t.Fatalf("expected no error, got %v (%s)", err, stderr.String())
+			}
+
+			negotiated := encoding
+			if negotiated == "" {
+				negotiated = "identity"
+			}
+			out := stdout.String()
+			for _, line := range []string{"encoding     " + negotiated + "\n", "connected    3/3", "errors       0", "latency      p50="} {
+				if !strings.Contains(out, line) {
+					t.Errorf("expected %q in output:\n%s", line, out)
+				}
+			}
+		})
+	}
+}
+
+func TestRun_Usage(t *testing.T) {
+	for _, args := range [][]string{{}, {"-self", "http://localhost"}, {"-c", "0", "http://localhost"}} {
+		var stdout, stderr bytes.Buffer
+		if err := run(context.Background(), args, &stdout, &stderr); err == nil {
+			t.Errorf("%q: expected an error", args)
+		}
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	var durations []time.Duration
+	for i := 100; i >= 1; i-- {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+	expected := "p50=50ms p90=90ms p99=99ms max=100ms"
+	if actual := percentiles(durations); actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+	if actual := percentiles(nil); actual != "n/a" {
+		t.Errorf("expected n/a, got %q", actual)
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	if ts, ok := timestamp(`{"ts":1700000000000000000,"payload":"x"}`, "ts"); !ok || ts.UnixNano() != 1700000000000000000 {
+		t.Errorf("expected the timestamp, got %v, %v", ts, ok)
+	}
+	for _, data := range []string{`{"payload":"x"}`, `{"ts":"now"}`, `plain`} {
+		if _, ok := timestamp(data, "ts"); ok {
+			t.Errorf("%q: expected no timestamp", data)
+		}
+	}
+}
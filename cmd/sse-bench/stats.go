@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// print writes a summary of the result.
+func (res *result) print(w io.Writer, cfg config) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+
+	seconds := res.elapsed.Seconds()
+	accepted := strings.Join(cfg.encodings, ",")
+	if accepted == "" {
+		accepted = "identity"
+	}
+	reconnects := res.requests - int64(cfg.clients)
+	if reconnects < 0 {
+		reconnects = 0
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "url\t%s\n", cfg.url)
+	fmt.Fprintf(tw, "clients\t%d\n", cfg.clients)
+	fmt.Fprintf(tw, "accepted\t%s\n", accepted)
+	fmt.Fprintf(tw, "encoding\t%s\n", formatEncodings(res.negotiated))
+	fmt.Fprintf(tw, "duration\t%s\n", res.elapsed.Round(time.Millisecond))
+	fmt.Fprintf(tw, "events\t%d (%.1f/s)\n", res.events, float64(res.events)/seconds)
+	fmt.Fprintf(tw, "bytes\t%s (%s/s)\n", formatBytes(float64(res.bytes)), formatBytes(float64(res.bytes)/seconds))
+	if res.events > 0 {
+		fmt.Fprintf(tw, "bytes/event\t%.1f\n", float64(res.bytes)/float64(res.events))
+	}
+	fmt.Fprintf(tw, "reconnects\t%d\n", reconnects)
+	fmt.Fprintf(tw, "errors\t%d\n", res.errors)
+	fmt.Fprintf(tw, "connected\t%d/%d\n", len(res.firstEvent), cfg.clients)
+	fmt.Fprintf(tw, "first event\t%s\n", percentiles(res.firstEvent))
+	fmt.Fprintf(tw, "latency\t%s\n", percentiles(res.latency))
+	tw.Flush()
+}
+
+// formatEncodings formats the encodings the server responded with. If the
+// responses used more than one, each is followed by its number of responses.
+func formatEncodings(counts map[string]int64) string {
+	if len(counts) == 0 {
+		return "n/a"
+	}
+	encodings := make([]string, 0, len(counts))
+	for encoding := range counts {
+		encodings = append(encodings, encoding)
+	}
+	sort.Strings(encodings)
+	if len(encodings) == 1 {
+		return encodings[0]
+	}
+	for i, encoding := range encodings {
+		encodings[i] = fmt.Sprintf("%s (%d)", encoding, counts[encoding])
+	}
+	return strings.Join(encodings, ", ")
+}
+
+// percentiles formats the median, 90th and 99th percentile and maximum of
+// durations.
+func percentiles(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "n/a"
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	at := func(p float64) time.Duration {
+		i := int(p * float64(len(sorted)-1))
+		return sorted[i].Round(time.Microsecond)
+	}
+	return fmt.Sprintf("p50=%s p90=%s p99=%s max=%s", at(0.5), at(0.9), at(0.99), at(1))
+}
+
+// formatBytes formats a number of bytes with a binary unit.
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
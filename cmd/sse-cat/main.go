@@ -0,0 +1,207 @@
+// Command sse-cat connects to a Server-Sent Events endpoint and prints its
+// events. It decodes every Content-Encoding supported by the sse package.
+//
+// Usage:
+//
+//	sse-cat [flags] URL
+//
+// Events are printed in one of three formats, chosen with -o:
+//
+//	raw     the event stream format, as sent by the server
+//	json    one indented JSON object per event
+//	ndjson  one JSON object per line
+//
+// In the JSON formats, data that is valid JSON is embedded as is; other data
+// is a string.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/floriscornel/sse"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "sse-cat:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// listFlag is a flag that can be repeated.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ", ") }
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// run parses the arguments and prints events until the stream ends, the
+// requested number of events has been printed or ctx is done.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var (
+		headers, events listFlag
+		flags           = flag.NewFlagSet("sse-cat", flag.ContinueOnError)
+	)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: sse-cat [flags] URL")
+		flags.PrintDefaults()
+	}
+	output := flags.String("o", "raw", "output `format`: raw, json or ndjson")
+	flags.Var(&events, "event", "only print events of this `type` (repeatable)")
+	flags.Var(&headers, "H", "add a request `header` such as 'Authorization: Bearer x' (repeatable)")
+	lastEventID := flags.String("last-event-id", "", "resume the stream after this `id`")
+	encodings := flags.String("encodings", strings.Join(sse.Encodings, ","),
+		"comma separated `list` of encodings to accept, in order of preference")
+	count := flags.Int("n", 0, "exit after printing this many events (0 for no limit)")
+	retry := flags.Duration("retry", sse.DefaultRetry, "reconnection delay until the stream sets one")
+	maxRetries := flags.Int("max-retries", 0, "reconnection attempts in a row before giving up (0 for no limit)")
+	noReconnect := flags.Bool("no-reconnect", false, "exit when the stream ends instead of reconnecting")
+	verbose := flags.Bool("v", false, "log connections to stderr")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	printEvent, err := printer(*output)
+	if err != nil {
+		return err
+	}
+	opts := sse.ClientOptions{
+		Header:      make(http.Header),
+		LastEventID: *lastEventID,
+		Encodings:   []string{},
+		Retry:       *retry,
+		MaxRetries:  *maxRetries,
+	}
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return fmt.Errorf("invalid header %q", header)
+		}
+		opts.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	for _, encoding := range strings.Split(*encodings, ",") {
+		if encoding = strings.TrimSpace(encoding); encoding != "" {
+			opts.Encodings = append(opts.Encodings, encoding)
+		}
+	}
+	if *noReconnect {
+		opts.MaxRetries = -1
+	}
+	if *verbose {
+		opts.Logger = slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+
+	client := sse.NewClient(flags.Arg(0), opts)
+	defer client.Close()
+	for printed := 0; *count == 0 || printed < *count; {
+		event, err := client.Next(ctx)
+		switch {
+		case err == io.EOF || (err != nil && ctx.Err() != nil):
+			return nil
+		case err != nil:
+			return err
+		}
+		if len(events) > 0 && !contains(events, eventType(event)) {
+			continue
+		}
+		if err := printEvent(stdout, event); err != nil {
+			return err
+		}
+		printed++
+	}
+	return nil
+}
+
+// eventType returns the type of an event, which is "message" if not set.
+func eventType(e sse.Event) string {
+	if e.Event == "" {
+		return "message"
+	}
+	return e.Event
+}
+
+// contains reports whether list contains s.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonEvent is the JSON representation of an event.
+type jsonEvent struct {
+	ID    string      `json:"id,omitempty"`
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+}
+
+// printer returns the function printing events in the given format.
+func printer(format string) (func(io.Writer, sse.Event) error, error) {
+	switch format {
+	case "raw":
+		return printRaw, nil
+	case "json":
+		return func(w io.Writer, e sse.Event) error {
+			return printJSON(w, e, "  ")
+		}, nil
+	case "ndjson":
+		return func(w io.Writer, e sse.Event) error {
+			return printJSON(w, e, "")
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// printRaw prints an event in the event stream format.
+func printRaw(w io.Writer, e sse.Event) error {
+	var b strings.Builder
+	if e.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", e.ID)
+	}
+	if e.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", e.Event)
+	}
+	for _, line := range strings.Split(e.Data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// printJSON prints an event as a JSON object, indented by indent.
+func printJSON(w io.Writer, e sse.Event, indent string) error {
+	var data interface{} = e.Data
+	if json.Valid([]byte(e.Data)) {
+		data = json.RawMessage(e.Data)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", indent)
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(jsonEvent{ID: e.ID, Event: eventType(e), Data: data})
+}
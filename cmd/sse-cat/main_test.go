@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/floriscornel/sse"
+)
+
+func TestRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Header.Get("Last-Event-ID") == "3" {
+			// The stream is complete.
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writer := sse.NewResponseWriter(w, sse.Options{
+			Encoding: sse.NegotiateEncoding(r.Header.Get("Accept-Encoding")),
+		}).(sse.EventWriter)
+		_ = writer.WriteEvent(sse.Event{Event: "add", Data: `{"id":1}`})
+		_ = writer.WriteEvent(sse.Event{Data: "hello\nworld"})
+		_ = writer.WriteEvent(sse.Event{Event: "remove", Data: `{"id":1}`})
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{
+			name: "Raw",
+			args: []string{"-encodings", "br"},
+			expected: "id: 1\nevent: add\ndata: {\"id\":1}\n\n" +
+				"id: 2\ndata: hello\ndata: world\n\n" +
+				"id: 3\nevent: remove\ndata: {\"id\":1}\n\n",
+		},
+		{
+			name: "NDJSON",
+			args: []string{"-o", "ndjson", "-encodings", "zstd", "-n", "3"},
+			expected: `{"id":"1","event":"add","data":{"id":1}}` + "\n" +
+				`{"id":"2","event":"message","data":"hello\nworld"}` + "\n" +
+				`{"id":"3","event":"remove","data":{"id":1}}` + "\n",
+		},
+		{
+			name:     "JSON with filter and count",
+			args:     []string{"-o", "json", "-event", "remove", "-event", "message", "-n", "1"},
+			expected: "{\n  \"id\": \"2\",\n  \"event\": \"message\",\n  \"data\": \"hello\\nworld\"\n}\n",
+		},
+		{
+			name:     "Resume after the last event",
+			args:     []string{"-last-event-id", "3"},
+			expected: "",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			args := append([]string{"-H", "X-Token: secret", "-retry", "1ms"}, tc.args...)
+			if err := run(context.Background(), append(args, server.URL), &stdout, &stderr); err != nil {
+				t.Fatalf("expected no error, got %v (%s)", err, stderr.String())
+			}
+			if stdout.String() != tc.expected {
+				t.Errorf("expected output:\n%s\ngot:\n%s", tc.expected, stdout.String())
+			}
+		})
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	for _, args := range [][]string{
+		{},
+		{"-o", "xml", server.URL},
+		{"-H", "invalid", server.URL},
+		{server.URL},
+	} {
+		var stdout, stderr bytes.Buffer
+		if err := run(context.Background(), args, &stdout, &stderr); err == nil {
+			t.Errorf("%q: expected an error", args)
+		}
+	}
+}
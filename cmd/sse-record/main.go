@@ -0,0 +1,135 @@
+// Command sse-record records the events of a Server-Sent Events stream, with
+// the time each was received, so that sse-replay can serve them again.
+//
+// Usage:
+//
+//	sse-record [flags] URL
+//
+// The recording is a JSON Lines file with one event per line:
+//
+//	{"t":1.25,"id":"42","event":"add","data":"{\"id\":1}"}
+//
+// where t is the number of seconds since the recording started. Recording
+// continues across reconnections until interrupted, or until the limits set
+// by -n or -d are reached.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/floriscornel/sse"
+	"github.com/floriscornel/sse/internal/recording"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "sse-record:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// listFlag is a flag that can be repeated.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ", ") }
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// run parses the arguments and records events until the stream ends, a limit
+// is reached or ctx is done.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var (
+		headers listFlag
+		flags   = flag.NewFlagSet("sse-record", flag.ContinueOnError)
+	)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: sse-record [flags] URL")
+		flags.PrintDefaults()
+	}
+	output := flags.String("o", "-", "write the recording to this `file` (- for standard output)")
+	flags.Var(&headers, "H", "add a request `header` such as 'Authorization: Bearer x' (repeatable)")
+	lastEventID := flags.String("last-event-id", "", "resume the stream after this `id`")
+	count := flags.Int("n", 0, "stop after recording this many events (0 for no limit)")
+	duration := flags.Duration("d", 0, "stop after this `duration` (0 for no limit)")
+	noReconnect := flags.Bool("no-reconnect", false, "stop when the stream ends instead of reconnecting")
+	verbose := flags.Bool("v", false, "log connections to stderr")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	opts := sse.ClientOptions{Header: make(http.Header), LastEventID: *lastEventID}
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return fmt.Errorf("invalid header %q", header)
+		}
+		opts.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if *noReconnect {
+		opts.MaxRetries = -1
+	}
+	if *verbose {
+		opts.Logger = slog.New(slog.NewTextHandler(stderr, nil))
+	}
+
+	out := stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	client := sse.NewClient(flags.Arg(0), opts)
+	defer client.Close()
+	writer := recording.NewWriter(out)
+	start := time.Now()
+	for recorded := 0; *count == 0 || recorded < *count; recorded++ {
+		event, err := client.Next(ctx)
+		switch {
+		case err == io.EOF || (err != nil && ctx.Err() != nil):
+			return nil
+		case err != nil:
+			return err
+		}
+		err = writer.Write(recording.Entry{
+			Time:  time.Since(start),
+			ID:    event.ID,
+			Event: event.Event,
+			Data:  event.Data,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
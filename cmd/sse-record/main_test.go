@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/floriscornel/sse"
+	"github.com/floriscornel/sse/internal/recording"
+)
+
+func TestRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writer := sse.NewResponseWriter(w, sse.Options{
+			Encoding: sse.NegotiateEncoding(r.Header.Get("Accept-Encoding")),
+		})
+		_ = writer.Write("load", []int{1, 2})
+		time.Sleep(50 * time.Millisecond)
+		_ = writer.Write("add", 3)
+		_ = writer.Write("remove", 1)
+	}))
+	defer server.Close()
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"-n", "2", server.URL}
+	if err := run(context.Background(), args, &stdout, &stderr); err != nil {
+		t.Fatalf("expected no error, got %v (%s)", err, stderr.String())
+	}
+
+	entries, err := recording.ReadAll(&stdout)
+	if err != nil {
+		t.Fatalf("expected a valid recording, got %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", entries)
+	}
+	if entries[0].ID != "1" || entries[0].Event != "load" || entries[0].Data != "[1,2]" {
+		t.Errorf("unexpected first entry %+v", entries[0])
+	}
+	if entries[1].Event != "add" || entries[1].Time-entries[0].Time < 40*time.Millisecond {
+		t.Errorf("expected the second entry to be recorded later, got %+v and %+v", entries[0], entries[1])
+	}
+}
+
+func TestRun_Duration(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writer := sse.NewResponseWriter(w, sse.Options{})
+		_ = writer.Write("load", nil)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"-d", "20ms", server.URL}
+	if err := run(context.Background(), args, &stdout, &stderr); err != nil {
+		t.Fatalf("expected no error, got %v (%s)", err, stderr.String())
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("expected no events, got %q", stdout.String())
+	}
+}
@@ -0,0 +1,130 @@
+// Command sse-relay subscribes once to an upstream Server-Sent Events stream
+// and serves its events to any number of downstream clients.
+//
+// Usage:
+//
+//	sse-relay [flags] URL
+//
+// Every downstream stream is encoded according to the client's
+// Accept-Encoding header. The relay keeps the most recent events, set by
+// -replay, so that downstream clients reconnecting with a Last-Event-ID are
+// served without reaching the upstream. The relay stops when the upstream
+// ends the stream with 204 No Content.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/floriscornel/sse"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "sse-relay:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// listFlag is a flag that can be repeated.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ", ") }
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// run parses the arguments and relays the upstream stream until it ends or
+// ctx is done.
+func run(ctx context.Context, args []string, stderr io.Writer) error {
+	var (
+		headers listFlag
+		flags   = flag.NewFlagSet("sse-relay", flag.ContinueOnError)
+	)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: sse-relay [flags] URL")
+		flags.PrintDefaults()
+	}
+	addr := flags.String("addr", "localhost:8080", "listen on this `address`")
+	flags.Var(&headers, "H", "add an upstream request `header` such as 'Authorization: Bearer x' (repeatable)")
+	replaySize := flags.Int("replay", sse.DefaultReplaySize, "number of events kept for reconnecting clients")
+	queueSize := flags.Int("queue", 64, "number of events queued for each client before it is disconnected")
+	verbose := flags.Bool("v", false, "log upstream connections and debug messages")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || *replaySize < 0 || *queueSize <= 0 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	level := slog.LevelInfo
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: level}))
+
+	opts := sse.RelayOptions{
+		Client: sse.ClientOptions{Header: make(http.Header)},
+		Broker: sse.BrokerOptions{
+			Options:    sse.Options{Logger: logger},
+			ReplaySize: *replaySize,
+			QueueSize:  *queueSize,
+		},
+	}
+	if *replaySize == 0 {
+		opts.Broker.ReplaySize = -1
+	}
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return fmt.Errorf("invalid header %q", header)
+		}
+		opts.Client.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	logger.Info("relaying stream",
+		slog.String("upstream", flags.Arg(0)), slog.String("url", "http://"+listener.Addr().String()))
+	return serve(ctx, listener, sse.NewRelay(flags.Arg(0), opts))
+}
+
+// serve runs the relay and serves it on listener until the relay stops or
+// ctx is done.
+func serve(ctx context.Context, listener net.Listener, relay *sse.Relay) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	server := &http.Server{Handler: relay}
+	done := make(chan error, 1)
+	go func() {
+		done <- relay.Run(ctx)
+		relay.Close()
+		server.Close()
+	}()
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		cancel()
+		<-done
+		return err
+	}
+	return <-done
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/floriscornel/sse"
+)
+
+func TestServe(t *testing.T) {
+	origin := sse.NewBroker(sse.BrokerOptions{})
+	defer origin.Close()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer x" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		origin.ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	relay := sse.NewRelay(upstream.URL, sse.RelayOptions{
+		Client: sse.ClientOptions{Header: http.Header{"Authorization": {"Bearer x"}}},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- serve(ctx, listener, relay) }()
+	for origin.Len() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	client := sse.NewClient("http://"+listener.Addr().String(), sse.ClientOptions{})
+	defer client.Close()
+	events := make(chan sse.Event, 1)
+	go func() {
+		event, _ := client.Next(context.Background())
+		events <- event
+	}()
+	for relay.Len() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := origin.Publish("add", 1); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	select {
+	case event := <-events:
+		if event.Event != "add" || event.Data != "1" {
+			t.Errorf("expected the upstream event, got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the upstream event, got nothing")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected serve to stop")
+	}
+}
+
+func TestServe_UpstreamEnds(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	relay := sse.NewRelay(upstream.URL, sse.RelayOptions{})
+	if err := serve(context.Background(), listener, relay); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestRun_Usage(t *testing.T) {
+	for _, args := range [][]string{nil, {"-queue", "0", "http://localhost"}} {
+		var stderr bytes.Buffer
+		if err := run(context.Background(), args, &stderr); !errors.Is(err, flag.ErrHelp) {
+			t.Errorf("%q: expected flag.ErrHelp, got %v", args, err)
+		}
+	}
+}
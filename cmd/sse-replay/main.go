@@ -0,0 +1,173 @@
+// Command sse-replay serves a recording made by sse-record as a Server-Sent
+// Events endpoint, so that clients can be developed against a recorded feed.
+//
+// Usage:
+//
+//	sse-replay [flags] FILE
+//
+// Every connection replays the recording from the start, with the recorded
+// timing scaled by -speed. A client reconnecting with a Last-Event-ID that
+// occurs in the recording resumes after that event. Once the recording is
+// done, the stream is held open, unless -loop or -close is given.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/floriscornel/sse"
+	"github.com/floriscornel/sse/internal/recording"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "sse-replay:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// run parses the arguments and serves the recording until ctx is done.
+func run(ctx context.Context, args []string, stderr io.Writer) error {
+	flags := flag.NewFlagSet("sse-replay", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: sse-replay [flags] FILE")
+		flags.PrintDefaults()
+	}
+	addr := flags.String("addr", "localhost:8080", "listen on this `address`")
+	var opts replayOptions
+	flags.Float64Var(&opts.speed, "speed", 1, "replay speed: 2 is twice as fast, 0 sends all events at once")
+	flags.StringVar(&opts.encoding, "encoding", "auto",
+		"Content-Encoding of the streams, or auto to negotiate it with Accept-Encoding")
+	flags.BoolVar(&opts.loop, "loop", false, "replay the recording again once it is done")
+	flags.BoolVar(&opts.close, "close", false, "end the stream once the recording is done")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || opts.speed < 0 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	entries, err := recording.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", flags.Arg(0), err)
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	logger := slog.New(slog.NewTextHandler(stderr, nil))
+	logger.Info("replaying recording",
+		slog.String("file", flags.Arg(0)), slog.Int("events", len(entries)),
+		slog.String("url", "http://"+listener.Addr().String()))
+
+	opts.logger = logger
+	server := &http.Server{Handler: newHandler(entries, opts)}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// replayOptions holds the settings of a replay.
+type replayOptions struct {
+	speed    float64
+	encoding string
+	loop     bool
+	close    bool
+	logger   *slog.Logger
+}
+
+// newHandler returns the handler replaying entries.
+func newHandler(entries []recording.Entry, opts replayOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := opts.encoding
+		if encoding == "auto" {
+			encoding = sse.NegotiateEncoding(r.Header.Get("Accept-Encoding"))
+		}
+		writer := sse.NewResponseWriterContext(r.Context(), w, sse.Options{
+			Encoding: encoding,
+			Logger:   opts.logger,
+		}).(sse.CloseWriter)
+		defer writer.Close()
+
+		ctx := r.Context()
+		remaining := resume(entries, r.Header.Get("Last-Event-ID"))
+		for {
+			if err := replay(ctx, writer.(sse.EventWriter), remaining, opts.speed); err != nil {
+				return
+			}
+			// An empty recording would loop without ever waiting.
+			if !opts.loop || len(entries) == 0 || ctx.Err() != nil {
+				break
+			}
+			remaining = entries
+		}
+		if !opts.close {
+			<-ctx.Done()
+		}
+	})
+}
+
+// resume returns the entries after the one with the given id, or all entries
+// if there is no such entry.
+func resume(entries []recording.Entry, lastEventID string) []recording.Entry {
+	if lastEventID == "" {
+		return entries
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].ID == lastEventID {
+			return entries[i+1:]
+		}
+	}
+	return entries
+}
+
+// replay writes entries with their recorded timing, relative to the first
+// one, scaled by speed.
+func replay(ctx context.Context, writer sse.EventWriter, entries []recording.Entry, speed float64) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	start, origin := time.Now(), entries[0].Time
+	for _, e := range entries {
+		if speed > 0 {
+			due := start.Add(time.Duration(float64(e.Time-origin) / speed))
+			timer := time.NewTimer(time.Until(due))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+		if err := writer.WriteEvent(sse.Event{ID: e.ID, Event: e.Event, Data: e.Data}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/floriscornel/sse"
+	"github.com/floriscornel/sse/internal/recording"
+)
+
+var entries = []recording.Entry{
+	{Time: 1 * time.Second, ID: "a", Event: "load", Data: "[]"},
+	{Time: 1050 * time.Millisecond, ID: "b", Event: "add", Data: "1"},
+	{Time: 1100 * time.Millisecond, ID: "c", Event: "add", Data: "2"},
+}
+
+// next reads an event from the client, failing the test after a second.
+func next(t *testing.T, client *sse.Client) sse.Event {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	event, err := client.Next(ctx)
+	if err != nil {
+		t.Fatalf("expected an event, got %v", err)
+	}
+	return event
+}
+
+func TestHandler(t *testing.T) {
+	server := httptest.NewServer(newHandler(entries, replayOptions{speed: 1, encoding: "auto"}))
+	defer server.Close()
+
+	client := sse.NewClient(server.URL, sse.ClientOptions{Encodings: []string{sse.EncodeBrotli}})
+	defer client.Close()
+	start := time.Now()
+	for _, e := range entries {
+		event := next(t, client)
+		if event.ID != e.ID || event.Event != e.Event || event.Data != e.Data {
+			t.Errorf("expected %+v, got %+v", e, event)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("expected the recorded timing to be kept, took %v", elapsed)
+	}
+}
+
+func TestHandler_SpeedAndResume(t *testing.T) {
+	server := httptest.NewServer(newHandler(entries, replayOptions{speed: 0, encoding: sse.EncodeGzip, loop: true}))
+	defer server.Close()
+
+	client := sse.NewClient(server.URL, sse.ClientOptions{LastEventID: "b"})
+	defer client.Close()
+	for _, id := range []string{"c", "a", "b", "c", "a"} {
+		if event := next(t, client); event.ID != id {
+			t.Errorf("expected event %s, got %+v", id, event)
+		}
+	}
+}
+
+func TestHandler_LoopEmpty(t *testing.T) {
+	handler := newHandler(nil, replayOptions{speed: 1, loop: true})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the handler to return once the client is gone")
+	}
+}
+
+func TestResume(t *testing.T) {
+	tests := map[string]int{"": 3, "a": 2, "c": 0, "unknown": 3}
+	for id, expected := range tests {
+		if actual := len(resume(entries, id)); actual != expected {
+			t.Errorf("%q: expected %d entries, got %d", id, expected, actual)
+		}
+	}
+}
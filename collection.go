@@ -0,0 +1,251 @@
+package sse
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Event types of a Collection, besides SnapshotEvent.
+const (
+	// UpsertEvent carries an item that was added or changed:
+	// {"key":...,"value":...}.
+	UpsertEvent = "upsert"
+	// DeleteEvent carries the key of an item that was deleted: {"key":...}.
+	DeleteEvent = "delete"
+)
+
+// CollectionOptions holds configuration for a Collection.
+type CollectionOptions struct {
+	// Broker configures the fan-out to clients. Its replay buffer holds the
+	// recent changes, so that reconnecting clients receive the changes they
+	// missed instead of a snapshot. A Backplane is not supported: the
+	// versions are local to the collection, and backplanes may replace ids.
+	// NewCollection returns ErrBackplaneUnsupported if one is set.
+	Broker BrokerOptions
+}
+
+// Collection streams a set of keyed items to any number of clients. A client
+// receives a snapshot event with every item when it connects, followed by an
+// upsert or delete event for each change. Each event's id is the version of
+// the collection, so a client reconnecting with a Last-Event-ID receives only
+// the changes since its version. If they are no longer buffered, or if they
+// outnumber the items, it receives a new snapshot instead. Versions are
+// prefixed with an epoch chosen when the collection is created, so that a
+// client that saw a collection of an earlier process receives a snapshot.
+//
+// The snapshot data is an array of items: [{"key":...,"value":...}, ...].
+// Keys and values are encoded with encoding/json. Use CollectionState to
+// rebuild the collection on a Go client.
+type Collection[K comparable, V any] struct {
+	broker *Broker
+	ids    versionIDs
+
+	mu      sync.Mutex
+	items   map[K]V
+	encoded map[K]collectionItem
+	version uint64
+}
+
+// collectionItem is the JSON representation of an item.
+type collectionItem struct {
+	Key   json.RawMessage `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// NewCollection creates an empty Collection at version 0. It returns
+// ErrBackplaneUnsupported if opts.Broker sets a Backplane.
+func NewCollection[K comparable, V any](opts CollectionOptions) (*Collection[K, V], error) {
+	if opts.Broker.Backplane != nil {
+		return nil, ErrBackplaneUnsupported
+	}
+	return &Collection[K, V]{
+		broker:  NewBroker(opts.Broker),
+		ids:     newVersionIDs(),
+		items:   make(map[K]V),
+		encoded: make(map[K]collectionItem),
+	}, nil
+}
+
+// Upsert adds or replaces the item with the given key and sends it to every
+// client. If the item did not change, nothing is sent.
+func (c *Collection[K, V]) Upsert(key K, value V) error {
+	k, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	item := collectionItem{Key: k, Value: v}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.encoded[key]; ok && bytes.Equal(old.Value, item.Value) {
+		c.items[key] = value
+		return nil
+	}
+	if err := c.publish(UpsertEvent, item); err != nil {
+		return err
+	}
+	c.items[key], c.encoded[key] = value, item
+	return nil
+}
+
+// Delete deletes the item with the given key and tells every client. If
+// there is no such item, nothing is sent.
+func (c *Collection[K, V]) Delete(key K) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.encoded[key]
+	if !ok {
+		return nil
+	}
+	if err := c.publish(DeleteEvent, collectionItem{Key: item.Key}); err != nil {
+		return err
+	}
+	delete(c.items, key)
+	delete(c.encoded, key)
+	return nil
+}
+
+// publish sends a change as the next version. The caller must hold c.mu.
+func (c *Collection[K, V]) publish(event string, item collectionItem) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	version := c.ids.id(c.version + 1)
+	if err := c.broker.PublishEvent(Event{ID: version, Event: event, Data: string(data)}); err != nil {
+		return err
+	}
+	c.version++
+	return nil
+}
+
+// Get returns the item with the given key.
+func (c *Collection[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.items[key]
+	return value, ok
+}
+
+// Snapshot returns a copy of the items and the version they are at.
+func (c *Collection[K, V]) Snapshot() (map[K]V, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	items := make(map[K]V, len(c.items))
+	for key, value := range c.items {
+		items[key] = value
+	}
+	return items, c.version
+}
+
+// snapshot returns the snapshot event. The caller must hold c.mu.
+func (c *Collection[K, V]) snapshot() Event {
+	items := make([]collectionItem, 0, len(c.encoded))
+	for _, item := range c.encoded {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return bytes.Compare(items[i].Key, items[j].Key) < 0
+	})
+	data, _ := json.Marshal(items)
+	return Event{ID: c.ids.id(c.version), Event: SnapshotEvent, Data: string(data)}
+}
+
+// subscribe subscribes w to the changes after lastEventID, starting with a
+// snapshot if they are not all buffered or outnumber the items.
+func (c *Collection[K, V]) subscribe(w EventWriter, lastEventID string) (func(), bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	unsubscribe, _ := c.broker.subscribe(w, lastEventID, nil, resume(c.ids, lastEventID, c.version, len(c.items), c.snapshot))
+	return unsubscribe, true
+}
+
+// ServeHTTP streams the collection to a client.
+func (c *Collection[K, V]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.broker.serve(w, r, c.subscribe)
+}
+
+// Len returns the number of clients.
+func (c *Collection[K, V]) Len() int {
+	return c.broker.Len()
+}
+
+// Close ends the streams of all clients and rejects further changes with
+// ErrClosed.
+func (c *Collection[K, V]) Close() error {
+	return c.broker.Close()
+}
+
+// CollectionState rebuilds the items of a Collection from its events. The
+// zero value is ready to use. A CollectionState is not safe for concurrent
+// use.
+type CollectionState[K comparable, V any] struct {
+	items   map[K]V
+	version string
+}
+
+// Apply applies a snapshot, upsert or delete event and reports whether it
+// changed the items. Other events are ignored. If the event cannot be
+// applied, the items are left as they were and an error is returned.
+func (s *CollectionState[K, V]) Apply(e Event) (bool, error) {
+	switch e.Event {
+	case SnapshotEvent:
+		var items []struct {
+			Key   K `json:"key"`
+			Value V `json:"value"`
+		}
+		if err := json.Unmarshal([]byte(e.Data), &items); err != nil {
+			return false, err
+		}
+		s.items = make(map[K]V, len(items))
+		for _, item := range items {
+			s.items[item.Key] = item.Value
+		}
+	case UpsertEvent:
+		if s.items == nil {
+			return false, ErrNoSnapshot
+		}
+		var item struct {
+			Key   K `json:"key"`
+			Value V `json:"value"`
+		}
+		if err := json.Unmarshal([]byte(e.Data), &item); err != nil {
+			return false, err
+		}
+		s.items[item.Key] = item.Value
+	case DeleteEvent:
+		if s.items == nil {
+			return false, ErrNoSnapshot
+		}
+		var item struct {
+			Key K `json:"key"`
+		}
+		if err := json.Unmarshal([]byte(e.Data), &item); err != nil {
+			return false, err
+		}
+		delete(s.items, item.Key)
+	default:
+		return false, nil
+	}
+	s.version = e.ID
+	return true, nil
+}
+
+// Items returns the items. The map belongs to the CollectionState and must
+// not be modified.
+func (s *CollectionState[K, V]) Items() map[K]V {
+	return s.items
+}
+
+// Version returns the id of the last event applied, which a client passes as
+// its Last-Event-ID to resume the stream.
+func (s *CollectionState[K, V]) Version() string {
+	return s.version
+}
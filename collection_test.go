@@ -0,0 +1,205 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// player is the item used in tests.
+type player struct {
+	Name  string `json:"name"`
+	Score int    `json:"score"`
+}
+
+func TestCollection(t *testing.T) {
+	players, err := NewCollection[int, player](CollectionOptions{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer players.Close()
+	if err := players.Upsert(2, player{"bob", 1}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := players.Upsert(1, player{"alice", 3}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	server := httptest.NewServer(players)
+	defer server.Close()
+
+	client := NewClient(server.URL, ClientOptions{})
+	defer client.Close()
+	var state CollectionState[int, player]
+	next := func() Event {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		event, err := client.Next(ctx)
+		if err != nil {
+			t.Fatalf("expected an event, got %v", err)
+		}
+		if _, err := state.Apply(event); err != nil {
+			t.Fatalf("expected %+v to apply, got %v", event, err)
+		}
+		return event
+	}
+
+	id := players.ids.id
+	expected := Event{
+		ID:    id(2),
+		Event: SnapshotEvent,
+		Data:  `[{"key":1,"value":{"name":"alice","score":3}},{"key":2,"value":{"name":"bob","score":1}}]`,
+	}
+	if event := next(); event != expected {
+		t.Errorf("expected %+v, got %+v", expected, event)
+	}
+
+	for _, change := range []func() error{
+		func() error { return players.Upsert(2, player{"bob", 1}) },
+		func() error { return players.Upsert(2, player{"bob", 5}) },
+		func() error { return players.Delete(3) },
+		func() error { return players.Delete(1) },
+	} {
+		if err := change(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	for _, expected := range []Event{
+		{ID: id(3), Event: UpsertEvent, Data: `{"key":2,"value":{"name":"bob","score":5}}`},
+		{ID: id(4), Event: DeleteEvent, Data: `{"key":1}`},
+	} {
+		if event := next(); event != expected {
+			t.Errorf("expected %+v, got %+v", expected, event)
+		}
+	}
+
+	items, version := players.Snapshot()
+	if !reflect.DeepEqual(state.Items(), items) || state.Version() != id(4) || version != 4 {
+		t.Errorf("expected %v at version 4, got %v at %q", items, state.Items(), state.Version())
+	}
+	if value, ok := players.Get(2); !ok || value.Score != 5 {
+		t.Errorf("expected bob to have a score of 5, got %+v", value)
+	}
+}
+
+func TestCollection_Resume(t *testing.T) {
+	players, err := NewCollection[string, int](CollectionOptions{Broker: BrokerOptions{ReplaySize: 4}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for i, name := range []string{"a", "b", "c", "d"} {
+		if err := players.Upsert(name, i); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := players.Delete("a"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	id := players.ids.id
+	snapshot := Event{ID: id(5), Event: SnapshotEvent, Data: `[{"key":"b","value":1},{"key":"c","value":2},{"key":"d","value":3}]`}
+	tests := []struct {
+		lastEventID string
+		events      []Event
+	}{
+		{"", []Event{snapshot}},
+		{id(5), nil},
+		{id(4), []Event{{ID: id(5), Event: DeleteEvent, Data: `{"key":"a"}`}}},
+		{id(2), []Event{
+			{ID: id(3), Event: UpsertEvent, Data: `{"key":"c","value":2}`},
+			{ID: id(4), Event: UpsertEvent, Data: `{"key":"d","value":3}`},
+			{ID: id(5), Event: DeleteEvent, Data: `{"key":"a"}`},
+		}},
+		{id(1), []Event{snapshot}},
+	}
+	for _, tc := range tests {
+		var c eventCollector
+		unsubscribe, _ := players.subscribe(&c, tc.lastEventID)
+		if !reflect.DeepEqual(c.events, tc.events) {
+			t.Errorf("%q: expected %+v, got %+v", tc.lastEventID, tc.events, c.events)
+		}
+		unsubscribe()
+	}
+
+	// A gap larger than the collection is served with a snapshot.
+	for _, name := range []string{"b", "c"} {
+		if err := players.Delete(name); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	var c eventCollector
+	players.subscribe(&c, id(5))
+	expected := []Event{{ID: id(7), Event: SnapshotEvent, Data: `[{"key":"d","value":3}]`}}
+	if !reflect.DeepEqual(c.events, expected) {
+		t.Errorf("expected %+v, got %+v", expected, c.events)
+	}
+}
+
+func TestCollection_Restart(t *testing.T) {
+	before, err := NewCollection[string, int](CollectionOptions{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	after, err := NewCollection[string, int](CollectionOptions{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer after.Close()
+	for i, name := range []string{"a", "b"} {
+		if err := before.Upsert(name, i); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		// The collection of a new process reaches the same version with
+		// different items.
+		if err := after.Upsert(name, 10*i+10); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	var c eventCollector
+	before.subscribe(&c, "")
+	before.Close()
+
+	lastEventID := c.events[0].ID
+	c = eventCollector{}
+	after.subscribe(&c, lastEventID)
+	expected := []Event{{ID: after.ids.id(2), Event: SnapshotEvent, Data: `[{"key":"a","value":10},{"key":"b","value":20}]`}}
+	if !reflect.DeepEqual(c.events, expected) {
+		t.Errorf("%q: expected %+v, got %+v", lastEventID, expected, c.events)
+	}
+}
+
+func TestCollection_Backplane(t *testing.T) {
+	_, err := NewCollection[string, int](CollectionOptions{Broker: BrokerOptions{Backplane: &Loopback{}}})
+	if !errors.Is(err, ErrBackplaneUnsupported) {
+		t.Errorf("expected ErrBackplaneUnsupported, got %v", err)
+	}
+}
+
+func TestCollectionState_Apply(t *testing.T) {
+	var state CollectionState[string, int]
+	if _, err := state.Apply(Event{Event: UpsertEvent, Data: `{"key":"a","value":1}`}); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("expected ErrNoSnapshot, got %v", err)
+	}
+	if changed, err := state.Apply(Event{Event: "other"}); changed || err != nil {
+		t.Errorf("expected other events to be ignored, got %v, %v", changed, err)
+	}
+	for _, e := range []Event{
+		{ID: "1", Event: SnapshotEvent, Data: `[]`},
+		{ID: "2", Event: UpsertEvent, Data: `{"key":"a","value":1}`},
+		{ID: "3", Event: UpsertEvent, Data: `{"key":"b","value":2}`},
+		{ID: "4", Event: DeleteEvent, Data: `{"key":"a"}`},
+	} {
+		if _, err := state.Apply(e); err != nil {
+			t.Fatalf("expected %+v to apply, got %v", e, err)
+		}
+	}
+	if _, err := state.Apply(Event{ID: "5", Event: UpsertEvent, Data: `{"key":1}`}); err == nil {
+		t.Error("expected an error for a key of the wrong type")
+	}
+	if !reflect.DeepEqual(state.Items(), map[string]int{"b": 2}) || state.Version() != "4" {
+		t.Errorf("expected b at version 4, got %v at %q", state.Items(), state.Version())
+	}
+}
@@ -0,0 +1,432 @@
+package sse
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// parserConformance holds streams and the events the WHATWG "interpret an
+// event stream" algorithm dispatches for them, along with the last event id
+// and reconnection time it leaves behind.
+var parserConformance = []struct {
+	name        string
+	stream      string
+	events      []Event
+	lastEventID string
+	retry       time.Duration
+}{
+	{
+		name:   "Data field",
+		stream: "data: hello\n\n",
+		events: []Event{{Data: "hello"}},
+	},
+	{
+		name:   "Field without colon has an empty value",
+		stream: "data\n\n",
+		events: []Event{{Data: ""}},
+	},
+	{
+		name:   "Only one leading space is stripped",
+		stream: "data:no space\n\ndata:  two spaces\n\ndata:\ttab\n\n",
+		events: []Event{{Data: "no space"}, {Data: " two spaces"}, {Data: "\ttab"}},
+	},
+	{
+		name:   "Colons after the first belong to the value",
+		stream: "data: a:b: c\n\n",
+		events: []Event{{Data: "a:b: c"}},
+	},
+	{
+		name:   "Field names are case sensitive",
+		stream: "Data: ignored\nDATA: ignored\n\n",
+	},
+	{
+		name:   "Unknown fields are ignored",
+		stream: "foo: bar\ndata: kept\nbaz\n\n",
+		events: []Event{{Data: "kept"}},
+	},
+	{
+		name:   "Field name with leading space is unknown",
+		stream: " data: ignored\n\n",
+	},
+	{
+		name:   "Comments are ignored",
+		stream: ": comment\n:\ndata: a\n: between\ndata: b\n\n",
+		events: []Event{{Data: "a\nb"}},
+	},
+	{
+		name:   "Data lines are joined with LF",
+		stream: "data: a\ndata: b\ndata\ndata: c\n\n",
+		events: []Event{{Data: "a\nb\n\nc"}},
+	},
+	{
+		name:   "Empty data line",
+		stream: "data:\n\n",
+		events: []Event{{Data: ""}},
+	},
+	{
+		name:   "Two empty data lines",
+		stream: "data:\ndata:\n\n",
+		events: []Event{{Data: "\n"}},
+	},
+	{
+		name:        "Block without data is not dispatched",
+		stream:      "event: add\nid: 1\n\ndata: a\n\n",
+		events:      []Event{{ID: "1", Data: "a"}},
+		lastEventID: "1",
+	},
+	{
+		name:   "Event type",
+		stream: "event: add\ndata: a\n\n",
+		events: []Event{{Event: "add", Data: "a"}},
+	},
+	{
+		name:   "Last event type wins",
+		stream: "event: add\nevent: remove\ndata: a\n\n",
+		events: []Event{{Event: "remove", Data: "a"}},
+	},
+	{
+		name:   "Event type is reset after dispatch",
+		stream: "event: add\ndata: a\n\ndata: b\n\n",
+		events: []Event{{Event: "add", Data: "a"}, {Data: "b"}},
+	},
+	{
+		name:   "Empty event type",
+		stream: "event\ndata: a\n\n",
+		events: []Event{{Data: "a"}},
+	},
+	{
+		name:        "Id persists across events",
+		stream:      "id: 1\ndata: a\n\ndata: b\n\n",
+		events:      []Event{{ID: "1", Data: "a"}, {ID: "1", Data: "b"}},
+		lastEventID: "1",
+	},
+	{
+		name:   "Empty id resets the last event id",
+		stream: "id: 1\ndata: a\n\nid\ndata: b\n\n",
+		events: []Event{{ID: "1", Data: "a"}, {ID: "", Data: "b"}},
+	},
+	{
+		name:        "Id containing NUL is ignored",
+		stream:      "id: 1\ndata: a\n\nid: 2\x003\ndata: b\n\n",
+		events:      []Event{{ID: "1", Data: "a"}, {ID: "1", Data: "b"}},
+		lastEventID: "1",
+	},
+	{
+		name:        "Id keeps surrounding spaces but the first",
+		stream:      "id:  a b \ndata: x\n\n",
+		events:      []Event{{ID: " a b ", Data: "x"}},
+		lastEventID: " a b ",
+	},
+	{
+		name:        "Id of a block without data still applies",
+		stream:      "id: 7\n\n",
+		lastEventID: "7",
+	},
+	{
+		name:   "Retry in milliseconds",
+		stream: "retry: 2500\n\n",
+		retry:  2500 * time.Millisecond,
+	},
+	{
+		name:   "Retry without space",
+		stream: "retry:10\n\n",
+		retry:  10 * time.Millisecond,
+	},
+	{
+		name:   "Retry with non-digits is ignored",
+		stream: "retry: 1000\n\nretry: 1.5\nretry: -1\nretry: 1e3\nretry: 12a\nretry:  5\nretry\nretry:\n\n",
+		retry:  time.Second,
+	},
+	{
+		name:   "Retry does not dispatch",
+		stream: "retry: 1\ndata: a\n\n",
+		events: []Event{{Data: "a"}},
+		retry:  time.Millisecond,
+	},
+	{
+		name:   "Leading BOM is skipped",
+		stream: "\uFEFFdata: a\n\n",
+		events: []Event{{Data: "a"}},
+	},
+	{
+		name:   "Only one BOM is skipped",
+		stream: "\uFEFF\uFEFFdata: a\n\ndata: b\n\n",
+		events: []Event{{Data: "b"}},
+	},
+	{
+		name:   "BOM after the start is data",
+		stream: "data: \uFEFFa\n\n",
+		events: []Event{{Data: "\uFEFFa"}},
+	},
+	{
+		name:   "CR line endings",
+		stream: "event: add\rdata: a\rdata: b\r\r",
+		events: []Event{{Event: "add", Data: "a\nb"}},
+	},
+	{
+		name:   "CRLF line endings",
+		stream: "event: add\r\ndata: a\r\ndata: b\r\n\r\n",
+		events: []Event{{Event: "add", Data: "a\nb"}},
+	},
+	{
+		name:   "Mixed line endings",
+		stream: "data: a\rdata: b\ndata: c\r\n\ndata: d\r\r\n",
+		events: []Event{{Data: "a\nb\nc"}, {Data: "d"}},
+	},
+	{
+		name:   "CR followed by CRLF is two lines",
+		stream: "data: a\r\r\ndata: b\n\n",
+		events: []Event{{Data: "a"}, {Data: "b"}},
+	},
+	{
+		name:   "Blank line dispatches",
+		stream: "data: a\n\n\n\ndata: b\n\n",
+		events: []Event{{Data: "a"}, {Data: "b"}},
+	},
+	{
+		name:   "Incomplete event at end of stream is discarded",
+		stream: "data: a\n\ndata: b\n",
+		events: []Event{{Data: "a"}},
+	},
+	{
+		name:   "Unterminated line at end of stream is discarded",
+		stream: "data: a\n\ndata: b",
+		events: []Event{{Data: "a"}},
+	},
+	{
+		name:   "Empty stream",
+		stream: "",
+	},
+	{
+		name:   "Traceparent field",
+		stream: "traceparent: 00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01\ndata: a\n\n",
+		events: []Event{{Data: "a", TraceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}},
+	},
+	{
+		name:   "Topic field",
+		stream: "topic: orders.42\ndata: a\n\ndata: b\n\n",
+		events: []Event{{Data: "a", Topic: "orders.42"}, {Data: "b"}},
+	},
+}
+
+func TestConformance_Decoder(t *testing.T) {
+	for _, tc := range parserConformance {
+		t.Run(tc.name, func(t *testing.T) {
+			decoder := NewDecoder(strings.NewReader(tc.stream))
+			events := decodeAll(t, decoder)
+			if !reflect.DeepEqual(events, tc.events) {
+				t.Errorf("expected events: %+v, got: %+v", tc.events, events)
+			}
+			if decoder.LastEventID() != tc.lastEventID {
+				t.Errorf("expected last event id %q, got %q", tc.lastEventID, decoder.LastEventID())
+			}
+			if decoder.Retry() != tc.retry {
+				t.Errorf("expected retry %v, got %v", tc.retry, decoder.Retry())
+			}
+		})
+	}
+}
+
+// writerConformance holds events, the stream the writer produces for them and
+// the event a conforming parser reads back from that stream.
+var writerConformance = []struct {
+	name    string
+	event   Event
+	stream  string
+	decoded Event
+}{
+	{
+		name:    "Data",
+		event:   Event{Data: "hello"},
+		stream:  "id: 1\ndata: hello\n\n",
+		decoded: Event{ID: "1", Data: "hello"},
+	},
+	{
+		name:    "Event type and id",
+		event:   Event{ID: "a-1", Event: "add", Data: "x"},
+		stream:  "id: a-1\nevent: add\ndata: x\n\n",
+		decoded: Event{ID: "a-1", Event: "add", Data: "x"},
+	},
+	{
+		name:    "Topic",
+		event:   Event{ID: "7", Event: "add", Topic: "orders.42", Data: "x"},
+		stream:  "id: 7\nevent: add\ntopic: orders.42\ndata: x\n\n",
+		decoded: Event{ID: "7", Event: "add", Topic: "orders.42", Data: "x"},
+	},
+	{
+		name:    "Empty data",
+		event:   Event{},
+		stream:  "id: 1\ndata: \n\n",
+		decoded: Event{ID: "1"},
+	},
+	{
+		name:    "Multi-line data",
+		event:   Event{Data: "a\nb\n"},
+		stream:  "id: 1\ndata: a\ndata: b\ndata: \n\n",
+		decoded: Event{ID: "1", Data: "a\nb\n"},
+	},
+	{
+		name:    "CR and CRLF in data",
+		event:   Event{Data: "a\rb\r\nc"},
+		stream:  "id: 1\ndata: a\ndata: b\ndata: c\n\n",
+		decoded: Event{ID: "1", Data: "a\nb\nc"},
+	},
+	{
+		name:    "Leading spaces are kept",
+		event:   Event{ID: " 1", Event: " add", Data: " x"},
+		stream:  "id:  1\nevent:  add\ndata:  x\n\n",
+		decoded: Event{ID: " 1", Event: " add", Data: " x"},
+	},
+	{
+		name:    "Colons in data",
+		event:   Event{Data: ": not a comment\ndata: x"},
+		stream:  "id: 1\ndata: : not a comment\ndata: data: x\n\n",
+		decoded: Event{ID: "1", Data: ": not a comment\ndata: x"},
+	},
+}
+
+func TestConformance_Writer(t *testing.T) {
+	for _, tc := range writerConformance {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writer := NewResponseWriter(rec, Options{}).(EventWriter)
+			if err := writer.WriteEvent(tc.event); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if rec.Body.String() != tc.stream {
+				t.Errorf("expected stream %q, got %q", tc.stream, rec.Body.String())
+			}
+
+			events := decodeAll(t, NewDecoder(strings.NewReader(tc.stream)))
+			if len(events) != 1 || events[0] != tc.decoded {
+				t.Errorf("expected to decode %+v, got %+v", tc.decoded, events)
+			}
+		})
+	}
+}
+
+func TestConformance_WriterRejectsInvalidEvents(t *testing.T) {
+	events := []Event{
+		{ID: "1\n2"},
+		{ID: "1\r2"},
+		{ID: "1\x002"},
+		{Event: "a\nb"},
+		{Event: "a\rb"},
+		{Topic: "a\nb"},
+	}
+	for _, e := range events {
+		writer := NewResponseWriter(httptest.NewRecorder(), Options{}).(EventWriter)
+		if err := writer.WriteEvent(e); !errors.Is(err, ErrInvalidEvent) {
+			t.Errorf("expected ErrInvalidEvent for %+v, got %v", e, err)
+		}
+	}
+
+	writer := NewResponseWriter(httptest.NewRecorder(), Options{})
+	if err := writer.Write("a\nb", nil); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("expected ErrInvalidEvent, got %v", err)
+	}
+}
+
+// decodeAll decodes every event of a stream.
+func decodeAll(t testing.TB, decoder *Decoder) []Event {
+	t.Helper()
+	var events []Event
+	for {
+		event, err := decoder.Decode()
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		events = append(events, event)
+	}
+}
+
+func FuzzDecoder(f *testing.F) {
+	for _, tc := range parserConformance {
+		f.Add(tc.stream)
+	}
+	f.Fuzz(func(t *testing.T, stream string) {
+		decoder := NewDecoder(strings.NewReader(stream))
+		for _, e := range decodeAll(t, decoder) {
+			if strings.ContainsAny(e.ID, "\r\n\x00") || strings.ContainsAny(e.Event, "\r\n") ||
+				strings.ContainsRune(e.Data, '\r') {
+				t.Fatalf("decoded an event with a line break in a field: %+v", e)
+			}
+		}
+		if decoder.Retry() < 0 {
+			t.Fatalf("decoded a negative retry: %v", decoder.Retry())
+		}
+	})
+}
+
+func FuzzWriteEvent(f *testing.F) {
+	for _, tc := range writerConformance {
+		f.Add(tc.event.ID, tc.event.Event, tc.event.Data)
+	}
+	f.Add("1\n", "a\rb", "")
+	f.Add("", "", "\uFEFF\r\r\n\n")
+	f.Fuzz(func(t *testing.T, id, event, data string) {
+		rec := httptest.NewRecorder()
+		writer := NewResponseWriter(rec, Options{}).(EventWriter)
+		err := writer.WriteEvent(Event{ID: id, Event: event, Data: data})
+		if strings.ContainsAny(id, "\r\n\x00") || strings.ContainsAny(event, "\r\n") {
+			if !errors.Is(err, ErrInvalidEvent) {
+				t.Fatalf("expected ErrInvalidEvent, got %v", err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		expected := Event{ID: id, Event: event, Data: lineBreaks.ReplaceAllString(data, "\n")}
+		if id == "" {
+			expected.ID = "1"
+		}
+		events := decodeAll(t, NewDecoder(rec.Body))
+		if len(events) != 1 || events[0] != expected {
+			t.Fatalf("expected to decode %+v, got %+v from %q", expected, events, rec.Body.String())
+		}
+	})
+}
+
+func FuzzWrite(f *testing.F) {
+	f.Add("add", "hello")
+	f.Add("", "line\nbreak\r\n")
+	f.Add("update", "\x00\uFEFF\"")
+	f.Fuzz(func(t *testing.T, event, data string) {
+		rec := httptest.NewRecorder()
+		writer := NewResponseWriter(rec, Options{})
+		err := writer.Write(event, data)
+		if strings.ContainsAny(event, "\r\n") {
+			if !errors.Is(err, ErrInvalidEvent) {
+				t.Fatalf("expected ErrInvalidEvent, got %v", err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		events := decodeAll(t, NewDecoder(rec.Body))
+		if len(events) != 1 || events[0].ID != "1" || events[0].Event != event {
+			t.Fatalf("expected one %q event, got %+v from %q", event, events, rec.Body.String())
+		}
+		var decoded string
+		if err := json.Unmarshal([]byte(events[0].Data), &decoded); err != nil {
+			t.Fatalf("expected JSON data, got %q: %v", events[0].Data, err)
+		}
+		if utf8.ValidString(data) && decoded != data {
+			t.Fatalf("expected data %q, got %q", data, decoded)
+		}
+	})
+}
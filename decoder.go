@@ -0,0 +1,182 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is a single event read from an event stream.
+type Event struct {
+	// ID is the last event id of the stream when the event was dispatched.
+	ID string
+
+	// Event is the event type. An empty type means "message".
+	Event string
+
+	// Data is the event's data. Multiple data lines are joined with "\n".
+	Data string
+
+	// TraceParent is the W3C traceparent the event was written with, if any.
+	TraceParent string
+
+	// Topic is the topic the event was published on, such as "orders.42".
+	// A Broker uses it to select subscribers. It is written in an extra
+	// `topic` field, which EventSource ignores and Decoder extracts.
+	Topic string
+}
+
+// Context returns a copy of ctx carrying the span context of the event's
+// traceparent, so that processing the event continues the trace of the
+// operation that wrote it. If the event has no valid traceparent, ctx is
+// returned unchanged.
+func (e Event) Context(ctx context.Context) context.Context {
+	sc, err := ParseTraceParent(e.TraceParent)
+	if err != nil {
+		return ctx
+	}
+	return ContextWithSpanContext(ctx, sc)
+}
+
+// Decoder reads events from an event stream, following the parsing rules of
+// the WHATWG HTML specification.
+type Decoder struct {
+	r           *bufio.Reader
+	started     bool
+	skipLF      bool
+	lastEventID string
+	retry       time.Duration
+}
+
+// NewDecoder creates a Decoder reading from r. The stream must already be
+// decoded from any Content-Encoding.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next event. Comments, and blocks that carry no data, are
+// not returned as events. At the end of the stream it returns io.EOF and
+// discards any incomplete event.
+func (d *Decoder) Decode() (Event, error) {
+	var (
+		eventType   string
+		data        strings.Builder
+		hasData     bool
+		traceParent string
+		topic       string
+	)
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			return Event{}, err
+		}
+
+		if line == "" {
+			if !hasData {
+				eventType, traceParent, topic = "", "", ""
+				continue
+			}
+			return Event{
+				ID:          d.lastEventID,
+				Event:       eventType,
+				Data:        strings.TrimSuffix(data.String(), "\n"),
+				TraceParent: traceParent,
+				Topic:       topic,
+			}, nil
+		}
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				d.lastEventID = value
+			}
+		case "retry":
+			if isDigits(value) {
+				if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+					d.retry = time.Duration(ms) * time.Millisecond
+				}
+			}
+		case "traceparent":
+			traceParent = value
+		case "topic":
+			topic = value
+		}
+	}
+}
+
+// LastEventID returns the last event id of the stream.
+func (d *Decoder) LastEventID() string {
+	return d.lastEventID
+}
+
+// Retry returns the reconnection time last set by the stream, or zero if the
+// stream has not set it.
+func (d *Decoder) Retry() time.Duration {
+	return d.retry
+}
+
+// readLine reads a line terminated by CRLF, LF or CR. A leading byte order
+// mark is skipped.
+func (d *Decoder) readLine() (string, error) {
+	var line []byte
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if d.skipLF {
+			d.skipLF = false
+			if b == '\n' {
+				continue
+			}
+		}
+		switch b {
+		case '\n':
+			return d.text(line), nil
+		case '\r':
+			d.skipLF = true
+			return d.text(line), nil
+		}
+		line = append(line, b)
+	}
+}
+
+// text converts a line to a string, removing the byte order mark from the
+// first line of the stream.
+func (d *Decoder) text(line []byte) string {
+	s := string(line)
+	if !d.started {
+		d.started = true
+		s = strings.TrimPrefix(s, "\uFEFF")
+	}
+	return s
+}
+
+// isDigits reports whether s is a non-empty string of ASCII digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package sse
+
+import (
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecoder_Decode(t *testing.T) {
+	input := ": comment\n" +
+		"id: 1\nevent: add\ndata: {\"a\":1}\n\n" +
+		"data: first\ndata: second\n\n" +
+		"retry: 1500\n\n" +
+		"id: 2\r\nevent: remove\r\ndata\r\n\r\n" +
+		"event: partial\ndata: discarded"
+
+	decoder := NewDecoder(strings.NewReader(input))
+	var events []Event
+	for {
+		event, err := decoder.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		events = append(events, event)
+	}
+
+	expected := []Event{
+		{ID: "1", Event: "add", Data: `{"a":1}`},
+		{ID: "1", Data: "first\nsecond"},
+		{ID: "2", Event: "remove", Data: ""},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected events: %+v, got: %+v", expected, events)
+	}
+	if decoder.LastEventID() != "2" {
+		t.Errorf("expected last event id 2, got %q", decoder.LastEventID())
+	}
+	if decoder.Retry() != 1500*time.Millisecond {
+		t.Errorf("expected retry of 1.5s, got %v", decoder.Retry())
+	}
+}
+
+func TestDecoder_ResponseWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writer := NewResponseWriter(rec, Options{})
+	if err := writer.Write("test-event", map[string]string{"key": "value"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	event, err := NewDecoder(rec.Body).Decode()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := Event{ID: "1", Event: "test-event", Data: `{"key":"value"}`}
+	if event != expected {
+		t.Fatalf("expected event: %+v, got: %+v", expected, event)
+	}
+}
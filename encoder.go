@@ -1,14 +1,17 @@
 package sse
 
 import (
-	"bytes"
+	"bufio"
 	"compress/flate"
 	"compress/gzip"
 	"compress/zlib"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 
 	"github.com/andybalholm/brotli"
+	"github.com/floriscornel/sse/internal/contentcoding"
 	"github.com/klauspost/compress/zstd"
 )
 
@@ -40,43 +43,152 @@ const (
 // encode applies the selected encoding to the input string and returns the encoded bytes.
 // It returns an error if the encoding process fails.
 func encode(level string, m string) ([]byte, error) {
-	buffer := new(bytes.Buffer)
+	return contentcoding.Encode(level, m)
+}
 
-	encoder, err := encoderFactory(level, buffer)
-	if err != nil {
-		return nil, fmt.Errorf("error creating encoder: %v", err)
-	}
-	if encoder == nil {
-		return []byte(m), nil
+// NewDecodingReader returns a reader that decodes a stream produced by a
+// writer with the given encoding. The writer encodes every flush separately,
+// so the stream is a concatenation of encoded members, which are decoded in
+// turn.
+func NewDecodingReader(encoding string, r io.Reader) (io.Reader, error) {
+	switch encoding {
+	case EncodeNone:
+		return r, nil
+	case EncodeZstd:
+		// Zstandard decodes concatenated frames on its own.
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case EncodeGzip, EncodeBrotli, EncodeDeflate, EncodeCompress:
+		return &memberReader{encoding: encoding, src: bufio.NewReader(r)}, nil
+	default:
+		return nil, fmt.Errorf("unknown encoding level: %s", encoding)
 	}
+}
 
-	if _, err = encoder.Write([]byte(m)); err != nil {
-		return nil, fmt.Errorf("error writing to encoder: %v", err)
-	}
+// memberReader decodes a concatenation of independently encoded members.
+type memberReader struct {
+	encoding string
+	src      *bufio.Reader
+	member   io.Reader
+	brotli   *brotliSource
+}
 
-	if err = encoder.Close(); err != nil {
-		return nil, fmt.Errorf("error closing encoder: %v", err)
-	}
+func (mr *memberReader) Read(p []byte) (int, error) {
+	for {
+		if mr.member == nil {
+			// Only start a new member once there is input for it, so that a
+			// stream ending between two members ends cleanly.
+			if _, err := mr.src.Peek(1); err != nil {
+				return 0, err
+			}
+			if err := mr.next(); err != nil {
+				return 0, err
+			}
+		}
 
-	return buffer.Bytes(), nil
+		n, err := mr.member.Read(p)
+		switch {
+		case err == io.EOF:
+			mr.member = nil
+		case err == io.ErrUnexpectedEOF && mr.brotli != nil && mr.brotli.probed:
+			// The member is not complete yet; the next Read feeds it a byte.
+		case err != nil:
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
 }
 
-// encoderFactory creates an encoder based on the provided level.
-func encoderFactory(level string, buffer *bytes.Buffer) (io.WriteCloser, error) {
-	switch level {
-	case EncodeNone:
-		return nil, nil
+// next starts decoding a member. The DEFLATE based decoders read src as an
+// io.ByteReader, so they do not read past the end of a member.
+func (mr *memberReader) next() error {
+	var err error
+	switch mr.encoding {
 	case EncodeGzip:
-		return gzip.NewWriter(buffer), nil
+		var gr *gzip.Reader
+		if gr, err = gzip.NewReader(mr.src); err == nil {
+			gr.Multistream(false)
+			mr.member = gr
+		}
 	case EncodeBrotli:
-		return brotli.NewWriter(buffer), nil
+		mr.brotli = &brotliSource{src: mr.src}
+		mr.member = brotli.NewReader(mr.brotli)
 	case EncodeDeflate:
-		return flate.NewWriter(buffer, flate.DefaultCompression)
+		mr.member = flate.NewReader(mr.src)
 	case EncodeCompress:
-		return zlib.NewWriter(buffer), nil
-	case EncodeZstd:
-		return zstd.NewWriter(buffer)
-	default:
-		return nil, fmt.Errorf("unknown encoding level: %s", level)
+		mr.member, err = zlib.NewReader(mr.src)
+	}
+	return err
+}
+
+// brotliSource feeds a brotli member from src without reading past its end.
+// The brotli reader only reads its source when it needs more input, so every
+// other Read reports io.EOF instead of a byte: the brotli reader then returns
+// io.EOF if the member is complete, and io.ErrUnexpectedEOF if it is not, in
+// which case the following Read supplies the next byte.
+type brotliSource struct {
+	src    *bufio.Reader
+	probe  bool // the next Read reports io.EOF
+	probed bool // the last Read reported io.EOF without reaching the end of src
+}
+
+func (bs *brotliSource) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	bs.probed = bs.probe
+	if bs.probe {
+		bs.probe = false
+		return 0, io.EOF
+	}
+	bs.probe = true
+	return bs.src.Read(p[:1])
+}
+
+// negotiatedEncodings are the encodings NegotiateEncoding and a Broker choose
+// from by default, from the most to the least preferred. deflate and compress
+// are left out because the writer's streams do not match what browsers expect
+// under those names.
+var negotiatedEncodings = []string{EncodeZstd, EncodeBrotli, EncodeGzip}
+
+// NegotiateEncoding returns the encoding among zstd, br and gzip that a client
+// prefers according to the value of its Accept-Encoding header, or EncodeNone
+// if it accepts none of them. Among encodings of equal preference, that order
+// wins. It is the negotiation a Broker does by default.
+func NegotiateEncoding(acceptEncoding string) string {
+	return negotiateEncoding(acceptEncoding, negotiatedEncodings)
+}
+
+// negotiateEncoding returns the encoding among encodings that a client
+// prefers according to its Accept-Encoding header.
+func negotiateEncoding(acceptEncoding string, encodings []string) string {
+	best, bestQ := EncodeNone, 0.0
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+	for _, encoding := range encodings {
+		q, ok := accepted[encoding]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
 	}
+	return best
 }
@@ -5,6 +5,8 @@ import (
 	"compress/flate"
 	"compress/gzip"
 	"compress/zlib"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/andybalholm/brotli"
@@ -173,3 +175,51 @@ func TestEncodeOutputs(t *testing.T) {
 		}
 	})
 }
+
+func TestNewDecodingReader(t *testing.T) {
+	members := []string{"id: 1\ndata: 1\n\n", "id: 2\ndata: 2\n\n", "id: 3\ndata: 3\n\n"}
+	for _, level := range []string{EncodeNone, EncodeGzip, EncodeBrotli, EncodeDeflate, EncodeCompress, EncodeZstd} {
+		t.Run(level, func(t *testing.T) {
+			stream := new(bytes.Buffer)
+			for _, m := range members {
+				encoded, err := encode(level, m)
+				if err != nil {
+					t.Fatalf("encode() error = %v", err)
+				}
+				stream.Write(encoded)
+			}
+
+			reader, err := NewDecodingReader(level, stream)
+			if err != nil {
+				t.Fatalf("NewDecodingReader() error = %v", err)
+			}
+			buf := new(bytes.Buffer)
+			if _, err := buf.ReadFrom(reader); err != nil {
+				t.Fatalf("buf.ReadFrom() error = %v", err)
+			}
+			if want := strings.Join(members, ""); buf.String() != want {
+				t.Errorf("decoding = %q, want %q", buf.String(), want)
+			}
+		})
+	}
+
+	for _, level := range []string{EncodeGzip, EncodeBrotli, EncodeDeflate, EncodeCompress} {
+		t.Run(level+"Truncated", func(t *testing.T) {
+			encoded, err := encode(level, strings.Repeat("data: truncated\n", 64))
+			if err != nil {
+				t.Fatalf("encode() error = %v", err)
+			}
+			reader, err := NewDecodingReader(level, bytes.NewReader(encoded[:len(encoded)-1]))
+			if err != nil {
+				t.Fatalf("NewDecodingReader() error = %v", err)
+			}
+			if _, err := io.ReadAll(reader); err != io.ErrUnexpectedEOF {
+				t.Errorf("io.ReadAll() error = %v, want %v", err, io.ErrUnexpectedEOF)
+			}
+		})
+	}
+
+	if _, err := NewDecodingReader("unknown", new(bytes.Buffer)); err == nil {
+		t.Errorf("NewDecodingReader() expected an error for an unknown encoding")
+	}
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
-	"fmt"
 	"log"
+	"log/slog"
 	"math/rand"
 	"net/http"
 	"time"
@@ -20,36 +20,37 @@ func main() {
 // It sends a list of players when a new client connects, and then sends
 // random updates to the player list every 5 seconds.
 func handler(w http.ResponseWriter, r *http.Request) {
-	sw := sse.NewResponseWriter(w, sse.Options{
+	sw, err := sse.NewRequestWriter(w, r, sse.Options{
 		Encoding: sse.EncodeBrotli,
+		Logger:   slog.Default(),
 	})
-	fmt.Println("New client connected")
+	if err != nil {
+		return
+	}
+	logger := slog.Default().With("remote_addr", r.RemoteAddr)
 
 	// Send initial player list.
 	select {
 	case <-r.Context().Done():
-		fmt.Println("A client has disconnected.")
+		logger.Info("client disconnected")
 	case <-time.After(1 * time.Second): // Mock delay.
 		break
 	}
 	players := generatePlayerList(10)
-	err := sw.Write(loadEvent, loadData{playerSliceFromMap(players)})
-	if err != nil {
-		fmt.Println("Error writing to client:", err)
+	if err := sw.Write(loadEvent, loadData{playerSliceFromMap(players)}); err != nil {
 		return
 	}
 
 	for {
 		select {
 		case <-r.Context().Done():
-			fmt.Println("A client has disconnected.")
+			logger.Info("client disconnected")
 			return
 
 		// Randomly add, update, or remove a player every 5 seconds.
 		case <-time.After(5 * time.Second):
 			err := performRandomEvent(sw, &players)
 			if err != nil {
-				fmt.Println("Error writing to client:", err)
 				return
 			}
 		}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/floriscornel/sse"
+	"github.com/floriscornel/sse/ssetest"
+)
+
+// TestEventCatalog guards the wire format of the events sent to clients. Run
+// it with -ssetest.update after an intended change.
+func TestEventCatalog(t *testing.T) {
+	rec := ssetest.NewRecorder()
+	sw := sse.NewResponseWriter(rec, sse.Options{Encoding: sse.EncodeBrotli})
+
+	alice := playerScore{ID: 1, Name: "Alice", Score: 10}
+	bob := playerScore{ID: 2, Name: "Bob", Score: 20}
+	writes := []struct {
+		event string
+		data  interface{}
+	}{
+		{loadEvent, loadData{[]playerScore{alice}}},
+		{addEvent, addData{bob}},
+		{updateEvent, updateData{playerScore{ID: 2, Name: "Bob", Score: 25}}},
+		{removeEvent, removeData{alice}},
+	}
+	for _, w := range writes {
+		if err := sw.Write(w.event, w.data); err != nil {
+			t.Fatalf("failed to write %s event: %v", w.event, err)
+		}
+	}
+
+	ssetest.Golden(t, "events", rec)
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
-	"fmt"
 	"log"
+	"log/slog"
 	"math/rand"
 	"net/http"
 	"sync"
@@ -24,13 +24,17 @@ type listenerMessage struct {
 // handler is the HTTP handler that sends incremental updates to the client.
 // It sends a "update" message every time a new client connects or disconnects.
 func handler(w http.ResponseWriter, r *http.Request) {
-	sw := sse.NewResponseWriter(w, sse.Options{
-		Encoding: sse.EncodeGzip,
-	})
-
 	// We generate a random ID for this listener.
 	uniqueID := rand.Intn(1 << 31)
-	fmt.Println("New listener connected with id", uniqueID)
+	logger := slog.Default().With("listener_id", uniqueID)
+
+	sw, err := sse.NewRequestWriter(w, r, sse.Options{
+		Encoding: sse.EncodeGzip,
+		Logger:   logger,
+	})
+	if err != nil {
+		return
+	}
 
 	// Add the listener to the listeners map.
 	addListener(uniqueID)
@@ -39,21 +43,18 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		removeListener(uniqueID)
 	}()
 
-	err := sw.Write("update", listenerMessage{len(listeners)})
-	if err != nil {
-		fmt.Println("Error writing to client:", err)
+	if err := sw.Write("update", listenerMessage{len(listeners)}); err != nil {
 		return
 	}
 
 	for {
 		select {
 		case <-r.Context().Done():
-			fmt.Println("The client has disconnected of listener", uniqueID)
+			logger.Info("client disconnected")
 			return
 		case newCount := <-listeners[uniqueID]:
 			err := sw.Write("update", listenerMessage{newCount})
 			if err != nil {
-				fmt.Println("Error writing to client:", err)
 				return
 			}
 		}
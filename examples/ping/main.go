@@ -1,8 +1,8 @@
 package main
 
 import (
-	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
@@ -21,8 +21,12 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	opts := sse.Options{
 		ResponseStatus: http.StatusOK,
 		Encoding:       sse.EncodeNone,
+		Logger:         slog.Default(),
+	}
+	sw, err := sse.NewRequestWriter(w, r, opts)
+	if err != nil {
+		return
 	}
-	sw := sse.NewResponseWriter(w, opts)
 
 	for {
 		select {
@@ -31,7 +35,6 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		case <-time.After(1 * time.Second):
 			err := sw.Write("ping", "pong")
 			if err != nil {
-				fmt.Println("Error writing to client:", err)
 				return
 			}
 		}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/floriscornel/sse"
+)
+
+// scoreboard is the state streamed to clients.
+type scoreboard struct {
+	Players map[string]int `json:"players"`
+}
+
+// main starts the server and listens for incoming connections.
+//
+// Each client receives the scoreboard as a "snapshot" event when it connects,
+// and a "patch" event with JSON Patch operations whenever a score changes.
+func main() {
+	stream, err := sse.NewStateStream(scoreboard{Players: map[string]int{}}, sse.StateOptions{
+		Broker: sse.BrokerOptions{Options: sse.Options{Logger: slog.Default()}},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	go play(stream)
+
+	http.Handle("/", stream)
+	log.Fatal(http.ListenAndServe("localhost:8004", nil))
+}
+
+// play changes a random score every 2 seconds.
+func play(stream *sse.StateStream[scoreboard]) {
+	names := []string{"Alice", "Bob", "Charlie", "David", "Eve"}
+	for range time.Tick(2 * time.Second) {
+		err := stream.Update(func(s *scoreboard) {
+			name := names[rand.Intn(len(names))]
+			if rand.Intn(5) == 0 {
+				delete(s.Players, name)
+			} else {
+				s.Players[name] += rand.Intn(10)
+			}
+		})
+		if err != nil {
+			slog.Error("update failed", slog.Any("error", err))
+		}
+	}
+}
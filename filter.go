@@ -0,0 +1,327 @@
+package sse
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidFilter is returned when parsing a malformed filter.
+var ErrInvalidFilter = errors.New("sse: invalid filter")
+
+// FilterHeader is the request header that may carry a filter, in the same
+// form as a query string: "event=add,remove&topic=orders.*".
+const FilterHeader = "SSE-Filter"
+
+// Filter selects the events a subscriber receives. A nil *Filter selects
+// every event.
+type Filter struct {
+	events map[string]bool
+	topics [][]string
+	where  []condition
+}
+
+// NewFilter creates a Filter that selects the events matching all of:
+//
+//   - events: the event type is one of events. An empty type is "message".
+//   - topics: the event's Topic matches one of the patterns. Topics are
+//     dot-separated; in a pattern, "*" matches any one segment and a final
+//     ">" matches one or more segments, so "orders.*" matches "orders.42"
+//     and "tenant.42.>" matches "tenant.42.orders.7".
+//   - where: the event's data is JSON and every condition holds. A
+//     condition is "field op value", such as "player.score>=10", where field
+//     is a dot-separated path into the data, op is one of =, !=, <, <=, > and
+//     >=, and value is a JSON literal or else a bare string.
+//
+// Empty lists select every event.
+func NewFilter(events, topics, where []string) (*Filter, error) {
+	f := &Filter{}
+	if len(events) > 0 {
+		f.events = make(map[string]bool, len(events))
+		for _, event := range events {
+			if event == "" {
+				event = "message"
+			}
+			f.events[event] = true
+		}
+	}
+	for _, topic := range topics {
+		pattern, err := parsePattern(topic)
+		if err != nil {
+			return nil, err
+		}
+		f.topics = append(f.topics, pattern)
+	}
+	for _, expr := range where {
+		c, err := parseCondition(expr)
+		if err != nil {
+			return nil, err
+		}
+		f.where = append(f.where, c)
+	}
+	return f, nil
+}
+
+// ParseFilter parses the filter of a request from its "event", "topic" and
+// "where" query parameters, and from the FilterHeader header. Parameters may
+// be repeated; event and topic also take comma-separated lists. It returns a
+// nil Filter if the request has none.
+func ParseFilter(r *http.Request) (*Filter, error) {
+	query := r.URL.Query()
+	if header := r.Header.Get(FilterHeader); header != "" {
+		values, err := url.ParseQuery(header)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+		}
+		for name, v := range values {
+			query[name] = append(query[name], v...)
+		}
+	}
+	events, topics, where := splitList(query["event"]), splitList(query["topic"]), query["where"]
+	if len(events) == 0 && len(topics) == 0 && len(where) == 0 {
+		return nil, nil
+	}
+	return NewFilter(events, topics, where)
+}
+
+// splitList splits comma-separated values.
+func splitList(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// Match reports whether the filter selects the event.
+func (f *Filter) Match(e Event) bool {
+	return f.match(&filterEvent{Event: e})
+}
+
+// filterEvent is an event being matched, with its data decoded at most once
+// for all the filters it is matched against.
+type filterEvent struct {
+	Event
+	decoded bool
+	data    any
+	err     error
+}
+
+// json returns the decoded data of the event.
+func (e *filterEvent) json() (any, error) {
+	if !e.decoded {
+		dec := json.NewDecoder(strings.NewReader(e.Data))
+		dec.UseNumber()
+		e.err = dec.Decode(&e.data)
+		e.decoded = true
+	}
+	return e.data, e.err
+}
+
+func (f *Filter) match(e *filterEvent) bool {
+	return f.matchTopic(e.Topic) && f.matchData(e)
+}
+
+// matchTopic reports whether the topic matches one of the patterns.
+func (f *Filter) matchTopic(topic string) bool {
+	if f == nil || f.topics == nil {
+		return true
+	}
+	segments := strings.Split(topic, ".")
+	for _, pattern := range f.topics {
+		if matchPattern(pattern, segments) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchData reports whether the event type and data are selected.
+func (f *Filter) matchData(e *filterEvent) bool {
+	if f == nil {
+		return true
+	}
+	if f.events != nil {
+		event := e.Event.Event
+		if event == "" {
+			event = "message"
+		}
+		if !f.events[event] {
+			return false
+		}
+	}
+	if f.where != nil {
+		data, err := e.json()
+		if err != nil {
+			return false
+		}
+		for _, c := range f.where {
+			if !c.holds(data) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parsePattern splits a topic pattern into segments.
+func parsePattern(pattern string) ([]string, error) {
+	segments := strings.Split(pattern, ".")
+	for i, segment := range segments {
+		if segment == "" || (segment == ">" && i != len(segments)-1) ||
+			(len(segment) > 1 && strings.ContainsAny(segment, "*>")) {
+			return nil, fmt.Errorf("%w: topic %q", ErrInvalidFilter, pattern)
+		}
+	}
+	return segments, nil
+}
+
+// matchPattern reports whether the segments of a topic match a pattern.
+func matchPattern(pattern, topic []string) bool {
+	for i, segment := range pattern {
+		if segment == ">" {
+			return len(topic) > i
+		}
+		if i >= len(topic) || (segment != "*" && segment != topic[i]) {
+			return false
+		}
+	}
+	return len(topic) == len(pattern)
+}
+
+// condition is a comparison of a field of the data with a value.
+type condition struct {
+	path  []string
+	op    string
+	value any
+}
+
+// operators are the comparison operators, longest first.
+var operators = []string{"!=", "<=", ">=", "==", "=", "<", ">"}
+
+// parseCondition parses "field op value".
+func parseCondition(expr string) (condition, error) {
+	i := strings.IndexAny(expr, "!=<>")
+	if i <= 0 {
+		return condition{}, fmt.Errorf("%w: condition %q", ErrInvalidFilter, expr)
+	}
+	c := condition{path: strings.Split(strings.TrimSpace(expr[:i]), ".")}
+	for _, op := range operators {
+		if strings.HasPrefix(expr[i:], op) {
+			c.op = op
+			break
+		}
+	}
+	if c.op == "" {
+		return condition{}, fmt.Errorf("%w: condition %q", ErrInvalidFilter, expr)
+	}
+	literal := strings.TrimSpace(expr[i+len(c.op):])
+	if c.op == "==" {
+		c.op = "="
+	}
+	dec := json.NewDecoder(strings.NewReader(literal))
+	dec.UseNumber()
+	if err := dec.Decode(&c.value); err != nil || dec.More() {
+		c.value = literal
+	}
+	return c, nil
+}
+
+// holds reports whether the condition holds for the data.
+func (c condition) holds(data any) bool {
+	value, ok := lookup(data, c.path)
+	if !ok {
+		return c.op == "!="
+	}
+	switch c.op {
+	case "=":
+		return equalJSON(value, c.value)
+	case "!=":
+		return !equalJSON(value, c.value)
+	}
+	cmp, ok := compareJSON(value, c.value)
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// lookup returns the value at a path of object members and array indexes.
+func lookup(data any, path []string) (any, bool) {
+	for _, key := range path {
+		switch v := data.(type) {
+		case map[string]any:
+			child, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			data = child
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			data = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+// equalJSON reports whether two decoded JSON values are equal, comparing
+// numbers by value.
+func equalJSON(a, b any) bool {
+	if cmp, ok := compareJSON(a, b); ok {
+		return cmp == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareJSON compares two numbers or two strings.
+func compareJSON(a, b any) (int, bool) {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return 0, false
+		}
+		x, err1 := a.Float64()
+		y, err2 := b.Float64()
+		if err1 != nil || err2 != nil {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a, b), true
+	}
+	return 0, false
+}
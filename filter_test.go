@@ -0,0 +1,96 @@
+package sse
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFilter_Match(t *testing.T) {
+	tests := []struct {
+		events, topics, where []string
+		event                 Event
+		expected              bool
+	}{
+		{nil, nil, nil, Event{Data: "x"}, true},
+		{[]string{"add", "remove"}, nil, nil, Event{Event: "add"}, true},
+		{[]string{"add", "remove"}, nil, nil, Event{Event: "update"}, false},
+		{[]string{"message"}, nil, nil, Event{}, true},
+		{nil, []string{"orders.*"}, nil, Event{Topic: "orders.42"}, true},
+		{nil, []string{"orders.*"}, nil, Event{Topic: "orders.42.items"}, false},
+		{nil, []string{"orders.*"}, nil, Event{Topic: "orders"}, false},
+		{nil, []string{"orders.*"}, nil, Event{}, false},
+		{nil, []string{"tenant.*.orders.>"}, nil, Event{Topic: "tenant.42.orders.7.items"}, true},
+		{nil, []string{"tenant.42.>"}, nil, Event{Topic: "tenant.42"}, false},
+		{nil, []string{"users", "orders.*"}, nil, Event{Topic: "users"}, true},
+		{nil, nil, []string{"player.id=42"}, Event{Data: `{"player":{"id":42}}`}, true},
+		{nil, nil, []string{"player.id==42.0"}, Event{Data: `{"player":{"id":42}}`}, true},
+		{nil, nil, []string{"player.id!=42"}, Event{Data: `{"player":{"id":42}}`}, false},
+		{nil, nil, []string{"score>=10", "score<20"}, Event{Data: `{"score":15}`}, true},
+		{nil, nil, []string{"score>=10", "score<20"}, Event{Data: `{"score":20}`}, false},
+		{nil, nil, []string{"score>10"}, Event{Data: `{"score":"11"}`}, false},
+		{nil, nil, []string{"status = open"}, Event{Data: `{"status":"open"}`}, true},
+		{nil, nil, []string{`status="open"`}, Event{Data: `{"status":"open"}`}, true},
+		{nil, nil, []string{"name<m"}, Event{Data: `{"name":"alice"}`}, true},
+		{nil, nil, []string{"items.1.ok=true"}, Event{Data: `{"items":[{},{"ok":true}]}`}, true},
+		{nil, nil, []string{"deleted=null"}, Event{Data: `{"deleted":null}`}, true},
+		{nil, nil, []string{"missing!=1"}, Event{Data: `{}`}, true},
+		{nil, nil, []string{"missing=1"}, Event{Data: `{}`}, false},
+		{nil, nil, []string{"id=1"}, Event{Data: "not json"}, false},
+		{[]string{"add"}, []string{"orders.*"}, []string{"id=1"}, Event{Event: "add", Topic: "orders.1", Data: `{"id":1}`}, true},
+		{[]string{"add"}, []string{"orders.*"}, []string{"id=1"}, Event{Event: "add", Topic: "orders.1", Data: `{"id":2}`}, false},
+	}
+	for _, tc := range tests {
+		f, err := NewFilter(tc.events, tc.topics, tc.where)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if actual := f.Match(tc.event); actual != tc.expected {
+			t.Errorf("%v %v %v: expected %v for %+v, got %v", tc.events, tc.topics, tc.where, tc.expected, tc.event, actual)
+		}
+	}
+
+	var f *Filter
+	if !f.Match(Event{Event: "any"}) {
+		t.Error("expected a nil filter to match every event")
+	}
+}
+
+func TestNewFilter_Invalid(t *testing.T) {
+	for _, tc := range []struct{ topics, where []string }{
+		{[]string{"orders..x"}, nil},
+		{[]string{"orders.>.x"}, nil},
+		{[]string{"orders.4*"}, nil},
+		{nil, []string{"=1"}},
+		{nil, []string{"score"}},
+		{nil, []string{"score!1"}},
+	} {
+		if _, err := NewFilter(nil, tc.topics, tc.where); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("%v %v: expected ErrInvalidFilter, got %v", tc.topics, tc.where, err)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	r := httptest.NewRequest("GET", "/?event=add,remove&event=update&topic=orders.*&where=id%3E1", nil)
+	r.Header.Set(FilterHeader, "where=id<5&topic=users")
+	f, err := ParseFilter(r)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(f.events) != 3 || len(f.topics) != 2 || len(f.where) != 2 {
+		t.Errorf("expected 3 events, 2 topics and 2 conditions, got %+v", f)
+	}
+	if !f.Match(Event{Event: "update", Topic: "users", Data: `{"id":3}`}) {
+		t.Error("expected the filter to match")
+	}
+
+	if f, err := ParseFilter(httptest.NewRequest("GET", "/?other=1", nil)); f != nil || err != nil {
+		t.Errorf("expected no filter, got %+v, %v", f, err)
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(FilterHeader, "topic=%zz")
+	if _, err := ParseFilter(r); !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("expected ErrInvalidFilter, got %v", err)
+	}
+}
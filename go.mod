@@ -5,4 +5,5 @@ go 1.23.0
 require (
 	github.com/andybalholm/brotli v1.2.0
 	github.com/klauspost/compress v1.18.0
+	golang.org/x/crypto v0.41.0
 )
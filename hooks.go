@@ -0,0 +1,208 @@
+package sse
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// CloseReason describes why a stream was closed.
+type CloseReason string
+
+const (
+	// CloseReasonClosed means the handler closed the writer.
+	CloseReasonClosed CloseReason = "closed"
+
+	// CloseReasonShutdown means a Registry shut the stream down.
+	CloseReasonShutdown CloseReason = "shutdown"
+
+	// CloseReasonLifetime means the stream reached Options.MaxLifetime.
+	CloseReasonLifetime CloseReason = "lifetime"
+
+	// CloseReasonIdle means the stream reached Options.IdleTimeout.
+	CloseReasonIdle CloseReason = "idle"
+
+	// CloseReasonOverflow means the queue of an AsyncWriter overflowed with
+	// OverflowClose.
+	CloseReasonOverflow CloseReason = "overflow"
+
+	// CloseReasonError means writing to the client failed.
+	CloseReasonError CloseReason = "error"
+
+	// CloseReasonClient means the client closed a WebSocket connection, or
+	// it was lost.
+	CloseReasonClient CloseReason = "client"
+)
+
+// Hooks are callbacks invoked by a writer, so that logging and metrics can be
+// plugged in without wrapping the http.ResponseWriter. Any of them may be nil.
+//
+// Hooks are called synchronously and must not call methods of the writer.
+// Hooks shared by several writers are called concurrently.
+type Hooks struct {
+	// OnConnect is called once the response headers have been sent.
+	OnConnect func(ConnectInfo)
+
+	// OnEvent is called for every event written to the client.
+	OnEvent func(EventInfo)
+
+	// OnFlush is called every time data is flushed to the client.
+	OnFlush func(FlushInfo)
+
+	// OnError is called when an event cannot be marshalled, encoded, written
+	// or flushed.
+	OnError func(ErrorInfo)
+
+	// OnDrop is called when an event is discarded before reaching the
+	// client, because the queue of an AsyncWriter overflowed or because a
+	// newer event replaced it.
+	OnDrop func(DropInfo)
+
+	// OnClose is called once when the stream is closed.
+	OnClose func(CloseInfo)
+}
+
+// ConnectInfo describes a new stream.
+type ConnectInfo struct {
+	ConnID   uint64
+	Encoding string
+	Status   int
+}
+
+// EventInfo describes an event written to the client.
+type EventInfo struct {
+	ConnID uint64
+	Event  string
+
+	// RawSize is the size of the framed event before encoding.
+	RawSize int
+
+	// EncodedSize is the size of the event after encoding. When events are
+	// batched, the encoded size of the batch is apportioned by raw size.
+	EncodedSize int
+
+	// Duration is the time spent framing, encoding, writing and flushing the
+	// event, or the batch it was part of.
+	Duration time.Duration
+}
+
+// FlushInfo describes a flush to the client.
+type FlushInfo struct {
+	ConnID uint64
+
+	// Events is the number of events flushed. Heartbeats are not counted.
+	Events int
+
+	// RawSize is the size of the flushed data before encoding.
+	RawSize int
+
+	// EncodedSize is the size of the flushed data after encoding.
+	EncodedSize int
+
+	// Duration is the time spent framing, encoding, writing and flushing.
+	Duration time.Duration
+}
+
+// ErrorInfo describes a failure to write to the client.
+type ErrorInfo struct {
+	ConnID uint64
+	Event  string
+
+	// Op is the operation that failed: "marshal", "encode", "write" or
+	// "flush".
+	Op  string
+	Err error
+}
+
+// DropReason describes why an event was discarded.
+type DropReason string
+
+const (
+	// DropReasonOverflow means the event was discarded by
+	// OverflowDropNewest or OverflowDropOldest.
+	DropReasonOverflow DropReason = "overflow"
+
+	// DropReasonCoalesced means the event was replaced by a newer event with
+	// the same key.
+	DropReasonCoalesced DropReason = "coalesced"
+)
+
+// DropInfo describes an event that was discarded.
+type DropInfo struct {
+	ConnID uint64
+	Event  string
+	Reason DropReason
+}
+
+// CloseInfo describes a closed stream.
+type CloseInfo struct {
+	ConnID   uint64
+	Reason   CloseReason
+	Events   int64
+	Bytes    int64
+	Duration time.Duration
+}
+
+// connIDs generates the connection ids passed to hooks.
+var connIDs atomic.Uint64
+
+// frameInfo is the event name and raw size of a framed message.
+type frameInfo struct {
+	event string
+	size  int
+}
+
+// reportFlush logs and calls OnEvent for each framed event, and calls OnFlush
+// for the write that carried them. The caller must hold rw.mu.
+func (rw *responseWriter) reportFlush(frames []frameInfo, raw, encoded int, d time.Duration) {
+	for _, f := range frames {
+		rw.log.Debug("sse event written", slog.String("event", f.event), slog.Int("raw_size", f.size))
+	}
+	hooks := rw.options.Hooks
+	if hooks.OnEvent != nil {
+		remaining := encoded
+		for i, f := range frames {
+			share := remaining
+			if i < len(frames)-1 && raw > 0 {
+				share = f.size * encoded / raw
+			}
+			remaining -= share
+			hooks.OnEvent(EventInfo{
+				ConnID:      rw.id,
+				Event:       f.event,
+				RawSize:     f.size,
+				EncodedSize: share,
+				Duration:    d,
+			})
+		}
+	}
+	if hooks.OnFlush != nil {
+		hooks.OnFlush(FlushInfo{
+			ConnID:      rw.id,
+			Events:      len(frames),
+			RawSize:     raw,
+			EncodedSize: encoded,
+			Duration:    d,
+		})
+	}
+}
+
+// reportDrop logs a discarded event and calls OnDrop.
+func (rw *responseWriter) reportDrop(event string, reason DropReason) {
+	rw.log.Debug("sse event dropped", slog.String("event", event), slog.String("reason", string(reason)))
+	if rw.options.Hooks.OnDrop != nil {
+		rw.options.Hooks.OnDrop(DropInfo{ConnID: rw.id, Event: event, Reason: reason})
+	}
+}
+
+// reportError logs a failure and calls OnError. The caller must hold rw.mu.
+func (rw *responseWriter) reportError(op, event string, err error) {
+	rw.log.Warn("sse write failed",
+		slog.String("op", op),
+		slog.String("event", event),
+		slog.Any("error", err),
+	)
+	if rw.options.Hooks.OnError != nil {
+		rw.options.Hooks.OnError(ErrorInfo{ConnID: rw.id, Event: event, Op: op, Err: err})
+	}
+}
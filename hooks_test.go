@@ -0,0 +1,156 @@
+package sse
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// hookRecorder records the calls made to Hooks.
+type hookRecorder struct {
+	connects []ConnectInfo
+	events   []EventInfo
+	flushes  []FlushInfo
+	errors   []ErrorInfo
+	drops    []DropInfo
+	closes   []CloseInfo
+}
+
+func (hr *hookRecorder) hooks() Hooks {
+	return Hooks{
+		OnConnect: func(i ConnectInfo) { hr.connects = append(hr.connects, i) },
+		OnEvent:   func(i EventInfo) { hr.events = append(hr.events, i) },
+		OnFlush:   func(i FlushInfo) { hr.flushes = append(hr.flushes, i) },
+		OnError:   func(i ErrorInfo) { hr.errors = append(hr.errors, i) },
+		OnDrop:    func(i DropInfo) { hr.drops = append(hr.drops, i) },
+		OnClose:   func(i CloseInfo) { hr.closes = append(hr.closes, i) },
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var hr hookRecorder
+	rec := httptest.NewRecorder()
+	writer := NewResponseWriter(rec, Options{
+		Encoding: EncodeGzip,
+		Hooks:    hr.hooks(),
+	}).(CloseWriter)
+
+	if err := writer.Write("test", "test"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := writer.Write("invalid", make(chan int)); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(hr.connects) != 1 {
+		t.Fatalf("expected 1 connect, got %d", len(hr.connects))
+	}
+	connID := hr.connects[0].ConnID
+	if hr.connects[0].Encoding != EncodeGzip || hr.connects[0].Status != http.StatusOK {
+		t.Errorf("unexpected connect info: %+v", hr.connects[0])
+	}
+
+	if len(hr.events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(hr.events))
+	}
+	event := hr.events[0]
+	rawSize := len("id: 1\nevent: test\ndata: \"test\"\n\n")
+	if event.ConnID != connID || event.Event != "test" || event.RawSize != rawSize ||
+		event.EncodedSize != rec.Body.Len() {
+		t.Errorf("unexpected event info: %+v", event)
+	}
+
+	if len(hr.flushes) != 1 || hr.flushes[0].Events != 1 || hr.flushes[0].EncodedSize != rec.Body.Len() {
+		t.Errorf("unexpected flushes: %+v", hr.flushes)
+	}
+
+	if len(hr.errors) != 1 || hr.errors[0].Op != "marshal" || hr.errors[0].Event != "invalid" {
+		t.Errorf("unexpected errors: %+v", hr.errors)
+	}
+
+	if len(hr.closes) != 1 {
+		t.Fatalf("expected 1 close, got %d", len(hr.closes))
+	}
+	closed := hr.closes[0]
+	if closed.ConnID != connID || closed.Reason != CloseReasonClosed || closed.Events != 1 ||
+		closed.Bytes != int64(rec.Body.Len()) {
+		t.Errorf("unexpected close info: %+v", closed)
+	}
+}
+
+func TestHooks_Batch(t *testing.T) {
+	var hr hookRecorder
+	rec := httptest.NewRecorder()
+	writer := NewResponseWriter(rec, Options{
+		Encoding:      EncodeBrotli,
+		FlushInterval: time.Hour,
+		Hooks:         hr.hooks(),
+	}).(CloseWriter)
+
+	for _, event := range []string{"a", "bb", "ccc"} {
+		if err := writer.Write(event, nil); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(hr.flushes) != 1 || hr.flushes[0].Events != 3 {
+		t.Fatalf("expected a single flush of 3 events, got %+v", hr.flushes)
+	}
+	encoded := 0
+	for _, e := range hr.events {
+		encoded += e.EncodedSize
+	}
+	if encoded != rec.Body.Len() {
+		t.Errorf("expected encoded sizes to add up to %d, got %d", rec.Body.Len(), encoded)
+	}
+}
+
+func TestHooks_Errors(t *testing.T) {
+	var hr hookRecorder
+	writer := NewResponseWriter(&nonFlusherWriter{ResponseWriter: httptest.NewRecorder()}, Options{
+		MaxLifetime: time.Millisecond,
+		Hooks:       hr.hooks(),
+	}).(CloseWriter)
+	<-writer.Done()
+
+	if len(hr.errors) != 1 || hr.errors[0].Op != "flush" {
+		t.Errorf("unexpected errors: %+v", hr.errors)
+	}
+	if len(hr.closes) != 1 || hr.closes[0].Reason != CloseReasonError {
+		t.Errorf("unexpected closes: %+v", hr.closes)
+	}
+}
+
+func TestHooks_Drop(t *testing.T) {
+	var hr hookRecorder
+	writer := NewResponseWriter(httptest.NewRecorder(), Options{
+		FlushInterval: time.Hour,
+		Coalesce:      CoalesceByEvent,
+		Hooks:         hr.hooks(),
+	}).(CloseWriter)
+
+	for i := 0; i < 3; i++ {
+		if err := writer.Write("tick", i); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(hr.drops) != 2 {
+		t.Fatalf("expected 2 drops, got %+v", hr.drops)
+	}
+	for _, d := range hr.drops {
+		if d.Event != "tick" || d.Reason != DropReasonCoalesced {
+			t.Errorf("unexpected drop info: %+v", d)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Package backplanetest provides helpers shared by the tests of the
+// backplane packages.
+package backplanetest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/floriscornel/sse"
+)
+
+// timeout bounds the waits of the helpers.
+const timeout = 2 * time.Second
+
+// Writer is an sse.EventWriter that sends events to a channel.
+type Writer chan sse.Event
+
+func (c Writer) Write(event string, data interface{}) error {
+	return errors.New("unexpected call to Write")
+}
+
+func (c Writer) WriteEvent(e sse.Event) error {
+	c <- e
+	return nil
+}
+
+// Receive returns the next event written to c.
+func Receive(t *testing.T, c Writer) sse.Event {
+	t.Helper()
+	select {
+	case e := <-c:
+		return e
+	case <-time.After(timeout):
+		t.Fatal("timed out waiting for an event")
+		return sse.Event{}
+	}
+}
+
+// ExpectNone checks that no event is written to c for a while.
+func ExpectNone(t *testing.T, c Writer) {
+	t.Helper()
+	select {
+	case e := <-c:
+		t.Errorf("expected no event, got %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// WaitFor waits until cond returns true.
+func WaitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
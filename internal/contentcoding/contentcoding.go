@@ -0,0 +1,62 @@
+// Package contentcoding encodes flushes of an event stream with an HTTP
+// content coding, shared by the writer and the ssetest server so that both
+// produce the same wire format.
+package contentcoding
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encode applies a content coding, named as in the Content-Encoding header,
+// to m and returns a complete member of the stream. The empty coding returns
+// m as is.
+func Encode(coding string, m string) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+
+	encoder, err := newEncoder(coding, buffer)
+	if err != nil {
+		return nil, fmt.Errorf("error creating encoder: %v", err)
+	}
+	if encoder == nil {
+		return []byte(m), nil
+	}
+
+	if _, err = encoder.Write([]byte(m)); err != nil {
+		return nil, fmt.Errorf("error writing to encoder: %v", err)
+	}
+
+	if err = encoder.Close(); err != nil {
+		return nil, fmt.Errorf("error closing encoder: %v", err)
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// newEncoder creates an encoder for the coding, or returns nil for the empty
+// coding.
+func newEncoder(coding string, buffer *bytes.Buffer) (io.WriteCloser, error) {
+	switch coding {
+	case "":
+		return nil, nil
+	case "gzip":
+		return gzip.NewWriter(buffer), nil
+	case "br":
+		return brotli.NewWriter(buffer), nil
+	case "deflate":
+		return flate.NewWriter(buffer, flate.DefaultCompression)
+	case "compress":
+		return zlib.NewWriter(buffer), nil
+	case "zstd":
+		return zstd.NewWriter(buffer)
+	default:
+		return nil, fmt.Errorf("unknown encoding level: %s", coding)
+	}
+}
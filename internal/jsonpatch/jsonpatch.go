@@ -0,0 +1,470 @@
+// Package jsonpatch computes and applies JSON Patch (RFC 6902) and JSON Merge
+// Patch (RFC 7386) documents.
+//
+// Documents are JSON values as decoded by Decode: map[string]any, []any,
+// string, json.Number, bool and nil.
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrTestFailed is returned by Apply when a test operation fails.
+var ErrTestFailed = errors.New("jsonpatch: test failed")
+
+// Operation is a JSON Patch operation.
+type Operation struct {
+	Op    string
+	Path  string
+	From  string
+	Value any
+}
+
+// hasValue reports whether the operation carries a value.
+func (o Operation) hasValue() bool {
+	return o.Op == "add" || o.Op == "replace" || o.Op == "test"
+}
+
+// MarshalJSON encodes the operation, with a value only if it takes one.
+func (o Operation) MarshalJSON() ([]byte, error) {
+	if o.hasValue() {
+		return json.Marshal(struct {
+			Op    string `json:"op"`
+			Path  string `json:"path"`
+			Value any    `json:"value"`
+		}{o.Op, o.Path, o.Value})
+	}
+	return json.Marshal(struct {
+		Op   string `json:"op"`
+		Path string `json:"path"`
+		From string `json:"from,omitempty"`
+	}{o.Op, o.Path, o.From})
+}
+
+// UnmarshalJSON decodes an operation, keeping numbers as json.Number.
+func (o *Operation) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Op    string          `json:"op"`
+		Path  *string         `json:"path"`
+		From  string          `json:"from"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.Path == nil {
+		return fmt.Errorf("jsonpatch: %s operation without a path", raw.Op)
+	}
+	*o = Operation{Op: raw.Op, Path: *raw.Path, From: raw.From}
+	if o.hasValue() {
+		if raw.Value == nil {
+			return fmt.Errorf("jsonpatch: %s operation without a value", raw.Op)
+		}
+		value, err := Decode(raw.Value)
+		if err != nil {
+			return err
+		}
+		o.Value = value
+	}
+	return nil
+}
+
+// Decode decodes a JSON document, keeping numbers as json.Number so that they
+// are encoded again exactly as they were.
+func Decode(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("jsonpatch: data after the document")
+	}
+	return v, nil
+}
+
+// Diff returns the operations that turn a into b. It never returns move or
+// copy operations: changed members and array elements are patched in place,
+// and elements are added or removed at the end of arrays.
+func Diff(a, b any) []Operation {
+	return diff(nil, "", a, b)
+}
+
+func diff(ops []Operation, path string, a, b any) []Operation {
+	switch a := a.(type) {
+	case map[string]any:
+		if b, ok := b.(map[string]any); ok {
+			return diffObjects(ops, path, a, b)
+		}
+	case []any:
+		if b, ok := b.([]any); ok {
+			return diffArrays(ops, path, a, b)
+		}
+	}
+	if !Equal(a, b) {
+		ops = append(ops, Operation{Op: "replace", Path: path, Value: b})
+	}
+	return ops
+}
+
+func diffObjects(ops []Operation, path string, a, b map[string]any) []Operation {
+	keys := make([]string, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		av, inA := a[key]
+		bv, inB := b[key]
+		member := path + "/" + escape(key)
+		switch {
+		case !inB:
+			ops = append(ops, Operation{Op: "remove", Path: member})
+		case !inA:
+			ops = append(ops, Operation{Op: "add", Path: member, Value: bv})
+		default:
+			ops = diff(ops, member, av, bv)
+		}
+	}
+	return ops
+}
+
+func diffArrays(ops []Operation, path string, a, b []any) []Operation {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		ops = diff(ops, path+"/"+strconv.Itoa(i), a[i], b[i])
+	}
+	for i := n; i < len(b); i++ {
+		ops = append(ops, Operation{Op: "add", Path: path + "/" + strconv.Itoa(i), Value: b[i]})
+	}
+	for i := len(a) - 1; i >= n; i-- {
+		ops = append(ops, Operation{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+	}
+	return ops
+}
+
+// Apply applies the operations to a copy of doc and returns the result. If
+// an operation fails, none are applied.
+func Apply(doc any, ops []Operation) (any, error) {
+	doc = clone(doc)
+	for _, op := range ops {
+		var err error
+		if doc, err = apply(doc, op); err != nil {
+			return nil, fmt.Errorf("jsonpatch: %s %q: %w", op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+func apply(doc any, op Operation) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add":
+		return add(doc, path, clone(op.Value))
+	case "remove":
+		return remove(doc, path)
+	case "replace":
+		return replace(doc, path, clone(op.Value))
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			return add(doc, path, clone(value))
+		}
+		if strings.HasPrefix(op.Path+"/", op.From+"/") {
+			if op.Path == op.From {
+				return doc, nil
+			}
+			return nil, errors.New("cannot move a value into itself")
+		}
+		if doc, err = remove(doc, from); err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	case "test":
+		value, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !Equal(value, op.Value) {
+			return nil, ErrTestFailed
+		}
+		return doc, nil
+	default:
+		return nil, errors.New("unknown operation")
+	}
+}
+
+// add adds value at path, replacing an object member or inserting an array
+// element.
+func add(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return update(doc, path, func(container any, token string) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			c[token] = value
+			return c, nil
+		case []any:
+			if token == "-" {
+				return append(c, value), nil
+			}
+			i, err := index(token, len(c)+1)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		default:
+			return nil, errors.New("parent is not a container")
+		}
+	})
+}
+
+// remove removes the value at path.
+func remove(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the document")
+	}
+	return update(doc, path, func(container any, token string) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			if _, ok := c[token]; !ok {
+				return nil, errors.New("no such member")
+			}
+			delete(c, token)
+			return c, nil
+		case []any:
+			i, err := index(token, len(c))
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], c[i+1:]...), nil
+		default:
+			return nil, errors.New("parent is not a container")
+		}
+	})
+}
+
+// replace replaces the existing value at path.
+func replace(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return update(doc, path, func(container any, token string) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			if _, ok := c[token]; !ok {
+				return nil, errors.New("no such member")
+			}
+			c[token] = value
+			return c, nil
+		case []any:
+			i, err := index(token, len(c))
+			if err != nil {
+				return nil, err
+			}
+			c[i] = value
+			return c, nil
+		default:
+			return nil, errors.New("parent is not a container")
+		}
+	})
+}
+
+// update walks to the parent of the value at path, calls fn with it and the
+// last token of path, and stores the container fn returns in place of it.
+func update(doc any, path []string, fn func(container any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	switch c := doc.(type) {
+	case map[string]any:
+		child, ok := c[path[0]]
+		if !ok {
+			return nil, errors.New("no such member")
+		}
+		child, err := update(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		c[path[0]] = child
+		return c, nil
+	case []any:
+		i, err := index(path[0], len(c))
+		if err != nil {
+			return nil, err
+		}
+		child, err := update(c[i], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		c[i] = child
+		return c, nil
+	default:
+		return nil, errors.New("no such value")
+	}
+}
+
+// get returns the value at path.
+func get(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch c := doc.(type) {
+		case map[string]any:
+			child, ok := c[token]
+			if !ok {
+				return nil, errors.New("no such member")
+			}
+			doc = child
+		case []any:
+			i, err := index(token, len(c))
+			if err != nil {
+				return nil, err
+			}
+			doc = c[i]
+		default:
+			return nil, errors.New("no such value")
+		}
+	}
+	return doc, nil
+}
+
+// index parses an array index below n.
+func index(token string, n int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || strings.Trim(token, "0123456789") != "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if i >= n {
+		return 0, fmt.Errorf("array index %d out of bounds", i)
+	}
+	return i, nil
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// escape escapes a token of a JSON Pointer.
+func escape(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+// MergeDiff returns the merge patch that turns a into b, and whether there is
+// any difference. Merge patches cannot set a member to null, because null
+// removes the member: applying the patch leaves out the null members of b.
+func MergeDiff(a, b any) (any, bool) {
+	am, aok := a.(map[string]any)
+	bm, bok := b.(map[string]any)
+	if !aok || !bok {
+		if Equal(a, b) {
+			return nil, false
+		}
+		return b, true
+	}
+	patch := make(map[string]any)
+	for key := range am {
+		if _, ok := bm[key]; !ok {
+			patch[key] = nil
+		}
+	}
+	for key, bv := range bm {
+		av, ok := am[key]
+		if !ok {
+			if bv != nil {
+				patch[key] = bv
+			}
+			continue
+		}
+		if p, changed := MergeDiff(av, bv); changed {
+			patch[key] = p
+		}
+	}
+	return patch, len(patch) > 0
+}
+
+// MergeApply applies a merge patch to a copy of doc and returns the result.
+func MergeApply(doc, patch any) any {
+	return mergeApply(clone(doc), patch)
+}
+
+func mergeApply(doc, patch any) any {
+	pm, ok := patch.(map[string]any)
+	if !ok {
+		return clone(patch)
+	}
+	dm, ok := doc.(map[string]any)
+	if !ok {
+		dm = make(map[string]any)
+	}
+	for key, value := range pm {
+		if value == nil {
+			delete(dm, key)
+		} else {
+			dm[key] = mergeApply(dm[key], value)
+		}
+	}
+	return dm
+}
+
+// Equal reports whether two documents are equal.
+func Equal(a, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// clone returns a deep copy of a document.
+func clone(doc any) any {
+	switch v := doc.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for key, value := range v {
+			c[key] = clone(value)
+		}
+		return c
+	case []any:
+		c := make([]any, len(v))
+		for i, value := range v {
+			c[i] = clone(value)
+		}
+		return c
+	default:
+		return v
+	}
+}
@@ -0,0 +1,223 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// decode decodes a document, failing the test if it is invalid.
+func decode(t *testing.T, s string) any {
+	t.Helper()
+	v, err := Decode([]byte(s))
+	if err != nil {
+		t.Fatalf("invalid document %s: %v", s, err)
+	}
+	return v
+}
+
+// encode encodes a document, failing the test if it cannot.
+func encode(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return string(data)
+}
+
+// The examples of RFC 6902, appendix A.
+var applyTests = []struct {
+	doc, patch, expected string
+}{
+	{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+	{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+	{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+	{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+	{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+	{
+		`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+		`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+		`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+	},
+	{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+	{`{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+	{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"child":{"grandchild":{}},"foo":"bar"}`},
+	{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+	{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+	{`{"foo":null}`, `[{"op":"copy","from":"/foo","path":"/bar"}]`, `{"bar":null,"foo":null}`},
+	{`{"foo":1}`, `[{"op":"replace","path":"","value":[1.50]}]`, `[1.50]`},
+}
+
+func TestApply(t *testing.T) {
+	for _, tc := range applyTests {
+		var ops []Operation
+		if err := json.Unmarshal([]byte(tc.patch), &ops); err != nil {
+			t.Fatalf("invalid patch %s: %v", tc.patch, err)
+		}
+		doc := decode(t, tc.doc)
+		patched, err := Apply(doc, ops)
+		if err != nil {
+			t.Errorf("%s: expected no error, got %v", tc.patch, err)
+			continue
+		}
+		if actual := encode(t, patched); actual != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.patch, tc.expected, actual)
+		}
+		if actual := encode(t, doc); actual != tc.doc {
+			t.Errorf("%s: expected the document to be left alone, got %s", tc.patch, actual)
+		}
+	}
+}
+
+func TestApply_Errors(t *testing.T) {
+	tests := []struct {
+		doc, patch string
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`},
+		{`{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`},
+		{`{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":1}]`},
+		{`{"foo":[1]}`, `[{"op":"add","path":"/foo/2","value":1}]`},
+		{`{"foo":[1]}`, `[{"op":"remove","path":"/foo/01"}]`},
+		{`{"foo":[1]}`, `[{"op":"remove","path":"/foo/-0"}]`},
+		{`{"foo":{}}`, `[{"op":"move","from":"/foo","path":"/foo/bar"}]`},
+		{`{"foo":"bar"}`, `[{"op":"unknown","path":"/foo"}]`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"foo","value":1}]`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":1},{"op":"test","path":"/foo","value":"baz"}]`},
+	}
+	for _, tc := range tests {
+		var ops []Operation
+		if err := json.Unmarshal([]byte(tc.patch), &ops); err != nil {
+			t.Fatalf("invalid patch %s: %v", tc.patch, err)
+		}
+		doc := decode(t, tc.doc)
+		if _, err := Apply(doc, ops); err == nil {
+			t.Errorf("%s: expected an error", tc.patch)
+		}
+		if actual := encode(t, doc); actual != tc.doc {
+			t.Errorf("%s: expected the document to be left alone, got %s", tc.patch, actual)
+		}
+	}
+
+	ops := []Operation{{Op: "test", Path: "/foo", Value: "baz"}}
+	if _, err := Apply(decode(t, `{"foo":"bar"}`), ops); !errors.Is(err, ErrTestFailed) {
+		t.Errorf("expected ErrTestFailed, got %v", err)
+	}
+}
+
+func TestOperation_JSON(t *testing.T) {
+	for _, s := range []string{
+		`{"op":"add","path":"/a","value":null}`,
+		`{"op":"remove","path":"/a"}`,
+		`{"op":"move","path":"/a","from":"/b"}`,
+	} {
+		var op Operation
+		if err := json.Unmarshal([]byte(s), &op); err != nil {
+			t.Fatalf("%s: expected no error, got %v", s, err)
+		}
+		if actual := encode(t, op); actual != s {
+			t.Errorf("expected %s, got %s", s, actual)
+		}
+	}
+
+	for _, s := range []string{`{"op":"add","path":"/a"}`, `{"op":"remove"}`} {
+		var op Operation
+		if err := json.Unmarshal([]byte(s), &op); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+var diffTests = []struct {
+	a, b string
+}{
+	{`{}`, `{}`},
+	{`{"a":1,"b":2}`, `{"a":1,"c":3}`},
+	{`{"a":{"b":[1,2,3]}}`, `{"a":{"b":[1,4]}}`},
+	{`{"a":[1]}`, `{"a":[1,{"x":null},3]}`},
+	{`{"a/b":{"~c":1}}`, `{"a/b":{"~c":2}}`},
+	{`{"a":"x"}`, `{"a":{"b":"x"}}`},
+	{`[1,2]`, `{"a":1}`},
+	{`1`, `1.0`},
+	{`null`, `{"a":null}`},
+}
+
+func TestDiff(t *testing.T) {
+	for _, tc := range diffTests {
+		a, b := decode(t, tc.a), decode(t, tc.b)
+		ops := Diff(a, b)
+		patched, err := Apply(a, ops)
+		if err != nil {
+			t.Errorf("%s to %s: expected no error, got %v", tc.a, tc.b, err)
+			continue
+		}
+		if !Equal(patched, b) {
+			t.Errorf("%s to %s: patch %s gives %s", tc.a, tc.b, encode(t, ops), encode(t, patched))
+		}
+	}
+
+	ops := Diff(decode(t, `{"a":1,"b":[1,2]}`), decode(t, `{"a":2,"b":[1,2,3]}`))
+	expected := `[{"op":"replace","path":"/a","value":2},{"op":"add","path":"/b/2","value":3}]`
+	if actual := encode(t, ops); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+	if ops := Diff(decode(t, `{"a":[1]}`), decode(t, `{"a":[1]}`)); len(ops) != 0 {
+		t.Errorf("expected no operations for equal documents, got %s", encode(t, ops))
+	}
+}
+
+// The examples of RFC 7386, appendix A.
+var mergeTests = []struct {
+	doc, patch, expected string
+}{
+	{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+	{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+	{`{"a":"b"}`, `{"a":null}`, `{}`},
+	{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+	{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+	{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+	{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+	{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+	{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+	{`{"a":"b"}`, `["c"]`, `["c"]`},
+	{`{"a":"foo"}`, `null`, `null`},
+	{`{"a":"foo"}`, `"bar"`, `"bar"`},
+	{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+	{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+	{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+}
+
+func TestMergeApply(t *testing.T) {
+	for _, tc := range mergeTests {
+		doc := decode(t, tc.doc)
+		if actual := encode(t, MergeApply(doc, decode(t, tc.patch))); actual != tc.expected {
+			t.Errorf("%s with %s: expected %s, got %s", tc.doc, tc.patch, tc.expected, actual)
+		}
+		if actual := encode(t, doc); actual != tc.doc {
+			t.Errorf("%s with %s: expected the document to be left alone, got %s", tc.doc, tc.patch, actual)
+		}
+	}
+}
+
+func TestMergeDiff(t *testing.T) {
+	for _, tc := range diffTests {
+		a, b := decode(t, tc.a), decode(t, tc.b)
+		patch, changed := MergeDiff(a, b)
+		if changed == Equal(a, b) {
+			t.Errorf("%s to %s: expected changed to be %v", tc.a, tc.b, !changed)
+		}
+		if !changed {
+			continue
+		}
+		// Null members cannot be expressed by a merge patch.
+		expected := MergeApply(nil, b)
+		if actual := MergeApply(a, patch); !Equal(actual, expected) {
+			t.Errorf("%s to %s: patch %s gives %s", tc.a, tc.b, encode(t, patch), encode(t, actual))
+		}
+	}
+
+	patch, _ := MergeDiff(decode(t, `{"a":1,"b":{"c":1,"d":2}}`), decode(t, `{"b":{"c":1,"d":3}}`))
+	if actual, expected := encode(t, patch), `{"a":null,"b":{"d":3}}`; actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+}
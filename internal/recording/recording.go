@@ -0,0 +1,121 @@
+// Package recording reads and writes recordings of event streams, as written
+// by sse-record and served by sse-replay.
+//
+// A recording is a JSON Lines file with one event per line:
+//
+//	{"t":1.25,"id":"42","event":"add","data":"{\"id\":1}"}
+//
+// t is the time in seconds since the recording started at which the event was
+// received. id and event are left out when empty.
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Entry is a recorded event.
+type Entry struct {
+	Time  time.Duration
+	ID    string
+	Event string
+	Data  string
+}
+
+// entry is the JSON representation of an Entry.
+type entry struct {
+	T     float64 `json:"t"`
+	ID    string  `json:"id,omitempty"`
+	Event string  `json:"event,omitempty"`
+	Data  string  `json:"data"`
+}
+
+// Writer writes a recording.
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter creates a Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Write writes an entry and flushes it, so that an interrupted recording is
+// complete up to the last event.
+func (w *Writer) Write(e Entry) error {
+	line, err := json.Marshal(entry{
+		T:     math.Round(e.Time.Seconds()*1e6) / 1e6,
+		ID:    e.ID,
+		Event: e.Event,
+		Data:  e.Data,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := w.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+// Reader reads a recording.
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewReader creates a Reader reading from r.
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	return &Reader{scanner: scanner}
+}
+
+// Read returns the next entry, or io.EOF at the end of the recording. Blank
+// lines are skipped.
+func (r *Reader) Read() (Entry, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := r.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var e entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return Entry{}, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		if e.T < 0 || math.IsNaN(e.T) {
+			return Entry{}, fmt.Errorf("line %d: invalid time %v", r.line, e.T)
+		}
+		return Entry{
+			Time:  time.Duration(e.T * float64(time.Second)),
+			ID:    e.ID,
+			Event: e.Event,
+			Data:  e.Data,
+		}, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Entry{}, err
+	}
+	return Entry{}, io.EOF
+}
+
+// ReadAll reads every entry of a recording.
+func ReadAll(r io.Reader) ([]Entry, error) {
+	reader := NewReader(r)
+	var entries []Entry
+	for {
+		e, err := reader.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+}
@@ -0,0 +1,51 @@
+package recording
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecording(t *testing.T) {
+	entries := []Entry{
+		{Time: 0, ID: "1", Event: "load", Data: `{"players":[]}`},
+		{Time: 1250 * time.Millisecond, ID: "2", Data: "line\nbreak"},
+		{Time: 3 * time.Second, Event: "add", Data: ""},
+	}
+
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	for _, e := range entries {
+		if err := writer.Write(e); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	expected := `{"t":0,"id":"1","event":"load","data":"{\"players\":[]}"}` + "\n" +
+		`{"t":1.25,"id":"2","data":"line\nbreak"}` + "\n" +
+		`{"t":3,"event":"add","data":""}` + "\n"
+	if buf.String() != expected {
+		t.Fatalf("expected recording:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	read, err := ReadAll(strings.NewReader(buf.String() + "\n"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(read, entries) {
+		t.Errorf("expected entries %+v, got %+v", entries, read)
+	}
+}
+
+func TestReader_Errors(t *testing.T) {
+	for _, input := range []string{
+		`{"t":0,"data":"a"}` + "\n" + `not json`,
+		`{"t":-1,"data":"a"}`,
+	} {
+		if _, err := ReadAll(strings.NewReader(input)); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
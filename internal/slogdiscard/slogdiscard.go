@@ -0,0 +1,21 @@
+// Package slogdiscard provides a logger that drops every record, used when
+// no logger is configured.
+package slogdiscard
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Handler is a slog.Handler that drops every record.
+type Handler struct{}
+
+func (Handler) Enabled(context.Context, slog.Level) bool  { return false }
+func (Handler) Handle(context.Context, slog.Record) error { return nil }
+func (h Handler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h Handler) WithGroup(string) slog.Handler           { return h }
+
+// Logger returns a logger that drops every record.
+func Logger() *slog.Logger {
+	return slog.New(Handler{})
+}
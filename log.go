@@ -0,0 +1,20 @@
+package sse
+
+import (
+	"log/slog"
+
+	"github.com/floriscornel/sse/internal/slogdiscard"
+)
+
+// newLogger returns the logger of a connection, or a logger that discards
+// everything if none is configured. remoteAddr is omitted if empty.
+func newLogger(logger *slog.Logger, connID uint64, remoteAddr, encoding string) *slog.Logger {
+	if logger == nil {
+		return slogdiscard.Logger()
+	}
+	logger = logger.With(slog.Uint64("conn_id", connID))
+	if remoteAddr != "" {
+		logger = logger.With(slog.String("remote_addr", remoteAddr))
+	}
+	return logger.With(slog.String("encoding", encoding))
+}
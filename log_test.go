@@ -0,0 +1,75 @@
+package sse
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey || a.Key == "duration" {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	w, err := NewRequestWriter(httptest.NewRecorder(), r, Options{
+		Encoding: EncodeGzip,
+		Logger:   logger,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	writer := w.(CloseWriter)
+	if err := writer.Write("test", "test"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := writer.Write("invalid", make(chan int)); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 records, got %d:\n%s", len(lines), buf.String())
+	}
+	expected := []string{
+		`level=INFO msg="sse stream opened" conn_id=`,
+		`level=DEBUG msg="sse event written" conn_id=`,
+		`level=WARN msg="sse write failed" conn_id=`,
+		`level=INFO msg="sse stream closed" conn_id=`,
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, expected[i]) {
+			t.Errorf("expected record %q to start with %q", line, expected[i])
+		}
+		if !strings.Contains(line, "remote_addr=192.0.2.1:1234 encoding=gzip") {
+			t.Errorf("expected record %q to carry the remote address and encoding", line)
+		}
+	}
+	if !strings.Contains(lines[2], "op=marshal event=invalid error=") {
+		t.Errorf("expected failed marshal in %q", lines[2])
+	}
+	if !strings.Contains(lines[3], "reason=closed events=1") {
+		t.Errorf("expected close reason in %q", lines[3])
+	}
+}
+
+func TestLogger_Default(t *testing.T) {
+	rw := newResponseWriter(context.Background(), httptest.NewRecorder(), Options{})
+	if rw.log.Enabled(context.Background(), slog.LevelError) {
+		t.Errorf("expected the default logger to discard records")
+	}
+}
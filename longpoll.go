@@ -0,0 +1,142 @@
+package sse
+
+import (
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLongPollTimeout is how long a Broker holds a long poll without
+// events when BrokerOptions.LongPollTimeout is zero.
+const DefaultLongPollTimeout = 30 * time.Second
+
+// IsLongPoll reports whether r asks for a long poll instead of a stream: its
+// query has a poll parameter, as in /events?poll=1.
+func IsLongPoll(r *http.Request) bool {
+	return r.URL.Query().Has("poll")
+}
+
+// pollEvent is the JSON representation of an event in a long-poll response.
+type pollEvent struct {
+	ID    string `json:"id"`
+	Event string `json:"event,omitempty"`
+	Topic string `json:"topic,omitempty"`
+	Data  string `json:"data"`
+}
+
+// pollWriter is an EventWriter collecting the events of a long poll.
+type pollWriter struct {
+	mu     sync.Mutex
+	events []Event
+	ready  chan struct{} // receives a value once there are events
+}
+
+func (p *pollWriter) Write(event string, data interface{}) error {
+	m, err := newMessage(event, data)
+	if err != nil {
+		return err
+	}
+	return p.WriteEvent(Event{Event: event, Data: string(m.data)})
+}
+
+func (p *pollWriter) WriteEvent(e Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, e)
+	select {
+	case p.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// take returns the collected events.
+func (p *pollWriter) take() []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	events := p.events
+	p.events = nil
+	return events
+}
+
+// longPoll answers a long poll with the events of subscribe after the
+// client's last event id, waiting for at least one until the timeout. The
+// events are sent as JSON if the client accepts it and not an event stream,
+// and as a finite event stream otherwise.
+func (b *Broker) longPoll(w http.ResponseWriter, r *http.Request, lastEventID string,
+	subscribe func(w EventWriter, lastEventID string) (unsubscribe func(), complete bool),
+) {
+	collector := &pollWriter{ready: make(chan struct{}, 1)}
+	unsubscribe, complete := subscribe(collector, lastEventID)
+	if !complete {
+		b.log.Warn("sse replay incomplete",
+			slog.String("last_event_id", lastEventID), slog.String("remote_addr", r.RemoteAddr))
+	}
+
+	timeout := b.options.LongPollTimeout
+	if timeout <= 0 {
+		timeout = DefaultLongPollTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-collector.ready:
+	case <-timer.C:
+	case <-b.done:
+	case <-r.Context().Done():
+		unsubscribe()
+		return
+	}
+	unsubscribe()
+	events := collector.take()
+
+	if acceptsJSON(r) {
+		body := struct {
+			Events []pollEvent `json:"events"`
+		}{Events: make([]pollEvent, 0, len(events))}
+		for _, e := range events {
+			body.Events = append(body.Events, pollEvent{ID: e.ID, Event: e.Event, Topic: e.Topic, Data: e.Data})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(body)
+		return
+	}
+
+	opts := b.options.Options
+	opts.Encoding = negotiateEncoding(r.Header.Get("Accept-Encoding"), b.encodings())
+	opts.MaxLifetime, opts.IdleTimeout, opts.Heartbeat = 0, 0, 0
+	opts.WebSocket = false
+	writer := newWriter(r.Context(), w, nil, r.RemoteAddr, opts)
+	for _, e := range events {
+		if err := writer.WriteEvent(e); err != nil {
+			break
+		}
+	}
+	_ = writer.Close()
+}
+
+// acceptsJSON reports whether the Accept header of r prefers JSON to an
+// event stream: it lists application/json and not text/event-stream.
+func acceptsJSON(r *http.Request) bool {
+	var accepted bool
+	for _, value := range r.Header.Values("Accept") {
+		for _, item := range strings.Split(value, ",") {
+			mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(item))
+			if err != nil {
+				continue
+			}
+			switch mediaType {
+			case "text/event-stream":
+				return false
+			case "application/json":
+				accepted = true
+			}
+		}
+	}
+	return accepted
+}
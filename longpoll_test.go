@@ -0,0 +1,122 @@
+package sse
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAcceptsJSON(t *testing.T) {
+	tests := map[string]bool{
+		"":                                     false,
+		"application/json":                     true,
+		"application/json; charset=utf-8, */*": true,
+		"text/event-stream, application/json":  false,
+		"application/json;q=0.9, text/html":    true,
+		"text/event-stream":                    false,
+		"*/*":                                  false,
+		"invalid;;, application/json":          true,
+		"application/json, text/event-stream;q=0.5": false,
+	}
+	for accept, expected := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/?poll=1", nil)
+		r.Header.Set("Accept", accept)
+		if actual := acceptsJSON(r); actual != expected {
+			t.Errorf("%q: expected %v, got %v", accept, expected, actual)
+		}
+	}
+}
+
+// poll sends a long poll to server and returns the response body and its
+// content type.
+func poll(t *testing.T, server *httptest.Server, query, lastEventID, accept string) (string, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/?poll=1"+query, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	req.Header.Set("Accept", accept)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return string(body), resp.Header.Get("Content-Type")
+}
+
+func TestBroker_LongPoll(t *testing.T) {
+	broker := NewBroker(BrokerOptions{LongPollTimeout: 100 * time.Millisecond, Encodings: []string{}})
+	defer broker.Close()
+	server := httptest.NewServer(broker)
+	defer server.Close()
+	for i := 1; i <= 3; i++ {
+		if err := broker.PublishTopic("orders."+string(rune('0'+i)), "add", i); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	// Missed events are returned at once.
+	body, contentType := poll(t, server, "", "1", "application/json")
+	expected := `{"events":[{"id":"2","event":"add","topic":"orders.2","data":"2"},{"id":"3","event":"add","topic":"orders.3","data":"3"}]}` + "\n"
+	if body != expected || contentType != "application/json" {
+		t.Errorf("expected %s, got %s (%s)", expected, body, contentType)
+	}
+	body, contentType = poll(t, server, "&topic=orders.3", "1", "text/event-stream")
+	expected = "id: 3\nevent: add\ntopic: orders.3\ndata: 3\n\n"
+	if body != expected || contentType != "text/event-stream" {
+		t.Errorf("expected %q, got %q (%s)", expected, body, contentType)
+	}
+
+	// Without missed events, the poll waits for the next one.
+	done := make(chan string, 1)
+	go func() {
+		body, _ := poll(t, server, "&lastEventId=3", "", "application/json")
+		done <- body
+	}()
+	waitForSubscribers(t, broker, 1)
+	if err := broker.Publish("add", 4); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var result struct {
+		Events []pollEvent `json:"events"`
+	}
+	if err := json.Unmarshal([]byte(<-done), &result); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(result.Events, []pollEvent{{ID: "4", Event: "add", Data: "4"}}) {
+		t.Errorf("expected event 4, got %+v", result.Events)
+	}
+	waitForSubscribers(t, broker, 0)
+
+	// Without events, the poll ends after the timeout.
+	start := time.Now()
+	body, _ = poll(t, server, "", "4", "application/json")
+	if body != `{"events":[]}`+"\n" || time.Since(start) < 100*time.Millisecond {
+		t.Errorf("expected no events after the timeout, got %s after %v", body, time.Since(start))
+	}
+}
+
+func TestStateStream_LongPoll(t *testing.T) {
+	state, err := NewStateStream([]int{1, 2}, StateOptions{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer state.Close()
+	server := httptest.NewServer(state)
+	defer server.Close()
+
+	// A first poll receives the snapshot.
+	body, _ := poll(t, server, "", "", "application/json")
+	expected := `{"events":[{"id":"` + state.ids.id(0) + `","event":"snapshot","data":"[1,2]"}]}` + "\n"
+	if body != expected {
+		t.Errorf("expected %s, got %s", expected, body)
+	}
+}
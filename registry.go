@@ -0,0 +1,141 @@
+package sse
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ShutdownOptions configures the final event sent to clients when a Registry
+// shuts down.
+type ShutdownOptions struct {
+	// Event is the name of the final event. If empty, only the retry hint is
+	// sent and no event is dispatched on the client.
+	Event string
+
+	// Data is the payload of the final event. If nil, the event is sent with
+	// an empty data line, so that EventSource still dispatches it.
+	Data interface{}
+
+	// Retry is the reconnection delay hinted to clients. If zero,
+	// DefaultRetry is used.
+	Retry time.Duration
+
+	// RetryJitter adds a random duration in [0, RetryJitter) to the retry hint
+	// of each client, so they do not all reconnect at the same moment.
+	RetryJitter time.Duration
+}
+
+// Registry tracks live writers so that they can be drained when the server
+// shuts down. The zero value is ready to use.
+//
+// Because handlers of Server-Sent Events never return on their own,
+// http.Server.Shutdown waits for them indefinitely. Register Registry.Shutdown
+// with http.Server.RegisterOnShutdown, and return from handlers once the
+// writer's Done channel is closed:
+//
+//	srv.RegisterOnShutdown(func() {
+//		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//		defer cancel()
+//		registry.Shutdown(ctx, sse.ShutdownOptions{Retry: time.Second})
+//	})
+type Registry struct {
+	mu      sync.Mutex
+	writers map[*responseWriter]struct{}
+	closing bool
+}
+
+// NewResponseWriter creates a new Writer for Server-Sent Events and tracks it
+// until it is closed. Handlers should close the writer when they return.
+//
+// If the registry is shutting down, the returned writer is already closed.
+func (reg *Registry) NewResponseWriter(w http.ResponseWriter, opts Options) CloseWriter {
+	rw := newResponseWriter(context.Background(), w, opts)
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.closing {
+		_ = rw.closeReason(CloseReasonShutdown)
+		return rw
+	}
+	if reg.writers == nil {
+		reg.writers = make(map[*responseWriter]struct{})
+	}
+	reg.writers[rw] = struct{}{}
+	rw.onClose = func() { reg.remove(rw) }
+	return rw
+}
+
+// Len returns the number of live writers.
+func (reg *Registry) Len() int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return len(reg.writers)
+}
+
+// Shutdown sends every live writer a final event carrying a retry hint and
+// closes its stream. Writers created after Shutdown has been called are closed
+// immediately.
+//
+// It returns the number of streams that were drained before ctx was done. If
+// ctx is done before all streams are drained, the context's error is returned
+// as well.
+func (reg *Registry) Shutdown(ctx context.Context, opts ShutdownOptions) (int, error) {
+	final, err := newMessage(opts.Event, opts.Data)
+	if err != nil {
+		return 0, err
+	}
+	if opts.Event != "" && final.data == nil {
+		final.data = []byte{}
+	}
+	if opts.Retry <= 0 {
+		opts.Retry = DefaultRetry
+	}
+
+	reg.mu.Lock()
+	reg.closing = true
+	writers := make([]*responseWriter, 0, len(reg.writers))
+	for rw := range reg.writers {
+		writers = append(writers, rw)
+	}
+	reg.mu.Unlock()
+
+	drained := make(chan struct{}, len(writers))
+	for _, rw := range writers {
+		m := final
+		m.retry = jitter(opts.Retry, opts.RetryJitter)
+		go func(rw *responseWriter) {
+			if err := rw.closeWith(m, CloseReasonShutdown); err == nil {
+				drained <- struct{}{}
+			}
+		}(rw)
+	}
+
+	count := 0
+	for count < len(writers) {
+		select {
+		case <-drained:
+			count++
+		case <-ctx.Done():
+			return count, ctx.Err()
+		}
+	}
+	return count, nil
+}
+
+// remove stops tracking a writer.
+func (reg *Registry) remove(rw *responseWriter) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.writers, rw)
+}
+
+// jitter returns d increased by a random duration in [0, j).
+func jitter(d, j time.Duration) time.Duration {
+	if j <= 0 {
+		return d
+	}
+	return d + time.Duration(rand.Int63n(int64(j)))
+}
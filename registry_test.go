@@ -0,0 +1,109 @@
+package sse
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry_Shutdown(t *testing.T) {
+	var reg Registry
+	recs := []*httptest.ResponseRecorder{httptest.NewRecorder(), httptest.NewRecorder()}
+	writers := make([]CloseWriter, len(recs))
+	for i, rec := range recs {
+		writers[i] = reg.NewResponseWriter(rec, Options{})
+	}
+	if reg.Len() != 2 {
+		t.Fatalf("expected 2 live writers, got %d", reg.Len())
+	}
+
+	drained, err := reg.Shutdown(context.Background(), ShutdownOptions{
+		Event: "shutdown",
+		Retry: 1500 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if drained != 2 {
+		t.Errorf("expected 2 drained writers, got %d", drained)
+	}
+	if reg.Len() != 0 {
+		t.Errorf("expected no live writers, got %d", reg.Len())
+	}
+
+	for i, rec := range recs {
+		expected := "id: 1\nevent: shutdown\nretry: 1500\ndata: \n\n"
+		if rec.Body.String() != expected {
+			t.Errorf("expected data: %q, got: %q", expected, rec.Body.String())
+		}
+		select {
+		case <-writers[i].Done():
+		default:
+			t.Errorf("expected writer %d to be done", i)
+		}
+		if err := writers[i].Write("test", nil); err != ErrClosed {
+			t.Errorf("expected ErrClosed, got %v", err)
+		}
+	}
+
+	late := reg.NewResponseWriter(httptest.NewRecorder(), Options{})
+	select {
+	case <-late.Done():
+	default:
+		t.Errorf("expected writer created during shutdown to be done")
+	}
+}
+
+func TestRegistry_ShutdownDefaultRetry(t *testing.T) {
+	var reg Registry
+	rec := httptest.NewRecorder()
+	_ = reg.NewResponseWriter(rec, Options{})
+
+	if _, err := reg.Shutdown(context.Background(), ShutdownOptions{}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := "id: 1\nretry: 3000\n\n"
+	if rec.Body.String() != expected {
+		t.Errorf("expected data: %q, got: %q", expected, rec.Body.String())
+	}
+}
+
+func TestRegistry_ShutdownJitter(t *testing.T) {
+	var reg Registry
+	rec := httptest.NewRecorder()
+	_ = reg.NewResponseWriter(rec, Options{})
+
+	_, err := reg.Shutdown(context.Background(), ShutdownOptions{
+		Retry:       time.Second,
+		RetryJitter: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, "id: 1\nretry: 1") || !strings.HasSuffix(body, "\n\n") {
+		t.Errorf("expected a jittered retry hint between 1000 and 1999, got %q", body)
+	}
+}
+
+func TestRegistry_ShutdownDeadline(t *testing.T) {
+	var reg Registry
+	w := reg.NewResponseWriter(httptest.NewRecorder(), Options{}).(*responseWriter)
+
+	// Hold the writer's lock to simulate a client that does not accept data.
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	drained, err := reg.Shutdown(ctx, ShutdownOptions{})
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if drained != 0 {
+		t.Errorf("expected no drained writers, got %d", drained)
+	}
+}
@@ -0,0 +1,71 @@
+package sse
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+// RelayOptions holds configuration for a Relay.
+type RelayOptions struct {
+	// Client configures the connection to the upstream stream.
+	Client ClientOptions
+
+	// Broker configures the fan-out to downstream clients, including the
+	// replay buffer that serves their reconnections.
+	Broker BrokerOptions
+}
+
+// Relay subscribes once to an upstream stream and republishes its events to
+// any number of downstream clients. Upstream event ids are kept, so a client
+// can move between the origin and relays. Downstream clients that reconnect
+// are served from the relay's replay buffer and never reach the origin.
+//
+// Relay embeds a Broker: serve it as an http.Handler and run it with Run.
+type Relay struct {
+	*Broker
+	client *Client
+	log    *slog.Logger
+}
+
+// NewRelay creates a Relay for the upstream URL.
+func NewRelay(url string, opts RelayOptions) *Relay {
+	if opts.Client.Logger == nil {
+		opts.Client.Logger = opts.Broker.Options.Logger
+	}
+	broker := NewBroker(opts.Broker)
+	return &Relay{
+		Broker: broker,
+		client: NewClient(url, opts.Client),
+		log:    broker.log,
+	}
+}
+
+// Run forwards upstream events until ctx is done, the upstream ends the
+// stream for good or the broker is closed. It returns nil if ctx is done or
+// the upstream responds with 204 No Content, and otherwise the error that
+// stopped it.
+func (r *Relay) Run(ctx context.Context) error {
+	defer r.client.Close()
+	for {
+		event, err := r.client.Next(ctx)
+		if err == io.EOF || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			r.log.Error("sse relay stopped", slog.Any("error", err))
+			return err
+		}
+		if err := r.PublishEvent(event); err != nil {
+			if err == ErrClosed {
+				return err
+			}
+			r.log.Warn("sse relay dropped event", slog.String("event", event.Event), slog.Any("error", err))
+		}
+	}
+}
+
+// LastEventID returns the id of the last event received from the upstream.
+func (r *Relay) LastEventID() string {
+	return r.client.LastEventID()
+}
@@ -0,0 +1,105 @@
+package sse
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRelay(t *testing.T) {
+	origin := NewBroker(BrokerOptions{})
+	defer origin.Close()
+	var upstreamRequests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		origin.ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+
+	relay := NewRelay(upstream.URL, RelayOptions{Client: ClientOptions{Retry: time.Millisecond}})
+	defer relay.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = relay.Run(ctx) }()
+	waitForSubscribers(t, origin, 1)
+
+	downstream := httptest.NewServer(relay)
+	defer downstream.Close()
+	read := func(client *Client) Event {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		event, err := client.Next(ctx)
+		if err != nil {
+			t.Fatalf("expected an event, got %v", err)
+		}
+		return event
+	}
+
+	first := NewClient(downstream.URL, ClientOptions{Encodings: []string{EncodeZstd}})
+	defer first.Close()
+	events := make(chan Event, 1)
+	go func() {
+		event, _ := first.Next(context.Background())
+		events <- event
+	}()
+	waitForSubscribers(t, relay.Broker, 1)
+	if err := origin.PublishEvent(Event{ID: "a", Event: "add", Data: "1"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	select {
+	case event := <-events:
+		if event != (Event{ID: "a", Event: "add", Data: "1"}) {
+			t.Errorf("expected the upstream event, got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the upstream event, got nothing")
+	}
+
+	for _, id := range []string{"b", "c"} {
+		if err := origin.PublishEvent(Event{ID: id, Data: id}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if event := read(first); event.ID != "b" {
+		t.Errorf("expected event b, got %+v", event)
+	}
+
+	// Clients reconnecting to the relay are served from its buffer.
+	for i := 0; i < 10; i++ {
+		client := NewClient(downstream.URL, ClientOptions{LastEventID: "a"})
+		if event := read(client); event.ID != "b" {
+			t.Errorf("expected event b to be replayed, got %+v", event)
+		}
+		if event := read(client); event.ID != "c" {
+			t.Errorf("expected event c to be replayed, got %+v", event)
+		}
+		client.Close()
+	}
+	if n := upstreamRequests.Load(); n != 1 {
+		t.Errorf("expected a single upstream connection, got %d", n)
+	}
+	if relay.LastEventID() != "c" {
+		t.Errorf("expected the relay to be at event c, got %q", relay.LastEventID())
+	}
+}
+
+func TestRelay_Run(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	relay := NewRelay(upstream.URL, RelayOptions{})
+	if err := relay.Run(context.Background()); err != nil {
+		t.Errorf("expected no error when the upstream ends the stream, got %v", err)
+	}
+
+	relay = NewRelay(upstream.URL+"/missing\x7f", RelayOptions{})
+	if err := relay.Run(context.Background()); err == nil {
+		t.Error("expected an error for an invalid upstream")
+	}
+}
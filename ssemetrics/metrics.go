@@ -0,0 +1,135 @@
+// Package ssemetrics collects metrics about Server-Sent Events streams and
+// serves them in the Prometheus text exposition format.
+//
+// Metrics are collected through sse.Hooks:
+//
+//	metrics := ssemetrics.New()
+//	opts := sse.Options{Encoding: sse.EncodeGzip, Hooks: metrics.Hooks()}
+//	http.Handle("/metrics", metrics)
+package ssemetrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/floriscornel/sse"
+)
+
+// Metrics collects metrics about Server-Sent Events streams. It implements
+// http.Handler, serving the metrics in the Prometheus text exposition format.
+type Metrics struct {
+	mu sync.Mutex
+
+	// encodings holds the encoding of every open connection, so that byte
+	// counts can be labelled with it.
+	encodings map[uint64]string
+
+	activeConnections float64
+	connections       *counter
+	closedConnections *counter
+	events            *counter
+	rawBytes          *counter
+	encodedBytes      *counter
+	compressionRatio  *histogram
+	flushDuration     *histogram
+	writeErrors       *counter
+	droppedEvents     *counter
+	slowConsumers     *counter
+}
+
+// New creates a new Metrics.
+func New() *Metrics {
+	return &Metrics{
+		encodings:         make(map[uint64]string),
+		connections:       newCounter("encoding"),
+		closedConnections: newCounter("reason"),
+		events:            newCounter("event"),
+		rawBytes:          newCounter("encoding"),
+		encodedBytes:      newCounter("encoding"),
+		compressionRatio: newHistogram("encoding",
+			[]float64{0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1, 1.5, 2}),
+		flushDuration: newHistogram("",
+			[]float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}),
+		writeErrors:   newCounter("op"),
+		droppedEvents: newCounter("reason"),
+		slowConsumers: newCounter(""),
+	}
+}
+
+// Hooks returns the hooks that feed the metrics. Pass them in sse.Options of
+// every writer that should be measured.
+func (m *Metrics) Hooks() sse.Hooks {
+	return sse.Hooks{
+		OnConnect: m.onConnect,
+		OnEvent:   m.onEvent,
+		OnFlush:   m.onFlush,
+		OnError:   m.onError,
+		OnDrop:    m.onDrop,
+		OnClose:   m.onClose,
+	}
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(m.expose())
+}
+
+func (m *Metrics) onConnect(info sse.ConnectInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	encoding := encodingLabel(info.Encoding)
+	m.encodings[info.ConnID] = encoding
+	m.activeConnections++
+	m.connections.add(encoding, 1)
+}
+
+func (m *Metrics) onEvent(info sse.EventInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events.add(info.Event, 1)
+}
+
+func (m *Metrics) onFlush(info sse.FlushInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	encoding := m.encodings[info.ConnID]
+	m.rawBytes.add(encoding, float64(info.RawSize))
+	m.encodedBytes.add(encoding, float64(info.EncodedSize))
+	m.flushDuration.observe("", info.Duration.Seconds())
+	if info.RawSize > 0 {
+		ratio := float64(info.EncodedSize) / float64(info.RawSize)
+		m.compressionRatio.observe(encoding, ratio)
+	}
+}
+
+func (m *Metrics) onError(info sse.ErrorInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writeErrors.add(info.Op, 1)
+}
+
+func (m *Metrics) onDrop(info sse.DropInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.droppedEvents.add(string(info.Reason), 1)
+}
+
+func (m *Metrics) onClose(info sse.CloseInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.encodings, info.ConnID)
+	m.activeConnections--
+	m.closedConnections.add(string(info.Reason), 1)
+	if info.Reason == sse.CloseReasonOverflow {
+		m.slowConsumers.add("", 1)
+	}
+}
+
+// encodingLabel returns the label value for an encoding.
+func encodingLabel(encoding string) string {
+	if encoding == sse.EncodeNone {
+		return "identity"
+	}
+	return encoding
+}
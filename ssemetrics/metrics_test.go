@@ -0,0 +1,105 @@
+package ssemetrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/floriscornel/sse"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := New()
+	opts := sse.Options{Encoding: sse.EncodeGzip, Hooks: metrics.Hooks()}
+
+	open := sse.NewResponseWriter(httptest.NewRecorder(), opts)
+	if err := open.Write("price", map[string]int{"value": 1}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	closed := sse.NewResponseWriter(httptest.NewRecorder(), sse.Options{Hooks: metrics.Hooks()})
+	for i := 0; i < 2; i++ {
+		if err := closed.Write("price", i); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := closed.Write("invalid", make(chan int)); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if err := closed.(sse.CloseWriter).Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE sse_active_connections gauge",
+		"sse_active_connections 1",
+		`sse_connections_total{encoding="gzip"} 1`,
+		`sse_connections_total{encoding="identity"} 1`,
+		`sse_connections_closed_total{reason="closed"} 1`,
+		`sse_events_total{event="price"} 3`,
+		`sse_raw_bytes_total{encoding="identity"} 56`,
+		`sse_encoded_bytes_total{encoding="identity"} 56`,
+		"# TYPE sse_compression_ratio histogram",
+		`sse_compression_ratio_bucket{encoding="identity",le="1"} 2`,
+		`sse_compression_ratio_count{encoding="gzip"} 1`,
+		`sse_flush_duration_seconds_bucket{le="+Inf"} 3`,
+		`sse_write_errors_total{op="marshal"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected line %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestMetrics_DropsAndSlowConsumers(t *testing.T) {
+	metrics := New()
+	hooks := metrics.Hooks()
+
+	hooks.OnConnect(sse.ConnectInfo{ConnID: 1, Encoding: sse.EncodeBrotli})
+	hooks.OnDrop(sse.DropInfo{ConnID: 1, Event: "tick", Reason: sse.DropReasonCoalesced})
+	hooks.OnDrop(sse.DropInfo{ConnID: 1, Event: "tick", Reason: sse.DropReasonOverflow})
+	hooks.OnError(sse.ErrorInfo{ConnID: 1, Op: "write", Err: errors.New("broken pipe")})
+	hooks.OnClose(sse.CloseInfo{ConnID: 1, Reason: sse.CloseReasonOverflow, Duration: time.Second})
+
+	body := string(metrics.expose())
+	for _, line := range []string{
+		"sse_active_connections 0",
+		`sse_connections_total{encoding="br"} 1`,
+		`sse_dropped_events_total{reason="coalesced"} 1`,
+		`sse_dropped_events_total{reason="overflow"} 1`,
+		`sse_write_errors_total{op="write"} 1`,
+		`sse_connections_closed_total{reason="overflow"} 1`,
+		"sse_slow_consumers_total 1",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected line %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestLabels(t *testing.T) {
+	tests := []struct {
+		pairs    []string
+		expected string
+	}{
+		{nil, ""},
+		{[]string{"", "ignored"}, ""},
+		{[]string{"event", "a\"b\\c\nd"}, `{event="a\"b\\c\nd"}`},
+		{[]string{"encoding", "gzip", "le", "0.5"}, `{encoding="gzip",le="0.5"}`},
+	}
+
+	for _, tt := range tests {
+		if got := labels(tt.pairs...); got != tt.expected {
+			t.Errorf("labels(%q) = %s, want %s", tt.pairs, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package ssemetrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// counter is a counter with at most one label.
+type counter struct {
+	label  string
+	values map[string]float64
+}
+
+func newCounter(label string) *counter {
+	return &counter{label: label, values: make(map[string]float64)}
+}
+
+func (c *counter) add(value string, v float64) {
+	c.values[value] += v
+}
+
+// histogram is a histogram with at most one label.
+type histogram struct {
+	label   string
+	buckets []float64
+	series  map[string]*histogramSeries
+}
+
+// histogramSeries holds the observations of a histogram for one label value.
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(label string, buckets []float64) *histogram {
+	return &histogram{label: label, buckets: buckets, series: make(map[string]*histogramSeries)}
+}
+
+func (h *histogram) observe(value string, v float64) {
+	s, ok := h.series[value]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[value] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// expose formats the metrics in the Prometheus text exposition format.
+func (m *Metrics) expose() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b bytes.Buffer
+	writeGauge(&b, "sse_active_connections",
+		"Number of open Server-Sent Events streams.", m.activeConnections)
+	writeCounter(&b, "sse_connections_total",
+		"Total number of Server-Sent Events streams opened.", m.connections)
+	writeCounter(&b, "sse_connections_closed_total",
+		"Total number of Server-Sent Events streams closed, by reason.", m.closedConnections)
+	writeCounter(&b, "sse_events_total",
+		"Total number of events sent, by event name.", m.events)
+	writeCounter(&b, "sse_raw_bytes_total",
+		"Total number of bytes sent before compression, by encoding.", m.rawBytes)
+	writeCounter(&b, "sse_encoded_bytes_total",
+		"Total number of bytes sent after compression, by encoding.", m.encodedBytes)
+	writeHistogram(&b, "sse_compression_ratio",
+		"Ratio of encoded to raw size of each flush, by encoding.", m.compressionRatio)
+	writeHistogram(&b, "sse_flush_duration_seconds",
+		"Time spent framing, encoding, writing and flushing data.", m.flushDuration)
+	writeCounter(&b, "sse_write_errors_total",
+		"Total number of errors writing to clients, by failed operation.", m.writeErrors)
+	writeCounter(&b, "sse_dropped_events_total",
+		"Total number of events discarded before reaching the client, by reason.", m.droppedEvents)
+	writeCounter(&b, "sse_slow_consumers_total",
+		"Total number of streams closed because the client could not keep up.", m.slowConsumers)
+	return b.Bytes()
+}
+
+func writeHeader(b *bytes.Buffer, name, help, kind string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeGauge(b *bytes.Buffer, name, help string, v float64) {
+	writeHeader(b, name, help, "gauge")
+	fmt.Fprintf(b, "%s %s\n", name, formatFloat(v))
+}
+
+func writeCounter(b *bytes.Buffer, name, help string, c *counter) {
+	writeHeader(b, name, help, "counter")
+	for _, value := range sortedKeys(c.values) {
+		fmt.Fprintf(b, "%s%s %s\n", name, labels(c.label, value), formatFloat(c.values[value]))
+	}
+}
+
+func writeHistogram(b *bytes.Buffer, name, help string, h *histogram) {
+	writeHeader(b, name, help, "histogram")
+	for _, value := range sortedKeys(h.series) {
+		s := h.series[value]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(b, "%s_bucket%s %d\n",
+				name, labels(h.label, value, "le", formatFloat(upper)), s.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", name, labels(h.label, value, "le", "+Inf"), s.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", name, labels(h.label, value), formatFloat(s.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", name, labels(h.label, value), s.count)
+	}
+}
+
+// labels formats label name and value pairs. Pairs with an empty name are
+// omitted.
+func labels(pairs ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i] == "" {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", pairs[i], escapeLabel(pairs[i+1])))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// escapeLabel escapes a label value as required by the exposition format.
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
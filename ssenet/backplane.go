@@ -0,0 +1,188 @@
+package ssenet
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/floriscornel/sse"
+)
+
+// ErrNotConnected is returned by Backplane.Publish while the backplane is not
+// connected to the hub.
+var ErrNotConnected = errors.New("ssenet: not connected")
+
+// Options holds configuration for a Backplane.
+type Options struct {
+	// RetryDelay is the delay before reconnecting to the hub. If zero, one
+	// second is used.
+	RetryDelay time.Duration
+
+	// DialTimeout bounds each attempt to connect. If zero, five seconds is
+	// used.
+	DialTimeout time.Duration
+
+	// Logger receives records about connections. If nil, nothing is logged.
+	Logger *slog.Logger
+}
+
+// Backplane is an sse.Backplane connected to a Hub. It reconnects whenever
+// the connection is lost; events published by other instances in the
+// meantime are missed.
+type Backplane struct {
+	network, address string
+	options          Options
+	log              *slog.Logger
+
+	mu     sync.Mutex // guards conn and writes to it
+	conn   net.Conn
+	closed bool
+	done   chan struct{}
+
+	subsMu sync.Mutex // held while events are passed to subscribers
+	subs   map[*func(sse.Event)]struct{}
+}
+
+// Dial creates a Backplane connected to the hub at the address on the named
+// network, such as "tcp" or "unix". It connects in the background.
+func Dial(network, address string, opts Options) *Backplane {
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = time.Second
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	b := &Backplane{
+		network: network,
+		address: address,
+		options: opts,
+		log:     logger(opts.Logger).With(slog.String("hub", address)),
+		done:    make(chan struct{}),
+		subs:    make(map[*func(sse.Event)]struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// run connects to the hub and reads events until the backplane is closed.
+func (b *Backplane) run() {
+	for {
+		conn, err := net.DialTimeout(b.network, b.address, b.options.DialTimeout)
+		if err == nil {
+			b.read(conn)
+		} else {
+			b.log.Warn("ssenet connection failed", slog.Any("error", err))
+		}
+
+		timer := time.NewTimer(b.options.RetryDelay)
+		select {
+		case <-b.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// read passes the events read from conn to the subscribers until the
+// connection is lost.
+func (b *Backplane) read(conn net.Conn) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		conn.Close()
+		return
+	}
+	b.conn = conn
+	b.mu.Unlock()
+	b.log.Info("ssenet connected")
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, MaxFrameSize)
+	for scanner.Scan() {
+		var f frame
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			b.log.Warn("ssenet invalid event", slog.Any("error", err))
+			continue
+		}
+		e := sse.Event{ID: f.ID, Event: f.Event, Topic: f.Topic, Data: f.Data}
+		b.subsMu.Lock()
+		for fn := range b.subs {
+			(*fn)(e)
+		}
+		b.subsMu.Unlock()
+	}
+
+	b.mu.Lock()
+	if b.conn == conn {
+		b.conn = nil
+	}
+	b.mu.Unlock()
+	conn.Close()
+	b.log.Info("ssenet disconnected", slog.Any("error", scanner.Err()))
+}
+
+// Publish sends an event to the hub, which sends it to every instance.
+func (b *Backplane) Publish(e sse.Event) error {
+	line, err := json.Marshal(frame{ID: e.ID, Event: e.Event, Topic: e.Topic, Data: e.Data})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return sse.ErrClosed
+	}
+	if b.conn == nil {
+		return ErrNotConnected
+	}
+	_ = b.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := b.conn.Write(line); err != nil {
+		// The reader notices the closed connection and reconnects.
+		b.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// Subscribe calls fn with every event received from the hub, until cancel is
+// called. fn must not block.
+func (b *Backplane) Subscribe(fn func(sse.Event)) (cancel func()) {
+	b.subsMu.Lock()
+	defer b.subsMu.Unlock()
+	key := &fn
+	b.subs[key] = struct{}{}
+	return func() {
+		b.subsMu.Lock()
+		defer b.subsMu.Unlock()
+		delete(b.subs, key)
+	}
+}
+
+// Connected reports whether the backplane is connected to the hub.
+func (b *Backplane) Connected() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.conn != nil
+}
+
+// Close disconnects from the hub.
+func (b *Backplane) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	if b.conn != nil {
+		b.conn.Close()
+	}
+	return nil
+}
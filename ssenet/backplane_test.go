@@ -0,0 +1,97 @@
+package ssenet
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/floriscornel/sse"
+	"github.com/floriscornel/sse/internal/backplanetest"
+)
+
+// startHub serves a hub on a new listener on the named network.
+func startHub(t *testing.T, network, address string) (*Hub, net.Listener) {
+	t.Helper()
+	l, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	hub := &Hub{}
+	go hub.Serve(l)
+	t.Cleanup(func() { hub.Close() })
+	return hub, l
+}
+
+func TestBackplane(t *testing.T) {
+	for network, address := range map[string]string{
+		"tcp":  "127.0.0.1:0",
+		"unix": filepath.Join(t.TempDir(), "hub.sock"),
+	} {
+		t.Run(network, func(t *testing.T) {
+			hub, l := startHub(t, network, address)
+
+			opts := Options{RetryDelay: 10 * time.Millisecond}
+			backplaneA := Dial(network, l.Addr().String(), opts)
+			defer backplaneA.Close()
+			backplaneB := Dial(network, l.Addr().String(), opts)
+			defer backplaneB.Close()
+			backplanetest.WaitFor(t, "instances to connect", func() bool {
+				return hub.Len() == 2 && backplaneA.Connected() && backplaneB.Connected()
+			})
+
+			a := sse.NewBroker(sse.BrokerOptions{Backplane: backplaneA})
+			defer a.Close()
+			b := sse.NewBroker(sse.BrokerOptions{Backplane: backplaneB})
+			defer b.Close()
+			onA, onB := make(backplanetest.Writer, 4), make(backplanetest.Writer, 4)
+			a.Subscribe(onA, "")
+			b.Subscribe(onB, "")
+
+			if err := a.PublishTopic("orders.42", "add", 42); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			fromA, fromB := backplanetest.Receive(t, onA), backplanetest.Receive(t, onB)
+			if fromA != fromB || fromA.Topic != "orders.42" || fromA.Data != "42" || fromA.ID == "" {
+				t.Errorf("expected both instances to receive the event, got %+v and %+v", fromA, fromB)
+			}
+		})
+	}
+}
+
+func TestBackplane_Reconnect(t *testing.T) {
+	hub, l := startHub(t, "tcp", "127.0.0.1:0")
+	backplane := Dial("tcp", l.Addr().String(), Options{RetryDelay: 10 * time.Millisecond})
+	defer backplane.Close()
+	events := make(chan sse.Event, 1)
+	backplane.Subscribe(func(e sse.Event) { events <- e })
+	backplanetest.WaitFor(t, "the instance to connect", func() bool { return hub.Len() == 1 })
+
+	// Disconnect the instance by closing the hub, and serve a new one on
+	// the same address.
+	hub.Close()
+	backplanetest.WaitFor(t, "the instance to disconnect", func() bool { return !backplane.Connected() })
+	if err := backplane.Publish(sse.Event{Data: "lost"}); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("expected ErrNotConnected, got %v", err)
+	}
+	hub, _ = startHub(t, "tcp", l.Addr().String())
+	backplanetest.WaitFor(t, "the instance to reconnect", func() bool { return hub.Len() == 1 && backplane.Connected() })
+
+	if err := backplane.Publish(sse.Event{ID: "1", Data: "found"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	select {
+	case e := <-events:
+		if e.ID != "1" || e.Data != "found" {
+			t.Errorf("expected the event to be echoed, got %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the event")
+	}
+
+	backplane.Close()
+	if err := backplane.Publish(sse.Event{Data: "closed"}); !errors.Is(err, sse.ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
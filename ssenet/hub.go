@@ -0,0 +1,210 @@
+// Package ssenet implements an sse.Backplane over TCP or Unix sockets, for
+// running a Broker on several instances without an external service.
+//
+// One process runs a Hub, and every instance connects to it with Dial:
+//
+//	hub := &ssenet.Hub{}
+//	go hub.Serve(listener)
+//
+//	backplane := ssenet.Dial("tcp", "hub.internal:7070", ssenet.Options{})
+//	broker := sse.NewBroker(sse.BrokerOptions{Backplane: backplane})
+//
+// The hub sends every event it receives to every instance, including the one
+// that sent it, so that all instances see the events in the same order.
+// Events are sent as JSON Lines:
+//
+//	{"id":"k3x9-42","event":"add","topic":"orders.42","data":"{\"id\":42}"}
+package ssenet
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/floriscornel/sse/internal/slogdiscard"
+)
+
+// ErrHubClosed is returned by Hub.Serve after Close.
+var ErrHubClosed = errors.New("ssenet: hub closed")
+
+// MaxFrameSize is the maximum size of an encoded event.
+const MaxFrameSize = 16 << 20
+
+// writeTimeout bounds the time to write an event to a connection.
+const writeTimeout = 10 * time.Second
+
+// frame is the JSON representation of an event.
+type frame struct {
+	ID    string `json:"id,omitempty"`
+	Event string `json:"event,omitempty"`
+	Topic string `json:"topic,omitempty"`
+	Data  string `json:"data"`
+}
+
+// Hub relays events between the instances connected to it. The zero value is
+// ready to use.
+type Hub struct {
+	// QueueSize is the number of events queued for each instance. An
+	// instance that falls further behind is disconnected, and reconnects.
+	// If zero, 1024 is used.
+	QueueSize int
+
+	// Logger receives records about connections. If nil, nothing is logged.
+	Logger *slog.Logger
+
+	mu        sync.Mutex
+	conns     map[*hubConn]struct{}
+	listeners map[net.Listener]struct{}
+	closed    bool
+}
+
+// hubConn is an instance connected to a hub.
+type hubConn struct {
+	conn  net.Conn
+	queue chan []byte
+}
+
+// Serve accepts connections from instances on l until Close is called, and
+// then returns ErrHubClosed.
+func (h *Hub) Serve(l net.Listener) error {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return ErrHubClosed
+	}
+	if h.listeners == nil {
+		h.listeners = make(map[net.Listener]struct{})
+	}
+	h.listeners[l] = struct{}{}
+	h.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			h.mu.Lock()
+			closed := h.closed
+			delete(h.listeners, l)
+			h.mu.Unlock()
+			if closed {
+				return ErrHubClosed
+			}
+			return err
+		}
+		go h.handle(conn)
+	}
+}
+
+// handle relays the events sent by an instance.
+func (h *Hub) handle(conn net.Conn) {
+	size := h.QueueSize
+	if size <= 0 {
+		size = 1024
+	}
+	c := &hubConn{conn: conn, queue: make(chan []byte, size)}
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		conn.Close()
+		return
+	}
+	if h.conns == nil {
+		h.conns = make(map[*hubConn]struct{})
+	}
+	h.conns[c] = struct{}{}
+	h.mu.Unlock()
+	h.log().Info("ssenet instance connected", slog.String("remote_addr", conn.RemoteAddr().String()))
+
+	go func() {
+		for line := range c.queue {
+			_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if _, err := conn.Write(line); err != nil {
+				conn.Close()
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, MaxFrameSize)
+	for scanner.Scan() {
+		if !json.Valid(scanner.Bytes()) {
+			h.log().Warn("ssenet invalid event", slog.String("remote_addr", conn.RemoteAddr().String()))
+			break
+		}
+		line := make([]byte, len(scanner.Bytes())+1)
+		copy(line, scanner.Bytes())
+		line[len(line)-1] = '\n'
+		h.broadcast(line)
+	}
+	h.remove(c)
+	h.log().Info("ssenet instance disconnected", slog.String("remote_addr", conn.RemoteAddr().String()))
+}
+
+// broadcast queues a line for every instance, disconnecting those whose queue
+// is full.
+func (h *Hub) broadcast(line []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.conns {
+		select {
+		case c.queue <- line:
+		default:
+			h.log().Warn("ssenet instance too slow", slog.String("remote_addr", c.conn.RemoteAddr().String()))
+			h.removeLocked(c)
+		}
+	}
+}
+
+// remove disconnects an instance.
+func (h *Hub) remove(c *hubConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeLocked(c)
+}
+
+// removeLocked disconnects an instance. The caller must hold h.mu.
+func (h *Hub) removeLocked(c *hubConn) {
+	if _, ok := h.conns[c]; !ok {
+		return
+	}
+	delete(h.conns, c)
+	close(c.queue)
+	c.conn.Close()
+}
+
+// Len returns the number of connected instances.
+func (h *Hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.conns)
+}
+
+// Close stops the listeners and disconnects every instance.
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for l := range h.listeners {
+		l.Close()
+	}
+	for c := range h.conns {
+		h.removeLocked(c)
+	}
+	return nil
+}
+
+// log returns the logger of the hub.
+func (h *Hub) log() *slog.Logger {
+	return logger(h.Logger)
+}
+
+// logger returns l, or a logger that discards everything if l is nil.
+func logger(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return slogdiscard.Logger()
+	}
+	return l
+}
@@ -0,0 +1,57 @@
+package ssenet
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/floriscornel/sse/internal/backplanetest"
+)
+
+func TestHub(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	hub := &Hub{}
+	served := make(chan error, 1)
+	go func() { served <- hub.Serve(l) }()
+
+	a, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer a.Close()
+	b, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer b.Close()
+	backplanetest.WaitFor(t, "instances to connect", func() bool { return hub.Len() == 2 })
+
+	line := `{"id":"1","data":"x"}` + "\n"
+	if _, err := a.Write([]byte(line)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for name, conn := range map[string]net.Conn{"sender": a, "other": b} {
+		actual, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil || actual != line {
+			t.Errorf("%s: expected %q, got %q (%v)", name, line, actual, err)
+		}
+	}
+
+	// An instance sending something other than JSON is disconnected.
+	if _, err := b.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	backplanetest.WaitFor(t, "the instance to be disconnected", func() bool { return hub.Len() == 1 })
+
+	hub.Close()
+	if err := <-served; !errors.Is(err, ErrHubClosed) {
+		t.Errorf("expected ErrHubClosed, got %v", err)
+	}
+	if hub.Len() != 0 {
+		t.Errorf("expected no instances, got %d", hub.Len())
+	}
+}
@@ -0,0 +1,614 @@
+// Package ssepostgres implements an sse.Backplane over PostgreSQL, for
+// services whose events originate from database writes.
+//
+// Events are rows of an outbox table, written in the same transaction as the
+// change they describe, so that an event is published if and only if the
+// change is committed:
+//
+//	CREATE TABLE sse_outbox (
+//		id    bigserial PRIMARY KEY,
+//		event text NOT NULL DEFAULT '',
+//		topic text NOT NULL DEFAULT '',
+//		data  text NOT NULL
+//	);
+//
+//	CREATE FUNCTION sse_outbox_notify() RETURNS trigger AS $$
+//	BEGIN
+//		PERFORM pg_notify('sse_outbox', NEW.id::text);
+//		RETURN NULL;
+//	END;
+//	$$ LANGUAGE plpgsql;
+//
+//	CREATE TRIGGER sse_outbox_notify AFTER INSERT ON sse_outbox
+//		FOR EACH ROW EXECUTE FUNCTION sse_outbox_notify();
+//
+// Every instance LISTENs on the channel and reads the new rows in id order,
+// so event ids are the ids of the rows and a client can resume from the
+// table on any instance. Notifications only wake the instances up: they also
+// poll, so no event is lost to a dropped connection. Rows are delivered at
+// least once, and duplicates are dropped by id.
+//
+// Ids are assigned when rows are inserted, not when they are committed, so a
+// row may become visible after rows with greater ids. An instance that sees a
+// gap in the ids waits up to Options.GapTimeout for it to fill before moving
+// on; a gap left by a rolled-back transaction never fills. It keeps looking
+// for the skipped ids until Options.LateTimeout, and delivers a late row
+// after the rows that overtook it; clients resuming from an id after it miss
+// it. A row committed later than that is never delivered, so LateTimeout
+// must exceed the duration of the longest transaction that writes events.
+//
+// The package speaks the PostgreSQL protocol itself. Like libpq, it uses TLS
+// if the server supports it, and Options.SSLMode can require TLS and verify
+// the server's certificate. Old rows are not deleted: delete them
+// periodically, keeping enough for clients to resume.
+package ssepostgres
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/floriscornel/sse"
+	"github.com/floriscornel/sse/internal/slogdiscard"
+)
+
+// commandTimeout bounds the time to run a statement.
+const commandTimeout = 10 * time.Second
+
+// pollLimit is the number of rows read at once.
+const pollLimit = 1000
+
+// TLS modes, named after the sslmode values of libpq.
+const (
+	// SSLDisable connects without TLS.
+	SSLDisable = "disable"
+	// SSLPrefer uses TLS if the server supports it, without verifying its
+	// certificate.
+	SSLPrefer = "prefer"
+	// SSLRequire requires TLS, without verifying the server's certificate.
+	SSLRequire = "require"
+	// SSLVerifyCA requires TLS and a certificate signed by a trusted
+	// authority.
+	SSLVerifyCA = "verify-ca"
+	// SSLVerifyFull requires TLS and a trusted certificate for the host
+	// name of the server.
+	SSLVerifyFull = "verify-full"
+)
+
+// Options holds configuration for a Backplane.
+type Options struct {
+	// Network and Addr locate the server. If empty, "tcp" and
+	// "localhost:5432" are used.
+	Network string
+	Addr    string
+
+	// User, Password and Database are used to connect. If empty, User is
+	// "postgres" and Database is User.
+	User     string
+	Password string
+	Database string
+
+	// SSLMode selects whether and how TLS is used, as one of the SSL
+	// constants. If empty, SSLPrefer is used. Unix sockets never use TLS.
+	SSLMode string
+
+	// TLSConfig is the base TLS configuration, for example with the
+	// authorities trusted by SSLVerifyCA and SSLVerifyFull, or a client
+	// certificate. If nil, the system's authorities are trusted. If its
+	// ServerName is empty, the host of Addr is used.
+	TLSConfig *tls.Config
+
+	// Table is the outbox table, optionally qualified by its schema. If
+	// empty, "sse_outbox" is used.
+	Table string
+
+	// Channel is the channel notified of new rows. If empty, Table is used,
+	// without its schema.
+	Channel string
+
+	// PollInterval is the interval between polls of the table when no
+	// notification arrives. If zero, one second is used.
+	PollInterval time.Duration
+
+	// GapTimeout is how long a missing id is waited for once rows with
+	// greater ids are visible. If zero, five seconds is used.
+	GapTimeout time.Duration
+
+	// LateTimeout is how long ids skipped after GapTimeout are still looked
+	// for, so that the rows of transactions that commit late are delivered.
+	// Rows committed later are lost. If zero, five minutes is used.
+	LateTimeout time.Duration
+
+	// RetryDelay is the delay before reconnecting to the server. If zero,
+	// one second is used.
+	RetryDelay time.Duration
+
+	// DialTimeout bounds each attempt to connect. If zero, five seconds is
+	// used.
+	DialTimeout time.Duration
+
+	// Logger receives records about the connection and skipped ids. If nil,
+	// nothing is logged.
+	Logger *slog.Logger
+}
+
+// statements holds the SQL statements of a Backplane.
+type statements struct {
+	listen, insert, poll, late, since, max string
+}
+
+// newStatements returns the statements for an outbox table and channel.
+func newStatements(table, channel string) statements {
+	var parts []string
+	for _, part := range strings.Split(table, ".") {
+		parts = append(parts, quoteIdentifier(part))
+	}
+	t := strings.Join(parts, ".")
+	return statements{
+		listen: "LISTEN " + quoteIdentifier(channel),
+		insert: "WITH e AS (INSERT INTO " + t + " (event, topic, data) VALUES ($1, $2, $3) RETURNING id) " +
+			"SELECT pg_notify($4, id::text) FROM e",
+		poll: "SELECT id, event, topic, data FROM " + t + " WHERE id >= $1 ORDER BY id LIMIT " + strconv.Itoa(pollLimit),
+		late: "SELECT id, event, topic, data FROM " + t + ", unnest($1::bigint[], $2::bigint[]) AS g(lo, hi) " +
+			"WHERE id BETWEEN g.lo AND g.hi ORDER BY id LIMIT " + strconv.Itoa(pollLimit),
+		since: "SELECT id, event, topic, data FROM " + t + " WHERE id >= $1 ORDER BY id DESC LIMIT $2",
+		max:   "SELECT COALESCE(MAX(id), 0) FROM " + t,
+	}
+}
+
+// quoteIdentifier quotes an SQL identifier.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// Backplane is an sse.Backplane and sse.BackplaneHistory over a PostgreSQL
+// outbox table. It uses one connection for inserts and history, and one to
+// listen and poll, reconnecting either when it is lost.
+type Backplane struct {
+	options    Options
+	statements statements
+	log        *slog.Logger
+
+	cmdMu sync.Mutex // held while a statement runs on cmd
+
+	mu        sync.Mutex // guards the fields below, never held during I/O
+	cmd       *conn
+	listener  *conn
+	listening bool
+	closed    bool
+	done      chan struct{}
+
+	subsMu sync.Mutex // held while events are passed to subscribers
+	subs   map[*func(sse.Event)]struct{}
+
+	// The position of the reader, used by run only. Every id below next has
+	// been delivered or skipped; seen holds the delivered ids from next on,
+	// and marks when greater ids became visible. skipped holds the ranges of
+	// skipped ids still looked for, in id order.
+	next    int64
+	seen    map[int64]struct{}
+	marks   []mark
+	skipped []gap
+}
+
+// gap is a range of skipped ids, from and to included.
+type gap struct {
+	from, to int64
+	at       time.Time // when the ids were skipped
+}
+
+// mark records when rows up to an id became visible. Missing ids below it
+// are gaps since then.
+type mark struct {
+	id int64
+	at time.Time
+}
+
+// New creates a Backplane for the server and table of opts. It starts
+// reading the rows inserted from now on in the background.
+func New(opts Options) *Backplane {
+	if opts.Network == "" {
+		opts.Network = "tcp"
+	}
+	if opts.Addr == "" {
+		opts.Addr = "localhost:5432"
+	}
+	if opts.User == "" {
+		opts.User = "postgres"
+	}
+	if opts.Database == "" {
+		opts.Database = opts.User
+	}
+	if opts.SSLMode == "" {
+		opts.SSLMode = SSLPrefer
+	}
+	if opts.Table == "" {
+		opts.Table = "sse_outbox"
+	}
+	if opts.Channel == "" {
+		opts.Channel = opts.Table[strings.LastIndex(opts.Table, ".")+1:]
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.GapTimeout <= 0 {
+		opts.GapTimeout = 5 * time.Second
+	}
+	if opts.LateTimeout <= 0 {
+		opts.LateTimeout = 5 * time.Minute
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = time.Second
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	log := opts.Logger
+	if log == nil {
+		log = slogdiscard.Logger()
+	}
+	b := &Backplane{
+		options:    opts,
+		statements: newStatements(opts.Table, opts.Channel),
+		log:        log.With(slog.String("table", opts.Table)),
+		done:       make(chan struct{}),
+		subs:       make(map[*func(sse.Event)]struct{}),
+		seen:       make(map[int64]struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Publish inserts an event into the outbox table and notifies the channel.
+// The id of the event is replaced by the id of its row. Events written by
+// the application in its own transactions need no call to Publish.
+func (b *Backplane) Publish(e sse.Event) error {
+	_, err := b.command(b.statements.insert, e.Event, e.Topic, e.Data, b.options.Channel)
+	return err
+}
+
+// Since returns the rows of the table after the one with the given id. An id
+// that is not a row id is not found.
+func (b *Backplane) Since(lastEventID string, limit int) ([]sse.Event, bool, error) {
+	id, err := strconv.ParseInt(lastEventID, 10, 64)
+	if err != nil {
+		return nil, false, nil
+	}
+	rows, err := b.command(b.statements.since, strconv.FormatInt(id, 10), strconv.Itoa(limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	events := make([]sse.Event, 0, len(rows))
+	for _, row := range rows {
+		e, _, err := decodeRow(row)
+		if err != nil {
+			return nil, false, err
+		}
+		events = append(events, e)
+	}
+
+	found := len(events) > 0 && events[len(events)-1].ID == strconv.FormatInt(id, 10)
+	if found {
+		events = events[:len(events)-1]
+	} else if len(events) > limit {
+		events = events[:limit]
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, found, nil
+}
+
+// command runs a statement on the command connection, connecting first if
+// needed. Statements run one at a time, and Close interrupts the one running.
+func (b *Backplane) command(sql string, args ...string) ([][]string, error) {
+	b.cmdMu.Lock()
+	defer b.cmdMu.Unlock()
+	b.mu.Lock()
+	c, closed := b.cmd, b.closed
+	b.mu.Unlock()
+	if closed {
+		return nil, sse.ErrClosed
+	}
+	if c == nil {
+		var err error
+		if c, err = dial(b.options); err != nil {
+			return nil, err
+		}
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			c.Close()
+			return nil, sse.ErrClosed
+		}
+		b.cmd = c
+		b.mu.Unlock()
+	}
+	_ = c.c.SetDeadline(time.Now().Add(commandTimeout))
+	rows, err := c.query(sql, args...)
+	if _, ok := err.(*pgError); err != nil && !ok {
+		b.mu.Lock()
+		if b.cmd == c {
+			b.cmd = nil
+		}
+		b.mu.Unlock()
+		c.Close()
+	}
+	return rows, err
+}
+
+// Subscribe calls fn with every event read from the table, until cancel is
+// called. fn must not block.
+func (b *Backplane) Subscribe(fn func(sse.Event)) (cancel func()) {
+	b.subsMu.Lock()
+	defer b.subsMu.Unlock()
+	key := &fn
+	b.subs[key] = struct{}{}
+	return func() {
+		b.subsMu.Lock()
+		defer b.subsMu.Unlock()
+		delete(b.subs, key)
+	}
+}
+
+// Connected reports whether the backplane is listening on the channel.
+func (b *Backplane) Connected() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.listening
+}
+
+// Close closes the connections to the server.
+func (b *Backplane) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	if b.cmd != nil {
+		b.cmd.c.Close()
+	}
+	if b.listener != nil {
+		b.listener.c.Close()
+	}
+	return nil
+}
+
+// run listens and polls until the backplane is closed.
+func (b *Backplane) run() {
+	for {
+		err := b.listen()
+		select {
+		case <-b.done:
+			return
+		default:
+		}
+		b.log.Warn("ssepostgres connection lost", slog.Any("error", err))
+
+		timer := time.NewTimer(b.options.RetryDelay)
+		select {
+		case <-b.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// listen listens on the channel, and polls the table whenever a
+// notification arrives or PollInterval passes, until the connection is lost.
+func (b *Backplane) listen() error {
+	c, err := dial(b.options)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		c.Close()
+		return nil
+	}
+	b.listener = c
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.listener, b.listening = nil, false
+		b.mu.Unlock()
+		c.Close()
+	}()
+
+	_ = c.c.SetDeadline(time.Now().Add(commandTimeout))
+	if _, err := c.query(b.statements.listen); err != nil {
+		return err
+	}
+	if b.next == 0 {
+		rows, err := c.query(b.statements.max)
+		if err != nil {
+			return err
+		}
+		if len(rows) != 1 || len(rows[0]) != 1 {
+			return errProtocol
+		}
+		max, err := strconv.ParseInt(rows[0][0], 10, 64)
+		if err != nil {
+			return errProtocol
+		}
+		b.next = max + 1
+	}
+	_ = c.c.SetDeadline(time.Time{})
+	b.mu.Lock()
+	b.listening = true
+	b.mu.Unlock()
+	b.log.Info("ssepostgres listening", slog.String("channel", b.options.Channel))
+
+	for {
+		if err := b.poll(c); err != nil {
+			return err
+		}
+		if _, err := c.wait(b.options.PollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// poll delivers the rows that became visible, and moves past the gaps that
+// timed out.
+func (b *Backplane) poll(c *conn) error {
+	if err := b.pollNew(c); err != nil {
+		return err
+	}
+	return b.pollLate(c, time.Now())
+}
+
+// pollNew delivers the rows from next on.
+func (b *Backplane) pollNew(c *conn) error {
+	for {
+		_ = c.c.SetDeadline(time.Now().Add(commandTimeout))
+		rows, err := c.query(b.statements.poll, strconv.FormatInt(b.next, 10))
+		_ = c.c.SetDeadline(time.Time{})
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		newest := b.next - 1
+		for _, row := range rows {
+			e, id, err := decodeRow(row)
+			if err != nil {
+				return err
+			}
+			newest = max(newest, id)
+			if _, ok := b.seen[id]; ok || id < b.next {
+				continue
+			}
+			b.seen[id] = struct{}{}
+			b.deliver(e)
+		}
+		if len(b.marks) == 0 || newest > b.marks[len(b.marks)-1].id {
+			b.marks = append(b.marks, mark{id: newest, at: now})
+		}
+
+		advanced := b.advance(now)
+		if len(rows) < pollLimit || !advanced {
+			return nil
+		}
+	}
+}
+
+// advance moves next past the delivered ids and the gaps that timed out, and
+// reports whether it moved.
+func (b *Backplane) advance(now time.Time) bool {
+	start := b.next
+	for len(b.marks) > 0 && b.next <= b.marks[len(b.marks)-1].id {
+		if _, ok := b.seen[b.next]; ok {
+			delete(b.seen, b.next)
+			b.next++
+			continue
+		}
+		// The gap became visible with the first mark above it.
+		i := 0
+		for b.marks[i].id < b.next {
+			i++
+		}
+		if now.Sub(b.marks[i].at) < b.options.GapTimeout {
+			break
+		}
+		// The gap ends at the smallest delivered id above it, which the
+		// mark guarantees.
+		end := b.marks[i].id
+		for id := range b.seen {
+			if id > b.next && id < end {
+				end = id
+			}
+		}
+		b.log.Warn("ssepostgres skipped missing ids",
+			slog.Int64("from", b.next), slog.Int64("to", end-1))
+		b.skipped = append(b.skipped, gap{from: b.next, to: end - 1, at: now})
+		b.next = end
+	}
+	for len(b.marks) > 0 && b.marks[0].id < b.next {
+		b.marks = b.marks[1:]
+	}
+	return b.next > start
+}
+
+// pollLate delivers the rows of skipped ids that became visible, and stops
+// looking for the ids skipped more than LateTimeout ago.
+func (b *Backplane) pollLate(c *conn, now time.Time) error {
+	for len(b.skipped) > 0 && now.Sub(b.skipped[0].at) >= b.options.LateTimeout {
+		b.log.Warn("ssepostgres gave up on missing ids",
+			slog.Int64("from", b.skipped[0].from), slog.Int64("to", b.skipped[0].to))
+		b.skipped = b.skipped[1:]
+	}
+	if len(b.skipped) == 0 {
+		return nil
+	}
+
+	from := make([]string, len(b.skipped))
+	to := make([]string, len(b.skipped))
+	for i, g := range b.skipped {
+		from[i] = strconv.FormatInt(g.from, 10)
+		to[i] = strconv.FormatInt(g.to, 10)
+	}
+	_ = c.c.SetDeadline(time.Now().Add(commandTimeout))
+	rows, err := c.query(b.statements.late, "{"+strings.Join(from, ",")+"}", "{"+strings.Join(to, ",")+"}")
+	_ = c.c.SetDeadline(time.Time{})
+	if err != nil {
+		return err
+	}
+
+	// Rows come in id order, like the gaps: split the gaps around them.
+	var skipped []gap
+	i := 0
+	for _, row := range rows {
+		e, id, err := decodeRow(row)
+		if err != nil {
+			return err
+		}
+		for i < len(b.skipped) && b.skipped[i].to < id {
+			skipped = append(skipped, b.skipped[i])
+			i++
+		}
+		if i == len(b.skipped) || id < b.skipped[i].from {
+			continue
+		}
+		g := b.skipped[i]
+		if id > g.from {
+			skipped = append(skipped, gap{from: g.from, to: id - 1, at: g.at})
+		}
+		b.skipped[i].from = id + 1
+		if b.skipped[i].from > g.to {
+			i++
+		}
+		b.log.Info("ssepostgres delivered a late row", slog.Int64("id", id))
+		b.deliver(e)
+	}
+	b.skipped = append(skipped, b.skipped[i:]...)
+	return nil
+}
+
+// deliver passes an event to the subscribers.
+func (b *Backplane) deliver(e sse.Event) {
+	b.subsMu.Lock()
+	defer b.subsMu.Unlock()
+	for fn := range b.subs {
+		(*fn)(e)
+	}
+}
+
+// decodeRow decodes a row of id, event, topic and data.
+func decodeRow(row []string) (sse.Event, int64, error) {
+	if len(row) != 4 {
+		return sse.Event{}, 0, errProtocol
+	}
+	id, err := strconv.ParseInt(row[0], 10, 64)
+	if err != nil {
+		return sse.Event{}, 0, errProtocol
+	}
+	return sse.Event{ID: row[0], Event: row[1], Topic: row[2], Data: row[3]}, id, nil
+}
@@ -0,0 +1,300 @@
+package ssepostgres
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/floriscornel/sse"
+	"github.com/floriscornel/sse/internal/backplanetest"
+	"github.com/floriscornel/sse/internal/slogdiscard"
+)
+
+// connect creates a Backplane connected to f, closed when the test ends.
+func connect(t *testing.T, f *fakePostgres, opts Options) (*Backplane, backplanetest.Writer) {
+	t.Helper()
+	opts.Addr = f.addr()
+	opts.RetryDelay = 10 * time.Millisecond
+	b := New(opts)
+	t.Cleanup(func() { b.Close() })
+	events := make(backplanetest.Writer, 16)
+	b.Subscribe(func(e sse.Event) { events <- e })
+	backplanetest.WaitFor(t, "the listener", b.Connected)
+	return b, events
+}
+
+func TestBackplane(t *testing.T) {
+	f := newFakePostgres(t)
+	f.setPassword("secret")
+	f.commit(f.reserve(), "before")
+	opts := Options{User: "app", Password: "secret", PollInterval: time.Hour}
+
+	backplaneA, _ := connect(t, f, opts)
+	backplaneB, _ := connect(t, f, opts)
+	a := sse.NewBroker(sse.BrokerOptions{Backplane: backplaneA})
+	defer a.Close()
+	b := sse.NewBroker(sse.BrokerOptions{Backplane: backplaneB})
+	defer b.Close()
+	onA, onB := make(backplanetest.Writer, 4), make(backplanetest.Writer, 4)
+	a.Subscribe(onA, "")
+	b.Subscribe(onB, "")
+
+	// Rows from before the backplane started are not delivered, and rows
+	// are delivered as soon as they are notified.
+	if err := a.PublishTopic("orders.42", "add", 42); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	fromA, fromB := backplanetest.Receive(t, onA), backplanetest.Receive(t, onB)
+	expected := sse.Event{ID: "2", Event: "add", Topic: "orders.42", Data: "42"}
+	if fromA != expected || fromB != expected {
+		t.Errorf("expected both instances to receive %+v, got %+v and %+v", expected, fromA, fromB)
+	}
+
+	f.setPassword("other")
+	f.disconnect()
+	backplanetest.WaitFor(t, "the listener to be lost", func() bool { return !backplaneA.Connected() })
+	if err := a.Publish("add", 1); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestBackplane_History(t *testing.T) {
+	f := newFakePostgres(t)
+	for i := 1; i <= 5; i++ {
+		f.commit(f.reserve(), "x")
+	}
+	backplane, _ := connect(t, f, Options{})
+
+	ids := func(events []sse.Event) []string {
+		var ids []string
+		for _, e := range events {
+			ids = append(ids, e.ID)
+		}
+		return ids
+	}
+	tests := []struct {
+		lastEventID string
+		limit       int
+		expected    []string
+		found       bool
+	}{
+		{"2", 10, []string{"3", "4", "5"}, true},
+		{"2", 3, []string{"3", "4", "5"}, true},
+		{"2", 2, []string{"4", "5"}, false},
+		{"5", 10, nil, true},
+		{"0", 10, []string{"1", "2", "3", "4", "5"}, false},
+		{"x", 10, nil, false},
+	}
+	for _, test := range tests {
+		events, found, err := backplane.Since(test.lastEventID, test.limit)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(ids(events), test.expected) || found != test.found {
+			t.Errorf("%s (%d): expected %v (%v), got %v (%v)",
+				test.lastEventID, test.limit, test.expected, test.found, ids(events), found)
+		}
+	}
+
+	// A broker resumes clients from the table.
+	broker := sse.NewBroker(sse.BrokerOptions{Backplane: backplane})
+	defer broker.Close()
+	w := make(backplanetest.Writer, 4)
+	_, complete := broker.Subscribe(w, "3")
+	if !complete || backplanetest.Receive(t, w).ID != "4" || backplanetest.Receive(t, w).ID != "5" {
+		t.Errorf("expected events 4 and 5 to be replayed (complete %v)", complete)
+	}
+}
+
+func TestBackplane_Gaps(t *testing.T) {
+	f := newFakePostgres(t)
+	backplane, events := connect(t, f, Options{
+		PollInterval: 10 * time.Millisecond,
+		GapTimeout:   200 * time.Millisecond,
+		LateTimeout:  500 * time.Millisecond,
+	})
+
+	// A row committed late is delivered once, after the rows that overtook
+	// it.
+	late := f.reserve()
+	if err := backplane.Publish(sse.Event{Data: "2"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if e := backplanetest.Receive(t, events); e.ID != "2" {
+		t.Fatalf("expected event 2, got %+v", e)
+	}
+	f.commit(late, "1")
+	if e := backplanetest.Receive(t, events); e.ID != "1" {
+		t.Fatalf("expected event 1, got %+v", e)
+	}
+	backplanetest.ExpectNone(t, events)
+
+	// A gap that does not fill in time is skipped, but its row is still
+	// delivered until LateTimeout.
+	skipped := f.reserve()
+	f.commit(f.reserve(), "4")
+	if e := backplanetest.Receive(t, events); e.ID != "4" {
+		t.Fatalf("expected event 4, got %+v", e)
+	}
+	time.Sleep(300 * time.Millisecond)
+	f.commit(skipped, "3")
+	f.commit(f.reserve(), "5")
+	ids := []string{backplanetest.Receive(t, events).ID, backplanetest.Receive(t, events).ID}
+	sort.Strings(ids)
+	if !reflect.DeepEqual(ids, []string{"3", "5"}) {
+		t.Errorf("expected events 3 and 5, got %v", ids)
+	}
+	backplanetest.ExpectNone(t, events)
+
+	// A row committed after LateTimeout is lost.
+	lost := f.reserve()
+	f.commit(f.reserve(), "7")
+	if e := backplanetest.Receive(t, events); e.ID != "7" {
+		t.Fatalf("expected event 7, got %+v", e)
+	}
+	time.Sleep(900 * time.Millisecond)
+	f.commit(lost, "6")
+	f.commit(f.reserve(), "8")
+	if e := backplanetest.Receive(t, events); e.ID != "8" {
+		t.Errorf("expected event 8, got %+v", e)
+	}
+	backplanetest.ExpectNone(t, events)
+}
+
+func TestBackplane_Advance(t *testing.T) {
+	var buf bytes.Buffer
+	now := time.Now()
+	b := &Backplane{
+		options: Options{GapTimeout: time.Second},
+		log:     slog.New(slog.NewTextHandler(&buf, nil)),
+		next:    1,
+		seen:    map[int64]struct{}{1: {}, 1000000: {}, 1000002: {}},
+		marks:   []mark{{id: 1000000, at: now.Add(-time.Minute)}, {id: 1000002, at: now}},
+	}
+
+	// A gap that timed out is skipped at once; a recent one is waited for.
+	if !b.advance(now) || b.next != 1000001 {
+		t.Errorf("expected next to move to 1000001, got %d", b.next)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 1 || !strings.Contains(buf.String(), "from=2 to=999999") {
+		t.Errorf("expected the gap to be logged once, got %q", buf.String())
+	}
+	if !reflect.DeepEqual(b.marks, []mark{{id: 1000002, at: now}}) {
+		t.Errorf("expected the passed mark to be dropped, got %+v", b.marks)
+	}
+	if !reflect.DeepEqual(b.skipped, []gap{{from: 2, to: 999999, at: now}}) {
+		t.Errorf("expected the gap to be kept to look for late rows, got %+v", b.skipped)
+	}
+}
+
+func TestBackplane_PollLate(t *testing.T) {
+	f := newFakePostgres(t)
+	for id := int64(1); id <= 20; id++ {
+		if f.reserve(); id == 5 || id == 12 || id == 20 {
+			f.commit(id, strconv.FormatInt(id, 10))
+		}
+	}
+	c, err := dial(Options{Network: "tcp", Addr: f.addr(), User: "app", SSLMode: SSLDisable, DialTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer c.Close()
+
+	now := time.Now()
+	events := make(backplanetest.Writer, 4)
+	b := &Backplane{
+		options:    Options{LateTimeout: time.Minute},
+		statements: f.statements,
+		log:        slogdiscard.Logger(),
+		subs:       make(map[*func(sse.Event)]struct{}),
+		skipped: []gap{
+			{from: 1, to: 3, at: now.Add(-2 * time.Minute)},
+			{from: 4, to: 10, at: now},
+			{from: 20, to: 20, at: now},
+		},
+	}
+	b.Subscribe(func(e sse.Event) { events <- e })
+
+	// Expired gaps are dropped, and the others are split around late rows.
+	if err := b.pollLate(c, now); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if e1, e2 := backplanetest.Receive(t, events), backplanetest.Receive(t, events); e1.ID != "5" || e2.ID != "20" {
+		t.Errorf("expected events 5 and 20, got %+v and %+v", e1, e2)
+	}
+	backplanetest.ExpectNone(t, events)
+	expected := []gap{{from: 4, to: 4, at: now}, {from: 6, to: 10, at: now}}
+	if !reflect.DeepEqual(b.skipped, expected) {
+		t.Errorf("expected gaps %+v, got %+v", expected, b.skipped)
+	}
+}
+
+func TestBackplane_Stall(t *testing.T) {
+	f := newFakePostgres(t)
+	stall := make(chan struct{})
+	defer close(stall)
+	f.setStall(stall)
+	backplane, _ := connect(t, f, Options{})
+
+	// A statement stalled on the server blocks neither the state of the
+	// backplane nor Close, which interrupts it.
+	published := make(chan error, 1)
+	go func() { published <- backplane.Publish(sse.Event{Data: "1"}) }()
+	backplanetest.WaitFor(t, "the insert to stall", func() bool { return f.stalledInserts() == 1 })
+	closed := make(chan struct{})
+	go func() {
+		if !backplane.Connected() {
+			t.Error("expected the backplane to be connected")
+		}
+		backplane.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected Close not to wait for the stalled insert")
+	}
+	select {
+	case err := <-published:
+		if err == nil {
+			t.Error("expected an error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Close to interrupt the stalled insert")
+	}
+}
+
+func TestBackplane_Reconnect(t *testing.T) {
+	f := newFakePostgres(t)
+	backplane, events := connect(t, f, Options{PollInterval: time.Hour})
+	f.commit(f.reserve(), "1")
+	if e := backplanetest.Receive(t, events); e.ID != "1" {
+		t.Fatalf("expected event 1, got %+v", e)
+	}
+
+	// Rows inserted while the listener is disconnected are read when it
+	// reconnects.
+	f.setRefuse(true)
+	f.disconnect()
+	backplanetest.WaitFor(t, "the listener to be lost", func() bool { return !backplane.Connected() })
+	f.commit(f.reserve(), "2")
+	f.commit(f.reserve(), "3")
+	f.setRefuse(false)
+	for _, expected := range []string{"2", "3"} {
+		if e := backplanetest.Receive(t, events); e.ID != expected {
+			t.Fatalf("expected event %s, got %+v", expected, e)
+		}
+	}
+	backplanetest.ExpectNone(t, events)
+
+	backplane.Close()
+	if err := backplane.Publish(sse.Event{Data: "4"}); !errors.Is(err, sse.ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,505 @@
+package ssepostgres
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// maxMessageSize is the maximum size of a message read from the server.
+const maxMessageSize = 64 << 20
+
+// errProtocol is returned for a message that does not follow the protocol.
+var errProtocol = errors.New("ssepostgres: invalid message")
+
+// pgError is an ErrorResponse from the server. It leaves the connection
+// usable.
+type pgError struct {
+	severity, code, message string
+}
+
+func (e *pgError) Error() string {
+	return fmt.Sprintf("ssepostgres: %s: %s (SQLSTATE %s)", e.severity, e.message, e.code)
+}
+
+// conn is a connection to PostgreSQL, speaking version 3 of the protocol.
+type conn struct {
+	c net.Conn
+	r *bufio.Reader
+	w []byte // message being written
+
+	// notified is set when a NotificationResponse is received.
+	notified bool
+}
+
+// dial connects to the server and authenticates.
+func dial(opts Options) (*conn, error) {
+	nc, err := net.DialTimeout(opts.Network, opts.Addr, opts.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = nc.SetDeadline(time.Now().Add(opts.DialTimeout))
+	if opts.Network != "unix" {
+		if nc, err = negotiateTLS(nc, opts); err != nil {
+			return nil, err
+		}
+	}
+	c := &conn{c: nc, r: bufio.NewReader(nc)}
+	if err := c.startup(opts); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	_ = nc.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// sslRequestCode asks the server to switch to TLS.
+const sslRequestCode = 80877103
+
+// negotiateTLS asks the server for TLS as opts.SSLMode requires, and returns
+// the connection to run the protocol on. nc is closed on errors.
+func negotiateTLS(nc net.Conn, opts Options) (net.Conn, error) {
+	switch opts.SSLMode {
+	case SSLDisable:
+		return nc, nil
+	case SSLPrefer, SSLRequire, SSLVerifyCA, SSLVerifyFull:
+	default:
+		nc.Close()
+		return nil, fmt.Errorf("ssepostgres: unknown sslmode %q", opts.SSLMode)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint32(msg[:], 8)
+	binary.BigEndian.PutUint32(msg[4:], sslRequestCode)
+	if _, err := nc.Write(msg[:]); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	// The answer is read without buffering: the TLS handshake follows it.
+	if _, err := io.ReadFull(nc, msg[:1]); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	switch msg[0] {
+	case 'S':
+	case 'N':
+		if opts.SSLMode == SSLPrefer {
+			return nc, nil
+		}
+		nc.Close()
+		return nil, errors.New("ssepostgres: server does not support TLS")
+	default:
+		nc.Close()
+		return nil, errProtocol
+	}
+
+	tc := tls.Client(nc, tlsConfig(opts))
+	if err := tc.Handshake(); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// tlsConfig returns the TLS configuration for opts.SSLMode.
+func tlsConfig(opts Options) *tls.Config {
+	config := &tls.Config{}
+	if opts.TLSConfig != nil {
+		config = opts.TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(opts.Addr); err == nil {
+			config.ServerName = host
+		}
+	}
+	switch opts.SSLMode {
+	case SSLPrefer, SSLRequire:
+		config.InsecureSkipVerify = true
+	case SSLVerifyCA:
+		// The chain is verified, but not the host name.
+		config.InsecureSkipVerify = true
+		roots := config.RootCAs
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("ssepostgres: server sent no certificate")
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range cs.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+				Roots:         roots,
+				Intermediates: intermediates,
+			})
+			return err
+		}
+	}
+	return config
+}
+
+// startup sends the startup message and answers authentication requests
+// until the server is ready for queries.
+func (c *conn) startup(opts Options) error {
+	c.w = binary.BigEndian.AppendUint32(c.w[:0], 0)
+	c.w = binary.BigEndian.AppendUint32(c.w, 3<<16)
+	for _, param := range [][2]string{
+		{"user", opts.User},
+		{"database", opts.Database},
+		{"application_name", "ssepostgres"},
+	} {
+		c.w = appendString(c.w, param[0])
+		c.w = appendString(c.w, param[1])
+	}
+	c.w = append(c.w, 0)
+	binary.BigEndian.PutUint32(c.w, uint32(len(c.w)))
+	if _, err := c.c.Write(c.w); err != nil {
+		return err
+	}
+
+	var scram *scramClient
+	for {
+		typ, body, err := c.receive()
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case 'R':
+			if len(body) < 4 {
+				return errProtocol
+			}
+			auth, data := binary.BigEndian.Uint32(body), body[4:]
+			switch auth {
+			case 0: // AuthenticationOk
+			case 3: // AuthenticationCleartextPassword
+				err = c.send('p', appendString(nil, opts.Password))
+			case 5: // AuthenticationMD5Password
+				if len(data) != 4 {
+					return errProtocol
+				}
+				err = c.send('p', appendString(nil, md5Password(opts.User, opts.Password, data)))
+			case 10: // AuthenticationSASL
+				if !containsString(data, "SCRAM-SHA-256") {
+					return errors.New("ssepostgres: no supported SASL mechanism")
+				}
+				if scram, err = newSCRAMClient(opts.Password); err != nil {
+					return err
+				}
+				first := scram.clientFirst()
+				msg := appendString(nil, "SCRAM-SHA-256")
+				msg = binary.BigEndian.AppendUint32(msg, uint32(len(first)))
+				err = c.send('p', append(msg, first...))
+			case 11: // AuthenticationSASLContinue
+				if scram == nil {
+					return errProtocol
+				}
+				var final string
+				if final, err = scram.clientFinal(string(data)); err == nil {
+					err = c.send('p', []byte(final))
+				}
+			case 12: // AuthenticationSASLFinal
+				if scram == nil {
+					return errProtocol
+				}
+				err = scram.verify(string(data))
+			default:
+				return fmt.Errorf("ssepostgres: unsupported authentication method %d", auth)
+			}
+			if err != nil {
+				return err
+			}
+		case 'E':
+			return parseError(body)
+		case 'Z':
+			return nil
+		}
+	}
+}
+
+// query runs a statement with text parameters, using the extended query
+// protocol, and returns the rows of its result as text. NULL is returned as
+// an empty string.
+func (c *conn) query(sql string, args ...string) ([][]string, error) {
+	parse := appendString(appendString(nil, ""), sql)
+	parse = binary.BigEndian.AppendUint16(parse, 0)
+	bind := appendString(appendString(nil, ""), "")
+	bind = binary.BigEndian.AppendUint16(bind, 0)
+	bind = binary.BigEndian.AppendUint16(bind, uint16(len(args)))
+	for _, arg := range args {
+		bind = binary.BigEndian.AppendUint32(bind, uint32(len(arg)))
+		bind = append(bind, arg...)
+	}
+	bind = binary.BigEndian.AppendUint16(bind, 0)
+	execute := binary.BigEndian.AppendUint32(appendString(nil, ""), 0)
+
+	c.w = c.w[:0]
+	c.append('P', parse)
+	c.append('B', bind)
+	c.append('E', execute)
+	c.append('S', nil)
+	if _, err := c.c.Write(c.w); err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	var queryErr error
+	for {
+		typ, body, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case 'D':
+			row, err := parseDataRow(body)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, row)
+		case 'E':
+			queryErr = parseError(body)
+		case 'Z':
+			return rows, queryErr
+		}
+	}
+}
+
+// wait waits up to d for a notification, and reports whether one arrived.
+func (c *conn) wait(d time.Duration) (bool, error) {
+	deadline := time.Now().Add(d)
+	for !c.notified {
+		_ = c.c.SetReadDeadline(deadline)
+		_, err := c.r.Peek(1)
+		_ = c.c.SetReadDeadline(time.Time{})
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		typ, _, err := c.readMessage()
+		if err != nil {
+			return false, err
+		}
+		if typ == 'A' {
+			c.notified = true
+		}
+	}
+	c.notified = false
+	return true, nil
+}
+
+// receive reads a message, handling the asynchronous ones: notifications
+// set c.notified, and notices and parameter changes are ignored.
+func (c *conn) receive() (byte, []byte, error) {
+	for {
+		typ, body, err := c.readMessage()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch typ {
+		case 'A':
+			c.notified = true
+		case 'N', 'S':
+		default:
+			return typ, body, nil
+		}
+	}
+}
+
+// readMessage reads a message.
+func (c *conn) readMessage() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size < 4 || size > maxMessageSize {
+		return 0, nil, errProtocol
+	}
+	body := make([]byte, size-4)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, err
+	}
+	return header[0], body, nil
+}
+
+// send writes a message.
+func (c *conn) send(typ byte, body []byte) error {
+	c.w = c.w[:0]
+	c.append(typ, body)
+	_, err := c.c.Write(c.w)
+	return err
+}
+
+// append appends a message to c.w.
+func (c *conn) append(typ byte, body []byte) {
+	c.w = append(c.w, typ)
+	c.w = binary.BigEndian.AppendUint32(c.w, uint32(len(body)+4))
+	c.w = append(c.w, body...)
+}
+
+// Close terminates the connection.
+func (c *conn) Close() error {
+	_ = c.send('X', nil)
+	return c.c.Close()
+}
+
+// appendString appends a NUL-terminated string.
+func appendString(b []byte, s string) []byte {
+	return append(append(b, s...), 0)
+}
+
+// containsString reports whether a list of NUL-terminated strings contains s.
+func containsString(list []byte, s string) bool {
+	for _, item := range strings.Split(string(list), "\x00") {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDataRow parses the columns of a DataRow message.
+func parseDataRow(body []byte) ([]string, error) {
+	if len(body) < 2 {
+		return nil, errProtocol
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	body = body[2:]
+	row := make([]string, n)
+	for i := range row {
+		if len(body) < 4 {
+			return nil, errProtocol
+		}
+		size := int32(binary.BigEndian.Uint32(body))
+		body = body[4:]
+		if size < 0 {
+			continue
+		}
+		if int(size) > len(body) {
+			return nil, errProtocol
+		}
+		row[i], body = string(body[:size]), body[size:]
+	}
+	return row, nil
+}
+
+// parseError parses an ErrorResponse message.
+func parseError(body []byte) error {
+	e := &pgError{}
+	for len(body) > 1 {
+		field := body[0]
+		value, rest, ok := strings.Cut(string(body[1:]), "\x00")
+		if !ok {
+			break
+		}
+		switch field {
+		case 'S':
+			e.severity = value
+		case 'C':
+			e.code = value
+		case 'M':
+			e.message = value
+		}
+		body = []byte(rest)
+	}
+	return e
+}
+
+// md5Password returns the response to an MD5 authentication request.
+func md5Password(user, password string, salt []byte) string {
+	inner := md5.Sum([]byte(password + user))
+	outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt...))
+	return "md5" + hex.EncodeToString(outer[:])
+}
+
+// scramClient runs the client side of a SCRAM-SHA-256 exchange (RFC 7677),
+// without channel binding. PostgreSQL ignores the user name in the exchange.
+type scramClient struct {
+	password    string
+	nonce       string
+	firstBare   string
+	authMessage string
+	salted      []byte
+}
+
+// newSCRAMClient creates a scramClient with a random nonce.
+func newSCRAMClient(password string) (*scramClient, error) {
+	nonce := make([]byte, 18)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &scramClient{password: password, nonce: base64.StdEncoding.EncodeToString(nonce)}, nil
+}
+
+// clientFirst returns the client-first-message.
+func (s *scramClient) clientFirst() string {
+	s.firstBare = "n=,r=" + s.nonce
+	return "n,," + s.firstBare
+}
+
+// clientFinal returns the client-final-message answering serverFirst.
+func (s *scramClient) clientFinal(serverFirst string) (string, error) {
+	var nonce, salt string
+	var iterations int
+	for _, attr := range strings.Split(serverFirst, ",") {
+		key, value, _ := strings.Cut(attr, "=")
+		switch key {
+		case "r":
+			nonce = value
+		case "s":
+			salt = value
+		case "i":
+			if _, err := fmt.Sscan(value, &iterations); err != nil {
+				return "", errProtocol
+			}
+		}
+	}
+	saltBytes, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil || !strings.HasPrefix(nonce, s.nonce) || iterations <= 0 {
+		return "", errProtocol
+	}
+
+	s.salted = pbkdf2.Key([]byte(s.password), saltBytes, iterations, sha256.Size, sha256.New)
+	withoutProof := "c=biws,r=" + nonce
+	s.authMessage = s.firstBare + "," + serverFirst + "," + withoutProof
+	clientKey := hmacSHA256(s.salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	proof := hmacSHA256(storedKey[:], s.authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// verify checks the server signature of the server-final-message.
+func (s *scramClient) verify(serverFinal string) error {
+	signature, ok := strings.CutPrefix(serverFinal, "v=")
+	expected := hmacSHA256(hmacSHA256(s.salted, "Server Key"), s.authMessage)
+	if !ok || signature != base64.StdEncoding.EncodeToString(expected) {
+		return errors.New("ssepostgres: invalid server signature")
+	}
+	return nil
+}
+
+// hmacSHA256 returns the HMAC-SHA-256 of message.
+func hmacSHA256(key []byte, message string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(message))
+	return h.Sum(nil)
+}
@@ -0,0 +1,516 @@
+package ssepostgres
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMD5Password(t *testing.T) {
+	actual := md5Password("postgres", "secret", []byte{1, 2, 3, 4})
+	if expected := "md5bb41a296aab6baccb36ff243a562abff"; actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+}
+
+func TestSCRAMClient(t *testing.T) {
+	// The example of RFC 7677, section 3.
+	s := &scramClient{
+		password:  "pencil",
+		nonce:     "rOprNGfwEbeRWgbNEkqO",
+		firstBare: "n=user,r=rOprNGfwEbeRWgbNEkqO",
+	}
+	final, err := s.clientFinal("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	if final != expected {
+		t.Errorf("expected %s, got %s", expected, final)
+	}
+	if err := s.verify("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if err := s.verify("v=AAAA"); err == nil {
+		t.Error("expected an invalid signature")
+	}
+
+	if _, err := s.clientFinal("r=other,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"); !errors.Is(err, errProtocol) {
+		t.Errorf("expected errProtocol for a foreign nonce, got %v", err)
+	}
+}
+
+// newCertificate returns a self-signed certificate for hosts, and a pool that
+// trusts it.
+func newCertificate(t *testing.T, hosts ...string) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake postgres"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestDial_TLS(t *testing.T) {
+	tests := []struct {
+		name    string
+		hosts   []string // of the certificate, if the server supports TLS
+		mode    string
+		trusted bool
+		ok      bool
+	}{
+		{"prefer, no TLS", nil, SSLPrefer, false, true},
+		{"require, no TLS", nil, SSLRequire, false, false},
+		{"disable", []string{"127.0.0.1"}, SSLDisable, false, true},
+		{"prefer", []string{"127.0.0.1"}, SSLPrefer, false, true},
+		{"require", []string{"127.0.0.1"}, SSLRequire, false, true},
+		{"verify-ca", []string{"db.internal"}, SSLVerifyCA, true, true},
+		{"verify-ca, untrusted", []string{"db.internal"}, SSLVerifyCA, false, false},
+		{"verify-full", []string{"127.0.0.1"}, SSLVerifyFull, true, true},
+		{"verify-full, untrusted", []string{"127.0.0.1"}, SSLVerifyFull, false, false},
+		{"verify-full, other host", []string{"db.internal"}, SSLVerifyFull, true, false},
+		{"unknown", nil, "always", false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFakePostgres(t)
+			opts := Options{Network: "tcp", Addr: f.addr(), User: "app", SSLMode: test.mode, DialTimeout: time.Second}
+			if test.hosts != nil {
+				cert, pool := newCertificate(t, test.hosts...)
+				f.setTLS(&tls.Config{Certificates: []tls.Certificate{cert}})
+				if test.trusted {
+					opts.TLSConfig = &tls.Config{RootCAs: pool}
+				} else {
+					opts.TLSConfig = &tls.Config{RootCAs: x509.NewCertPool()}
+				}
+			}
+
+			c, err := dial(opts)
+			if !test.ok {
+				if err == nil {
+					c.c.Close()
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			defer c.c.Close()
+			_, encrypted := c.c.(*tls.Conn)
+			if expected := test.hosts != nil && test.mode != SSLDisable; encrypted != expected {
+				t.Errorf("expected TLS %v, got %v", expected, encrypted)
+			}
+		})
+	}
+}
+
+// fakePostgres is an in-process stand-in for a PostgreSQL server, running
+// the statements of a Backplane on an in-memory outbox table.
+type fakePostgres struct {
+	listener   net.Listener
+	statements statements
+
+	mu        sync.Mutex
+	password  string
+	nextID    int64
+	rows      map[int64][]string
+	listeners map[string]map[*fakeClient]struct{}
+	clients   map[*fakeClient]struct{}
+	refuse    bool
+	tls       *tls.Config
+	stall     chan struct{}
+	stalled   int
+}
+
+// fakeClient is a connection to a fakePostgres.
+type fakeClient struct {
+	conn net.Conn
+	mu   sync.Mutex // guards writes
+}
+
+// newFakePostgres serves a fakePostgres for the default table on a local
+// port until the test ends.
+func newFakePostgres(t *testing.T) *fakePostgres {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	f := &fakePostgres{
+		listener:   l,
+		statements: newStatements("sse_outbox", "sse_outbox"),
+		nextID:     1,
+		rows:       make(map[int64][]string),
+		listeners:  make(map[string]map[*fakeClient]struct{}),
+		clients:    make(map[*fakeClient]struct{}),
+	}
+	go f.serve()
+	t.Cleanup(func() {
+		l.Close()
+		f.disconnect()
+	})
+	return f
+}
+
+// addr returns the address of the server.
+func (f *fakePostgres) addr() string {
+	return f.listener.Addr().String()
+}
+
+// setPassword sets the password required, with MD5 authentication, from new
+// connections.
+func (f *fakePostgres) setPassword(password string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.password = password
+}
+
+// setTLS sets the configuration of TLS, which is refused if nil.
+func (f *fakePostgres) setTLS(config *tls.Config) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tls = config
+}
+
+// setRefuse sets whether new connections are closed at once.
+func (f *fakePostgres) setRefuse(refuse bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.refuse = refuse
+}
+
+// setStall makes inserts wait until stall is closed, if it is not nil.
+func (f *fakePostgres) setStall(stall chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stall = stall
+}
+
+// stalledInserts returns the number of inserts that have waited on a stall.
+func (f *fakePostgres) stalledInserts() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stalled
+}
+
+// disconnect closes every client connection.
+func (f *fakePostgres) disconnect() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for c := range f.clients {
+		c.conn.Close()
+	}
+}
+
+// reserve takes the next id without inserting a row, like a transaction
+// that has not committed yet.
+func (f *fakePostgres) reserve() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	return f.nextID - 1
+}
+
+// commit inserts a row with a reserved id and notifies the listeners.
+func (f *fakePostgres) commit(id int64, data string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.insertLocked(id, []string{"", "", data}, "sse_outbox")
+}
+
+// insertLocked inserts a row and notifies a channel. The caller must hold
+// f.mu.
+func (f *fakePostgres) insertLocked(id int64, values []string, channel string) {
+	f.rows[id] = append([]string{strconv.FormatInt(id, 10)}, values...)
+	payload := strconv.FormatInt(id, 10)
+	msg := binary.BigEndian.AppendUint32(nil, 1)
+	msg = appendString(appendString(msg, channel), payload)
+	for c := range f.listeners[channel] {
+		c.send('A', msg)
+	}
+}
+
+func (f *fakePostgres) serve() {
+	for {
+		nc, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		if f.refuse {
+			f.mu.Unlock()
+			nc.Close()
+			continue
+		}
+		c := &fakeClient{conn: nc}
+		f.clients[c] = struct{}{}
+		f.mu.Unlock()
+		go f.handle(c)
+	}
+}
+
+// handle authenticates a client and runs its statements.
+func (f *fakePostgres) handle(c *fakeClient) {
+	defer func() {
+		f.mu.Lock()
+		delete(f.clients, c)
+		for _, listeners := range f.listeners {
+			delete(listeners, c)
+		}
+		f.mu.Unlock()
+		c.conn.Close()
+	}()
+	var header [8]byte
+	if _, err := io.ReadFull(c.conn, header[:]); err != nil {
+		return
+	}
+	if binary.BigEndian.Uint32(header[4:]) == sslRequestCode {
+		f.mu.Lock()
+		config := f.tls
+		f.mu.Unlock()
+		if config == nil {
+			c.conn.Write([]byte{'N'})
+		} else {
+			c.conn.Write([]byte{'S'})
+			tc := tls.Server(c.conn, config)
+			if err := tc.Handshake(); err != nil {
+				return
+			}
+			f.mu.Lock()
+			c.mu.Lock()
+			c.conn = tc
+			c.mu.Unlock()
+			f.mu.Unlock()
+		}
+		if _, err := io.ReadFull(c.conn, header[:]); err != nil {
+			return
+		}
+	}
+	r := &conn{c: c.conn, r: bufio.NewReader(c.conn)}
+	startup := make([]byte, binary.BigEndian.Uint32(header[:])-8)
+	if _, err := io.ReadFull(r.r, startup); err != nil {
+		return
+	}
+	params := strings.Split(string(startup), "\x00")
+	var user string
+	for i := 0; i+1 < len(params); i += 2 {
+		if params[i] == "user" {
+			user = params[i+1]
+		}
+	}
+	f.mu.Lock()
+	password := f.password
+	f.mu.Unlock()
+	if password != "" {
+		salt := []byte{1, 2, 3, 4}
+		c.send('R', append(binary.BigEndian.AppendUint32(nil, 5), salt...))
+		typ, body, err := r.readMessage()
+		if err != nil || typ != 'p' || string(body) != md5Password(user, password, salt)+"\x00" {
+			c.sendError("28P01", "password authentication failed")
+			return
+		}
+	}
+	c.send('R', binary.BigEndian.AppendUint32(nil, 0))
+	c.send('S', appendString(appendString(nil, "server_version"), "16.0"))
+	c.send('Z', []byte{'I'})
+
+	var sql string
+	var args []string
+	failed := false
+	for {
+		typ, body, err := r.readMessage()
+		if err != nil {
+			return
+		}
+		switch typ {
+		case 'P':
+			sql, _, _ = strings.Cut(string(body[1:]), "\x00")
+		case 'B':
+			args = parseBind(body)
+		case 'E':
+			if failed {
+				continue
+			}
+			f.mu.Lock()
+			stall := f.stall
+			if stall != nil && sql == f.statements.insert {
+				f.stalled++
+				f.mu.Unlock()
+				<-stall
+			} else {
+				f.mu.Unlock()
+			}
+			rows, err := f.run(c, sql, args)
+			if err != nil {
+				c.sendError("42601", err.Error())
+				failed = true
+				continue
+			}
+			for _, row := range rows {
+				msg := binary.BigEndian.AppendUint16(nil, uint16(len(row)))
+				for _, col := range row {
+					msg = binary.BigEndian.AppendUint32(msg, uint32(len(col)))
+					msg = append(msg, col...)
+				}
+				c.send('D', msg)
+			}
+			c.send('C', appendString(nil, "OK"))
+		case 'S':
+			failed = false
+			c.send('Z', []byte{'I'})
+		case 'X':
+			return
+		}
+	}
+}
+
+// run runs a statement of the backplane.
+func (f *fakePostgres) run(c *fakeClient, sql string, args []string) ([][]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch sql {
+	case f.statements.listen:
+		if f.listeners["sse_outbox"] == nil {
+			f.listeners["sse_outbox"] = make(map[*fakeClient]struct{})
+		}
+		f.listeners["sse_outbox"][c] = struct{}{}
+		return nil, nil
+	case f.statements.insert:
+		id := f.nextID
+		f.nextID++
+		f.insertLocked(id, args[:3], args[3])
+		return [][]string{{""}}, nil
+	case f.statements.max:
+		var newest int64
+		for id := range f.rows {
+			newest = max(newest, id)
+		}
+		return [][]string{{strconv.FormatInt(newest, 10)}}, nil
+	case f.statements.poll:
+		from, _ := strconv.ParseInt(args[0], 10, 64)
+		return f.selectLocked(from, pollLimit, false), nil
+	case f.statements.late:
+		from, to := parseArray(args[0]), parseArray(args[1])
+		var rows [][]string
+		for _, row := range f.selectLocked(0, len(f.rows), false) {
+			id, _ := strconv.ParseInt(row[0], 10, 64)
+			for i := range from {
+				if id >= from[i] && id <= to[i] && len(rows) < pollLimit {
+					rows = append(rows, row)
+				}
+			}
+		}
+		return rows, nil
+	case f.statements.since:
+		from, _ := strconv.ParseInt(args[0], 10, 64)
+		limit, _ := strconv.Atoi(args[1])
+		return f.selectLocked(from, limit, true), nil
+	}
+	return nil, errors.New("syntax error")
+}
+
+// selectLocked returns up to limit rows from the id from on, in id order.
+// The caller must hold f.mu.
+func (f *fakePostgres) selectLocked(from int64, limit int, descending bool) [][]string {
+	var ids []int64
+	for id := range f.rows {
+		if id >= from {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return (ids[i] < ids[j]) != descending })
+	var rows [][]string
+	for _, id := range ids {
+		if len(rows) == limit {
+			break
+		}
+		rows = append(rows, f.rows[id])
+	}
+	return rows
+}
+
+// parseArray parses the text form of a bigint array.
+func parseArray(s string) []int64 {
+	var values []int64
+	for _, item := range strings.Split(strings.Trim(s, "{}"), ",") {
+		if v, err := strconv.ParseInt(item, 10, 64); err == nil {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// parseBind returns the text parameters of a Bind message.
+func parseBind(body []byte) []string {
+	_, rest, _ := strings.Cut(string(body), "\x00") // portal
+	_, rest, _ = strings.Cut(rest, "\x00")          // statement
+	b := []byte(rest)
+	b = b[2+2*int(binary.BigEndian.Uint16(b)):] // format codes
+	args := make([]string, binary.BigEndian.Uint16(b))
+	b = b[2:]
+	for i := range args {
+		size := binary.BigEndian.Uint32(b)
+		args[i], b = string(b[4:4+size]), b[4+size:]
+	}
+	return args
+}
+
+// send writes a message to the client.
+func (c *fakeClient) send(typ byte, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msg := binary.BigEndian.AppendUint32([]byte{typ}, uint32(len(body)+4))
+	c.conn.Write(append(msg, body...))
+}
+
+// sendError writes an ErrorResponse to the client.
+func (c *fakeClient) sendError(code, message string) {
+	var body []byte
+	body = appendString(append(body, 'S'), "ERROR")
+	body = appendString(append(body, 'C'), code)
+	body = appendString(append(body, 'M'), message)
+	c.send('E', append(body, 0))
+}
@@ -0,0 +1,501 @@
+// Package sseredis implements an sse.Backplane over Redis, for running a
+// Broker on several instances that share a Redis server:
+//
+//	backplane := sseredis.New(sseredis.Options{Addr: "redis:6379"})
+//	defer backplane.Close()
+//	broker := sse.NewBroker(sse.BrokerOptions{Backplane: backplane})
+//
+// Every event is appended to a Redis stream, with XADD, and published on a
+// Pub/Sub channel, by one script so that both see the events in the same
+// order. Instances receive the events from the channel. The stream keeps the
+// recent events durably: clients resume from it when their Last-Event-ID is
+// no longer buffered by the broker, and an instance that lost its
+// subscription catches up from it when it reconnects.
+//
+// Events take the id of their stream entry, such as "1718000000000-0", in
+// place of the id assigned by the broker.
+package sseredis
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/floriscornel/sse"
+	"github.com/floriscornel/sse/internal/slogdiscard"
+)
+
+// commandTimeout bounds the time to send a command and read its reply.
+const commandTimeout = 10 * time.Second
+
+// catchUpCount is the number of entries read at once when catching up.
+const catchUpCount = 1000
+
+// maxIdleConns is the number of command connections kept open between
+// commands.
+const maxIdleConns = 4
+
+// publishScript appends an event to the stream KEYS[1], trimmed to about
+// ARGV[1] entries, and publishes it with its id on the channel ARGV[2].
+const publishScript = `local id = redis.call('XADD', KEYS[1], 'MAXLEN', '~', ARGV[1], '*', 'e', ARGV[3])
+redis.call('PUBLISH', ARGV[2], id .. ' ' .. ARGV[3])
+return id`
+
+// Options holds configuration for a Backplane.
+type Options struct {
+	// Network and Addr locate the Redis server. If empty, "tcp" and
+	// "localhost:6379" are used.
+	Network string
+	Addr    string
+
+	// Username and Password authenticate with AUTH, if Password is set.
+	Username string
+	Password string
+
+	// DB is the database selected with SELECT, if not zero.
+	DB int
+
+	// Stream is the key of the stream keeping the events. If empty, "sse"
+	// is used. Brokers sharing a stream share their events.
+	Stream string
+
+	// Channel is the Pub/Sub channel of the events. If empty, Stream is
+	// used.
+	Channel string
+
+	// MaxLen is the approximate number of events kept in the stream. If
+	// zero, 10000 is used.
+	MaxLen int
+
+	// RetryDelay is the delay before reconnecting to Redis. If zero, one
+	// second is used.
+	RetryDelay time.Duration
+
+	// DialTimeout bounds each attempt to connect. If zero, five seconds is
+	// used.
+	DialTimeout time.Duration
+
+	// PingInterval is the interval at which the subscription connection is
+	// checked with PING. The subscription is considered lost when nothing is
+	// received from Redis for two intervals. If zero, 30 seconds is used.
+	PingInterval time.Duration
+
+	// Logger receives records about the subscription. If nil, nothing is
+	// logged.
+	Logger *slog.Logger
+}
+
+// entry is the JSON representation of an event in the stream and on the
+// channel, where its id is kept by Redis.
+type entry struct {
+	Event string `json:"event,omitempty"`
+	Topic string `json:"topic,omitempty"`
+	Data  string `json:"data"`
+}
+
+// Backplane is an sse.Backplane and sse.BackplaneHistory over Redis. It runs
+// commands on a small pool of connections, so that concurrent commands do not
+// wait for each other, and keeps one connection for its subscription,
+// reconnecting when it is lost.
+type Backplane struct {
+	options Options
+	log     *slog.Logger
+
+	mu         sync.Mutex // guards the fields below
+	idle       []*conn
+	active     map[*conn]struct{}
+	sub        *conn
+	subscribed bool
+	closed     bool
+	done       chan struct{}
+
+	subsMu sync.Mutex // held while events are passed to subscribers
+	subs   map[*func(sse.Event)]struct{}
+
+	lastID string // of the last event received, used by run only
+}
+
+// New creates a Backplane for the Redis server of opts. It subscribes in the
+// background.
+func New(opts Options) *Backplane {
+	if opts.Network == "" {
+		opts.Network = "tcp"
+	}
+	if opts.Addr == "" {
+		opts.Addr = "localhost:6379"
+	}
+	if opts.Stream == "" {
+		opts.Stream = "sse"
+	}
+	if opts.Channel == "" {
+		opts.Channel = opts.Stream
+	}
+	if opts.MaxLen <= 0 {
+		opts.MaxLen = 10000
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = time.Second
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.PingInterval <= 0 {
+		opts.PingInterval = 30 * time.Second
+	}
+	log := opts.Logger
+	if log == nil {
+		log = slogdiscard.Logger()
+	}
+	b := &Backplane{
+		options: opts,
+		log:     log.With(slog.String("redis", opts.Addr), slog.String("channel", opts.Channel)),
+		active:  make(map[*conn]struct{}),
+		done:    make(chan struct{}),
+		subs:    make(map[*func(sse.Event)]struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Publish appends an event to the stream and publishes it to every instance.
+// The id of the event is replaced by the id of its stream entry.
+func (b *Backplane) Publish(e sse.Event) error {
+	payload, err := json.Marshal(entry{Event: e.Event, Topic: e.Topic, Data: e.Data})
+	if err != nil {
+		return err
+	}
+	_, err = b.command("EVAL", publishScript, "1", b.options.Stream,
+		strconv.Itoa(b.options.MaxLen), b.options.Channel, string(payload))
+	return err
+}
+
+// Since returns the events of the stream after the one with the given id. An
+// id that is not a stream entry id is not found.
+func (b *Backplane) Since(lastEventID string, limit int) ([]sse.Event, bool, error) {
+	if _, _, ok := parseID(lastEventID); !ok {
+		return nil, false, nil
+	}
+	reply, err := b.command("XREVRANGE", b.options.Stream, "+", lastEventID, "COUNT", strconv.Itoa(limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	events, err := decodeEntries(reply)
+	if err != nil {
+		return nil, false, err
+	}
+
+	found := len(events) > 0 && events[len(events)-1].ID == lastEventID
+	if found {
+		events = events[:len(events)-1]
+	} else if len(events) > limit {
+		events = events[:limit]
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, found, nil
+}
+
+// command sends a command on an idle connection, or on a new one if there is
+// none, and returns the connection to the pool once it has the reply.
+func (b *Backplane) command(args ...string) (interface{}, error) {
+	c, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	_ = c.c.SetDeadline(time.Now().Add(commandTimeout))
+	reply, err := c.do(args...)
+	_, ok := err.(redisError)
+	b.put(c, err == nil || ok)
+	return reply, err
+}
+
+// get takes an idle command connection, or dials a new one.
+func (b *Backplane) get() (*conn, error) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil, sse.ErrClosed
+	}
+	if n := len(b.idle); n > 0 {
+		c := b.idle[n-1]
+		b.idle = b.idle[:n-1]
+		b.active[c] = struct{}{}
+		b.mu.Unlock()
+		return c, nil
+	}
+	b.mu.Unlock()
+
+	c, err := dial(b.options)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		c.Close()
+		return nil, sse.ErrClosed
+	}
+	b.active[c] = struct{}{}
+	return c, nil
+}
+
+// put returns a command connection to the pool, or closes it if it is not
+// reusable or the pool is full.
+func (b *Backplane) put(c *conn, reusable bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.active, c)
+	if !reusable || b.closed || len(b.idle) >= maxIdleConns {
+		c.Close()
+		return
+	}
+	b.idle = append(b.idle, c)
+}
+
+// Subscribe calls fn with every event received from Redis, until cancel is
+// called. fn must not block.
+func (b *Backplane) Subscribe(fn func(sse.Event)) (cancel func()) {
+	b.subsMu.Lock()
+	defer b.subsMu.Unlock()
+	key := &fn
+	b.subs[key] = struct{}{}
+	return func() {
+		b.subsMu.Lock()
+		defer b.subsMu.Unlock()
+		delete(b.subs, key)
+	}
+}
+
+// Connected reports whether the backplane is subscribed to the channel.
+func (b *Backplane) Connected() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.subscribed
+}
+
+// Close closes the connections to Redis.
+func (b *Backplane) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	for _, c := range b.idle {
+		c.Close()
+	}
+	b.idle = nil
+	for c := range b.active {
+		c.Close()
+	}
+	if b.sub != nil {
+		b.sub.Close()
+	}
+	return nil
+}
+
+// run subscribes to the channel until the backplane is closed.
+func (b *Backplane) run() {
+	for {
+		err := b.listen()
+		select {
+		case <-b.done:
+			return
+		default:
+		}
+		b.log.Warn("sseredis subscription lost", slog.Any("error", err))
+
+		timer := time.NewTimer(b.options.RetryDelay)
+		select {
+		case <-b.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// listen subscribes to the channel, catches up with the events missed since
+// the last one received, and passes the events to the subscribers until the
+// subscription is lost. It sends a PING every PingInterval, and gives up on
+// the connection when nothing arrives for two intervals.
+func (b *Backplane) listen() error {
+	c, err := dial(b.options)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		c.Close()
+		return nil
+	}
+	b.sub = c
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.sub, b.subscribed = nil, false
+		b.mu.Unlock()
+		c.Close()
+	}()
+
+	_ = c.c.SetDeadline(time.Now().Add(commandTimeout))
+	if _, err := c.do("SUBSCRIBE", b.options.Channel); err != nil {
+		return err
+	}
+	_ = c.c.SetDeadline(time.Time{})
+	stop := make(chan struct{})
+	defer close(stop)
+	go b.ping(c, stop)
+	if b.lastID != "" {
+		if err := b.catchUp(); err != nil {
+			return err
+		}
+	}
+	b.mu.Lock()
+	b.subscribed = true
+	b.mu.Unlock()
+	b.log.Info("sseredis subscribed")
+
+	for {
+		_ = c.c.SetReadDeadline(time.Now().Add(2 * b.options.PingInterval))
+		reply, err := c.receive()
+		if err != nil {
+			return err
+		}
+		message, ok := reply.([]interface{})
+		if !ok || len(message) != 3 || message[0] != "message" {
+			continue
+		}
+		payload, _ := message[2].(string)
+		id, data, _ := strings.Cut(payload, " ")
+		e, err := decodeEntry(id, data)
+		if err != nil {
+			b.log.Warn("sseredis invalid event", slog.Any("error", err))
+			continue
+		}
+		// Skip the events already received while catching up.
+		if newer(e.ID, b.lastID) {
+			b.deliver(e)
+		}
+	}
+}
+
+// ping sends PING on the subscription connection every PingInterval until
+// stop is closed. Redis replies with a "pong" message, which keeps the read
+// deadline of listen from expiring.
+func (b *Backplane) ping(c *conn, stop <-chan struct{}) {
+	ticker := time.NewTicker(b.options.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		_ = c.c.SetWriteDeadline(time.Now().Add(commandTimeout))
+		if err := c.send("PING"); err != nil {
+			// The read fails as well, and listen returns.
+			c.Close()
+			return
+		}
+	}
+}
+
+// catchUp passes the events of the stream after the last one received to
+// the subscribers.
+func (b *Backplane) catchUp() error {
+	for {
+		reply, err := b.command("XRANGE", b.options.Stream, b.lastID, "+", "COUNT", strconv.Itoa(catchUpCount))
+		if err != nil {
+			return err
+		}
+		events, err := decodeEntries(reply)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			if newer(e.ID, b.lastID) {
+				b.deliver(e)
+			}
+		}
+		if len(events) < catchUpCount {
+			return nil
+		}
+	}
+}
+
+// deliver passes an event to the subscribers.
+func (b *Backplane) deliver(e sse.Event) {
+	b.lastID = e.ID
+	b.subsMu.Lock()
+	defer b.subsMu.Unlock()
+	for fn := range b.subs {
+		(*fn)(e)
+	}
+}
+
+// decodeEntries decodes the reply of XRANGE or XREVRANGE.
+func decodeEntries(reply interface{}) ([]sse.Event, error) {
+	entries, ok := reply.([]interface{})
+	if !ok && reply != nil {
+		return nil, errProtocol
+	}
+	events := make([]sse.Event, 0, len(entries))
+	for _, raw := range entries {
+		item, ok := raw.([]interface{})
+		if !ok || len(item) != 2 {
+			return nil, errProtocol
+		}
+		id, _ := item[0].(string)
+		fields, _ := item[1].([]interface{})
+		var data string
+		for i := 0; i+1 < len(fields); i += 2 {
+			if fields[i] == "e" {
+				data, _ = fields[i+1].(string)
+			}
+		}
+		e, err := decodeEntry(id, data)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// decodeEntry decodes the JSON representation of an event with the given id.
+func decodeEntry(id, data string) (sse.Event, error) {
+	var en entry
+	if err := json.Unmarshal([]byte(data), &en); err != nil {
+		return sse.Event{}, err
+	}
+	return sse.Event{ID: id, Event: en.Event, Topic: en.Topic, Data: en.Data}, nil
+}
+
+// parseID parses a stream entry id, such as "1718000000000-0".
+func parseID(id string) (ms, seq uint64, ok bool) {
+	msPart, seqPart, found := strings.Cut(id, "-")
+	if !found {
+		return 0, 0, false
+	}
+	ms, err1 := strconv.ParseUint(msPart, 10, 64)
+	seq, err2 := strconv.ParseUint(seqPart, 10, 64)
+	return ms, seq, err1 == nil && err2 == nil
+}
+
+// newer reports whether the stream entry id a comes after b. Every id is
+// newer than an empty one.
+func newer(a, b string) bool {
+	if b == "" {
+		return true
+	}
+	aMS, aSeq, _ := parseID(a)
+	bMS, bSeq, _ := parseID(b)
+	return aMS > bMS || aMS == bMS && aSeq > bSeq
+}
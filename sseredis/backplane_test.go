@@ -0,0 +1,192 @@
+package sseredis
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/floriscornel/sse"
+	"github.com/floriscornel/sse/internal/backplanetest"
+)
+
+// connect creates a Backplane connected to f, closed when the test ends.
+func connect(t *testing.T, f *fakeRedis, opts Options) *Backplane {
+	t.Helper()
+	opts.Addr = f.addr()
+	opts.RetryDelay = 10 * time.Millisecond
+	b := New(opts)
+	t.Cleanup(func() { b.Close() })
+	backplanetest.WaitFor(t, "the subscription", b.Connected)
+	return b
+}
+
+func TestBackplane(t *testing.T) {
+	f := newFakeRedis(t)
+	f.setPassword("secret")
+	opts := Options{Password: "secret", DB: 2, Stream: "events"}
+
+	a := sse.NewBroker(sse.BrokerOptions{Backplane: connect(t, f, opts)})
+	defer a.Close()
+	b := sse.NewBroker(sse.BrokerOptions{Backplane: connect(t, f, opts)})
+	defer b.Close()
+	onA, onB := make(backplanetest.Writer, 4), make(backplanetest.Writer, 4)
+	a.Subscribe(onA, "")
+	b.Subscribe(onB, "")
+
+	if err := a.PublishTopic("orders.42", "add", 42); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	fromA, fromB := backplanetest.Receive(t, onA), backplanetest.Receive(t, onB)
+	expected := sse.Event{ID: "1-0", Event: "add", Topic: "orders.42", Data: "42"}
+	if fromA != expected || fromB != expected {
+		t.Errorf("expected both instances to receive %+v, got %+v and %+v", expected, fromA, fromB)
+	}
+
+	f.setPassword("other")
+	f.disconnect()
+	if err := a.Publish("add", 1); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestBackplane_History(t *testing.T) {
+	f := newFakeRedis(t)
+	backplane := connect(t, f, Options{MaxLen: 4})
+	for i := 1; i <= 5; i++ {
+		if err := backplane.Publish(sse.Event{Data: "x"}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	ids := func(events []sse.Event) []string {
+		var ids []string
+		for _, e := range events {
+			ids = append(ids, e.ID)
+		}
+		return ids
+	}
+	tests := []struct {
+		lastEventID string
+		limit       int
+		expected    []string
+		found       bool
+	}{
+		{"2-0", 10, []string{"3-0", "4-0", "5-0"}, true},
+		{"2-0", 3, []string{"3-0", "4-0", "5-0"}, true},
+		{"2-0", 2, []string{"4-0", "5-0"}, false},
+		{"5-0", 10, nil, true},
+		{"1-0", 10, []string{"2-0", "3-0", "4-0", "5-0"}, false},
+		{"abc-42", 10, nil, false},
+	}
+	for _, test := range tests {
+		events, found, err := backplane.Since(test.lastEventID, test.limit)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(ids(events), test.expected) || found != test.found {
+			t.Errorf("%s (%d): expected %v (%v), got %v (%v)",
+				test.lastEventID, test.limit, test.expected, test.found, ids(events), found)
+		}
+	}
+
+	// A broker that just started resumes clients from the stream.
+	broker := sse.NewBroker(sse.BrokerOptions{Backplane: backplane})
+	defer broker.Close()
+	w := make(backplanetest.Writer, 4)
+	_, complete := broker.Subscribe(w, "3-0")
+	if !complete || backplanetest.Receive(t, w).ID != "4-0" || backplanetest.Receive(t, w).ID != "5-0" {
+		t.Errorf("expected events 4-0 and 5-0 to be replayed (complete %v)", complete)
+	}
+}
+
+func TestBackplane_Concurrent(t *testing.T) {
+	f := newFakeRedis(t)
+	b := connect(t, f, Options{})
+	if err := b.Publish(sse.Event{Data: "1"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// A slow command does not hold up the others.
+	hold := make(chan struct{})
+	f.setHold(hold)
+	done := make(chan error)
+	go func() {
+		_, _, err := b.Since("1-0", 10)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := b.Publish(sse.Event{Data: "2"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n := f.connections(); n != 3 {
+		t.Errorf("expected a second command connection, got %d connections", n)
+	}
+	close(hold)
+	if err := <-done; err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestBackplane_Ping(t *testing.T) {
+	f := newFakeRedis(t)
+	a := connect(t, f, Options{PingInterval: 10 * time.Millisecond})
+	events := make(backplanetest.Writer, 4)
+	a.Subscribe(func(e sse.Event) { events <- e })
+
+	// A subscription that is kept alive stays on its connection.
+	time.Sleep(50 * time.Millisecond)
+	if n := f.subscriptions(); n != 1 {
+		t.Fatalf("expected 1 subscription, got %d", n)
+	}
+
+	// A connection that stops answering is replaced.
+	f.stall()
+	backplanetest.WaitFor(t, "a new subscription", func() bool { return f.subscriptions() == 2 })
+	f.publish(`{"data":"1"}`, "sse", "100")
+	if e := backplanetest.Receive(t, events); e.Data != "1" {
+		t.Fatalf("expected event 1, got %+v", e)
+	}
+}
+
+func TestBackplane_Reconnect(t *testing.T) {
+	f := newFakeRedis(t)
+	a := connect(t, f, Options{})
+	b := connect(t, f, Options{})
+	events := make(backplanetest.Writer, 4)
+	a.Subscribe(func(e sse.Event) { events <- e })
+
+	if err := b.Publish(sse.Event{Data: "1"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if e := backplanetest.Receive(t, events); e.Data != "1" {
+		t.Fatalf("expected event 1, got %+v", e)
+	}
+
+	// An event published while a is disconnected is caught up from the
+	// stream.
+	f.setRefuse(true)
+	f.disconnect()
+	backplanetest.WaitFor(t, "the subscription to be lost", func() bool { return !a.Connected() })
+	f.publish(`{"data":"2"}`, "sse", "100")
+	f.setRefuse(false)
+	backplanetest.WaitFor(t, "the subscription", a.Connected)
+	if e := backplanetest.Receive(t, events); e.Data != "2" {
+		t.Fatalf("expected event 2, got %+v", e)
+	}
+
+	if err := b.Publish(sse.Event{Data: "3"}); err != nil {
+		// The first command after a disconnection may fail.
+		if err := b.Publish(sse.Event{Data: "3"}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if e := backplanetest.Receive(t, events); e.Data != "3" {
+		t.Fatalf("expected event 3, got %+v", e)
+	}
+
+	a.Close()
+	if err := a.Publish(sse.Event{Data: "4"}); !errors.Is(err, sse.ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
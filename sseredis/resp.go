@@ -0,0 +1,156 @@
+package sseredis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// maxBulkSize is the maximum size of a bulk string read from Redis.
+const maxBulkSize = 512 << 20
+
+// errProtocol is returned for a reply that is not valid RESP.
+var errProtocol = errors.New("sseredis: invalid reply")
+
+// redisError is an error reply from Redis. It leaves the connection usable.
+type redisError string
+
+func (e redisError) Error() string { return "sseredis: " + string(e) }
+
+// conn is a connection to Redis, speaking RESP2.
+type conn struct {
+	c net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// dial connects to Redis, and authenticates and selects the database of
+// opts.
+func dial(opts Options) (*conn, error) {
+	nc, err := net.DialTimeout(opts.Network, opts.Addr, opts.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{c: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	_ = nc.SetDeadline(time.Now().Add(opts.DialTimeout))
+	if opts.Password != "" {
+		args := []string{"AUTH", opts.Password}
+		if opts.Username != "" {
+			args = []string{"AUTH", opts.Username, opts.Password}
+		}
+		if _, err := c.do(args...); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	if opts.DB != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(opts.DB)); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	_ = nc.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// do sends a command and reads its reply. An error reply is returned as a
+// redisError.
+func (c *conn) do(args ...string) (interface{}, error) {
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	reply, err := c.receive()
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := reply.(redisError); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// send sends a command as an array of bulk strings.
+func (c *conn) send(args ...string) error {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return c.w.Flush()
+}
+
+// receive reads a reply: a string for simple and bulk strings, an int64, a
+// redisError, a []interface{} for arrays, or nil.
+func (c *conn) receive() (interface{}, error) {
+	line, err := c.line()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errProtocol
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, errProtocol
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > maxBulkSize {
+			return nil, errProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		if string(buf[n:]) != "\r\n" {
+			return nil, errProtocol
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		array := make([]interface{}, n)
+		for i := range array {
+			if array[i], err = c.receive(); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	}
+	return nil, errProtocol
+}
+
+// line reads a line, without its CRLF.
+func (c *conn) line() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errProtocol
+	}
+	return line[:len(line)-2], nil
+}
+
+// Close closes the connection.
+func (c *conn) Close() error {
+	return c.c.Close()
+}
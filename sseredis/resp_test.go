@@ -0,0 +1,334 @@
+package sseredis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConn_Receive(t *testing.T) {
+	tests := map[string]interface{}{
+		"+OK\r\n":                             "OK",
+		"-ERR unknown command\r\n":            redisError("ERR unknown command"),
+		":42\r\n":                             int64(42),
+		"$5\r\nhello\r\n":                     "hello",
+		"$0\r\n\r\n":                          "",
+		"$-1\r\n":                             nil,
+		"*-1\r\n":                             nil,
+		"*2\r\n$1\r\na\r\n*1\r\n:1\r\n":       []interface{}{"a", []interface{}{int64(1)}},
+		"$4\r\na\r\nb\r\n":                    "a\r\nb",
+		"*3\r\n+message\r\n$1\r\nc\r\n:0\r\n": []interface{}{"message", "c", int64(0)},
+	}
+	for input, expected := range tests {
+		c := &conn{r: bufio.NewReader(strings.NewReader(input))}
+		actual, err := c.receive()
+		if err != nil {
+			t.Errorf("%q: expected no error, got %v", input, err)
+		} else if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%q: expected %#v, got %#v", input, expected, actual)
+		}
+	}
+
+	for _, input := range []string{"OK\r\n", ":x\r\n", "$3\r\nabcd\r\n", "+OK\n", "?\r\n"} {
+		c := &conn{r: bufio.NewReader(strings.NewReader(input))}
+		if _, err := c.receive(); !errors.Is(err, errProtocol) {
+			t.Errorf("%q: expected errProtocol, got %v", input, err)
+		}
+	}
+}
+
+// fakeRedis is an in-process stand-in for a Redis server, implementing the
+// commands used by Backplane.
+type fakeRedis struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	password string
+	seq      int
+	stream   []fakeEntry
+	channels map[string]map[*fakeClient]struct{}
+	clients  map[*fakeClient]struct{}
+	refuse   bool
+	subs     int           // number of SUBSCRIBE commands
+	hold     chan struct{} // XREVRANGE waits for it to be closed, if set
+}
+
+// fakeEntry is an entry of the stream of a fakeRedis.
+type fakeEntry struct {
+	id     string
+	fields []interface{}
+}
+
+// fakeClient is a connection to a fakeRedis.
+type fakeClient struct {
+	conn    net.Conn
+	mu      sync.Mutex // guards writes and stalled
+	w       *bufio.Writer
+	stalled bool // replies are dropped
+}
+
+// newFakeRedis serves a fakeRedis on a local port until the test ends.
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	f := &fakeRedis{
+		listener: l,
+		channels: make(map[string]map[*fakeClient]struct{}),
+		clients:  make(map[*fakeClient]struct{}),
+	}
+	go f.serve()
+	t.Cleanup(func() {
+		l.Close()
+		f.disconnect()
+	})
+	return f
+}
+
+// addr returns the address of the server.
+func (f *fakeRedis) addr() string {
+	return f.listener.Addr().String()
+}
+
+// disconnect closes every client connection.
+func (f *fakeRedis) disconnect() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for c := range f.clients {
+		c.conn.Close()
+	}
+}
+
+// setPassword sets the password required from new connections.
+func (f *fakeRedis) setPassword(password string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.password = password
+}
+
+// setRefuse sets whether new connections are closed at once.
+func (f *fakeRedis) setRefuse(refuse bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.refuse = refuse
+}
+
+// stall makes the current client connections drop every reply, as if the
+// network silently failed.
+func (f *fakeRedis) stall() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for c := range f.clients {
+		c.mu.Lock()
+		c.stalled = true
+		c.mu.Unlock()
+	}
+}
+
+// connections returns the number of open client connections.
+func (f *fakeRedis) connections() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.clients)
+}
+
+// subscriptions returns the number of SUBSCRIBE commands received.
+func (f *fakeRedis) subscriptions() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.subs
+}
+
+// setHold makes XREVRANGE wait until hold is closed.
+func (f *fakeRedis) setHold(hold chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hold = hold
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		nc, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		if f.refuse {
+			f.mu.Unlock()
+			nc.Close()
+			continue
+		}
+		c := &fakeClient{conn: nc, w: bufio.NewWriter(nc)}
+		f.clients[c] = struct{}{}
+		f.mu.Unlock()
+		go f.handle(c)
+	}
+}
+
+// handle runs the commands of a client.
+func (f *fakeRedis) handle(c *fakeClient) {
+	defer func() {
+		f.mu.Lock()
+		delete(f.clients, c)
+		for _, subs := range f.channels {
+			delete(subs, c)
+		}
+		f.mu.Unlock()
+		c.conn.Close()
+	}()
+	r := &conn{r: bufio.NewReader(c.conn)}
+	f.mu.Lock()
+	password := f.password
+	f.mu.Unlock()
+	authenticated := password == ""
+	subscribed := false
+	for {
+		reply, err := r.receive()
+		if err != nil {
+			return
+		}
+		array, _ := reply.([]interface{})
+		args := make([]string, len(array))
+		for i, arg := range array {
+			args[i], _ = arg.(string)
+		}
+		if len(args) == 0 {
+			c.reply(redisError("ERR empty command"))
+			continue
+		}
+		if !authenticated && strings.ToUpper(args[0]) != "AUTH" {
+			c.reply(redisError("NOAUTH Authentication required."))
+			continue
+		}
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "AUTH":
+			if args[len(args)-1] != password {
+				c.reply(redisError("WRONGPASS invalid username-password pair"))
+				continue
+			}
+			authenticated = true
+			c.reply(status("OK"))
+		case cmd == "PING" && subscribed:
+			c.reply([]interface{}{"pong", ""})
+		case cmd == "SELECT" || cmd == "PING":
+			c.reply(status("OK"))
+		case cmd == "EVAL" && len(args) == 7 && args[1] == publishScript:
+			c.reply(f.publish(args[6], args[5], args[4]))
+		case cmd == "XRANGE" && len(args) == 6:
+			c.reply(f.xrange(args[2], args[3], args[5], false))
+		case cmd == "XREVRANGE" && len(args) == 6:
+			f.mu.Lock()
+			hold := f.hold
+			f.mu.Unlock()
+			if hold != nil {
+				<-hold
+			}
+			c.reply(f.xrange(args[3], args[2], args[5], true))
+		case cmd == "SUBSCRIBE" && len(args) == 2:
+			f.mu.Lock()
+			if f.channels[args[1]] == nil {
+				f.channels[args[1]] = make(map[*fakeClient]struct{})
+			}
+			f.channels[args[1]][c] = struct{}{}
+			f.subs++
+			f.mu.Unlock()
+			subscribed = true
+			c.reply([]interface{}{"subscribe", args[1], 1})
+		default:
+			c.reply(redisError("ERR unsupported command " + args[0]))
+		}
+	}
+}
+
+// publish runs publishScript: it appends the payload to the stream and
+// publishes it with its id on the channel.
+func (f *fakeRedis) publish(payload, channel, maxLen string) interface{} {
+	n, err := strconv.Atoi(maxLen)
+	if err != nil {
+		return redisError("ERR value is not an integer")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seq++
+	id := fmt.Sprintf("%d-0", f.seq)
+	f.stream = append(f.stream, fakeEntry{id: id, fields: []interface{}{"e", payload}})
+	if len(f.stream) > n {
+		f.stream = f.stream[len(f.stream)-n:]
+	}
+	for c := range f.channels[channel] {
+		c.reply([]interface{}{"message", channel, id + " " + payload})
+	}
+	return id
+}
+
+// xrange returns the entries between the ids start and end, inclusive.
+func (f *fakeRedis) xrange(start, end, count string, reverse bool) interface{} {
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return redisError("ERR value is not an integer")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var entries []interface{}
+	for i := range f.stream {
+		e := f.stream[i]
+		if reverse {
+			e = f.stream[len(f.stream)-1-i]
+		}
+		if (start != "-" && newer(start, e.id)) || (end != "+" && newer(e.id, end)) {
+			continue
+		}
+		if len(entries) == n {
+			break
+		}
+		entries = append(entries, []interface{}{e.id, e.fields})
+	}
+	if entries == nil {
+		entries = []interface{}{}
+	}
+	return entries
+}
+
+// status is a simple string reply.
+type status string
+
+// reply writes a reply to the client.
+func (c *fakeClient) reply(v interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stalled {
+		return
+	}
+	writeReply(c.w, v)
+	c.w.Flush()
+}
+
+// writeReply encodes a reply in RESP.
+func writeReply(w io.Writer, v interface{}) {
+	switch v := v.(type) {
+	case status:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case redisError:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	default:
+		fmt.Fprint(w, "$-1\r\n")
+	}
+}
@@ -0,0 +1,163 @@
+package ssetest
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// update is the -ssetest.update flag of test binaries, which rewrites golden
+// files instead of comparing against them. The name is prefixed so that it
+// does not clash with an -update flag of the test package.
+var update = flag.Bool("ssetest.update", false, "rewrite ssetest golden files")
+
+// timestamps matches RFC 3339 timestamps, with or without fractional seconds.
+var timestamps = regexp.MustCompile(
+	`\d{4}-\d{2}-\d{2}[Tt ]\d{2}:\d{2}:\d{2}(\.\d+)?([Zz]|[+-]\d{2}:\d{2})?`)
+
+// GoldenOption changes how Golden normalises a stream.
+type GoldenOption func(*golden)
+
+// golden holds the normalisation settings of Golden.
+type golden struct {
+	maskIDs        bool
+	maskTimestamps bool
+}
+
+// MaskIDs replaces the values of id and traceparent fields with <id> and
+// <traceparent>, for streams whose ids are not deterministic.
+func MaskIDs() GoldenOption {
+	return func(g *golden) { g.maskIDs = true }
+}
+
+// MaskTimestamps replaces RFC 3339 timestamps anywhere in field values with
+// <timestamp>.
+func MaskTimestamps() GoldenOption {
+	return func(g *golden) { g.maskTimestamps = true }
+}
+
+// Golden compares a transcript of the recorded stream with the golden file
+// testdata/<name>.golden, failing the test if they differ. Run the test with
+// -ssetest.update to write the golden file instead.
+//
+// The transcript is the stream decoded from its Content-Encoding, with
+// comments such as heartbeats removed, LF line endings, one space after every
+// field name and a blank line after every event. An incomplete trailing event
+// is left out.
+func Golden(t testing.TB, name string, rec *Recorder, opts ...GoldenOption) {
+	t.Helper()
+	var g golden
+	for _, opt := range opts {
+		opt(&g)
+	}
+
+	decoded, err := rec.Decoded()
+	if err != nil {
+		t.Fatalf("failed to decode stream: %v", err)
+	}
+	transcript, err := g.transcript(decoded)
+	if err != nil {
+		t.Fatalf("failed to read stream: %v", err)
+	}
+
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create golden file directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(transcript), 0o644); err != nil {
+			t.Fatalf("failed to write golden file: %v", err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file (run with -ssetest.update to create it): %v", err)
+	}
+	if string(expected) != transcript {
+		t.Errorf("stream does not match %s (run with -ssetest.update to accept it):\n%s",
+			path, diff(string(expected), transcript))
+	}
+}
+
+// transcript normalises a decoded stream.
+func (g golden) transcript(stream string) (string, error) {
+	var out, block strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(strings.TrimPrefix(stream, "\uFEFF")))
+	// No line is longer than the stream.
+	scanner.Buffer(nil, len(stream)+1)
+	scanner.Split(scanLines)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if block.Len() > 0 {
+				out.WriteString(block.String())
+				out.WriteByte('\n')
+				block.Reset()
+			}
+			continue
+		}
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch {
+		case g.maskIDs && field == "id":
+			value = "<id>"
+		case g.maskIDs && field == "traceparent":
+			value = "<traceparent>"
+		case g.maskTimestamps:
+			value = timestamps.ReplaceAllString(value, "<timestamp>")
+		}
+		block.WriteString(field)
+		if value != "" {
+			block.WriteString(": ")
+			block.WriteString(value)
+		}
+		block.WriteByte('\n')
+	}
+	return out.String(), scanner.Err()
+}
+
+// scanLines is a bufio.SplitFunc for lines ending in CRLF, LF or CR. Only
+// terminated lines are returned.
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	for i, b := range data {
+		switch b {
+		case '\n':
+			return i + 1, data[:i], nil
+		case '\r':
+			if i+1 == len(data) && !atEOF {
+				// Wait for more data to tell CR from CRLF.
+				return 0, nil, nil
+			}
+			if i+1 < len(data) && data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+	}
+	return 0, nil, nil
+}
+
+// diff returns the lines of expected and actual from the first line where they
+// differ.
+func diff(expected, actual string) string {
+	e, a := strings.Split(expected, "\n"), strings.Split(actual, "\n")
+	i := 0
+	for i < len(e) && i < len(a) && e[i] == a[i] {
+		i++
+	}
+	return fmt.Sprintf("first difference at line %d\n--- expected\n%s\n+++ actual\n%s",
+		i+1, strings.Join(e[i:], "\n"), strings.Join(a[i:], "\n"))
+}
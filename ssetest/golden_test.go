@@ -0,0 +1,116 @@
+package ssetest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/floriscornel/sse"
+)
+
+func TestGolden(t *testing.T) {
+	for _, encoding := range []string{sse.EncodeNone, sse.EncodeGzip, sse.EncodeZstd} {
+		t.Run("Encoding "+encoding, func(t *testing.T) {
+			rec := NewRecorder()
+			writer := sse.NewResponseWriter(rec, sse.Options{Encoding: encoding}).(sse.EventWriter)
+			for _, e := range []sse.Event{
+				{Event: "add", Data: `{"id":1,"at":"2024-05-01T12:30:00.123Z"}`},
+				{Event: "note", Data: "first line\nsecond line"},
+				{ID: "custom", Data: ""},
+			} {
+				if err := writer.WriteEvent(e); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+
+			Golden(t, "stream", rec, MaskTimestamps())
+		})
+	}
+}
+
+// failRecorder records the failures of a test.
+type failRecorder struct {
+	testing.TB
+	failures []string
+}
+
+func (f *failRecorder) Helper() {}
+
+func (f *failRecorder) Errorf(format string, args ...interface{}) {
+	f.failures = append(f.failures, fmt.Sprintf(format, args...))
+}
+
+func (f *failRecorder) Fatalf(format string, args ...interface{}) {
+	f.Errorf(format, args...)
+}
+
+func TestGolden_Mismatch(t *testing.T) {
+	if *update {
+		t.Skip("golden files are being rewritten")
+	}
+	rec := NewRecorder()
+	writer := sse.NewResponseWriter(rec, sse.Options{})
+	if err := writer.Write("add", map[string]int{"id": 2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	f := &failRecorder{TB: t}
+	Golden(f, "stream", rec)
+	if len(f.failures) != 1 {
+		t.Fatalf("expected 1 failure, got %q", f.failures)
+	}
+
+	f = &failRecorder{TB: t}
+	Golden(f, "missing", rec)
+	if len(f.failures) == 0 || !strings.HasPrefix(f.failures[0], "failed to read golden file") {
+		t.Fatalf("expected a failure for a missing golden file, got %q", f.failures)
+	}
+}
+
+func TestGolden_Transcript(t *testing.T) {
+	tests := []struct {
+		name     string
+		golden   golden
+		stream   string
+		expected string
+	}{
+		{
+			name:     "Normalises fields and line endings",
+			stream:   "\uFEFF: heartbeat\r\nid:1\revent:  add\r\ndata\n\n\n: heartbeat\n\nretry: 10\n\n",
+			expected: "id: 1\nevent:  add\ndata\n\nretry: 10\n\n",
+		},
+		{
+			name:     "Leaves out incomplete events",
+			stream:   "data: a\n\ndata: b\n",
+			expected: "data: a\n\n",
+		},
+		{
+			name:     "Masks ids",
+			golden:   golden{maskIDs: true},
+			stream:   "id: 42\ntraceparent: 00-abc-def-01\ndata: 2024-01-01T00:00:00Z\n\n",
+			expected: "id: <id>\ntraceparent: <traceparent>\ndata: 2024-01-01T00:00:00Z\n\n",
+		},
+		{
+			name:     "Masks timestamps",
+			golden:   golden{maskTimestamps: true},
+			stream:   "id: 2024-01-01T00:00:00Z\ndata: {\"at\":\"2024-01-01T10:00:00.5+02:00\",\"n\":2024}\n\n",
+			expected: "id: <timestamp>\ndata: {\"at\":\"<timestamp>\",\"n\":2024}\n\n",
+		},
+		{
+			name:     "Keeps long lines",
+			stream:   "data: " + strings.Repeat("x", 100<<10) + "\n\n",
+			expected: "data: " + strings.Repeat("x", 100<<10) + "\n\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := tc.golden.transcript(tc.stream)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if actual != tc.expected {
+				t.Errorf("expected transcript %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
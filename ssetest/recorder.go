@@ -0,0 +1,203 @@
+// Package ssetest provides utilities for testing Server-Sent Events handlers
+// and clients.
+package ssetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/floriscornel/sse"
+)
+
+// Recorder is a flushable http.ResponseWriter that records an event stream.
+// Unlike httptest.ResponseRecorder, it is safe to inspect while a streaming
+// handler writes to it from another goroutine, and it decodes the stream from
+// any Content-Encoding supported by the sse package.
+type Recorder struct {
+	mu          sync.Mutex
+	header      http.Header
+	code        int
+	wroteHeader bool
+	encoding    string
+	body        bytes.Buffer
+	flushes     int
+	changed     chan struct{}
+}
+
+// NewRecorder creates a new Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{
+		header:  make(http.Header),
+		code:    http.StatusOK,
+		changed: make(chan struct{}),
+	}
+}
+
+// Header returns the response headers. Like with http.ResponseWriter, they
+// must not be modified once the handler has written the status code.
+func (rec *Recorder) Header() http.Header {
+	return rec.header
+}
+
+// WriteHeader records the status code.
+func (rec *Recorder) WriteHeader(code int) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.writeHeader(code)
+	rec.notify()
+}
+
+// Write records data written to the response body.
+func (rec *Recorder) Write(p []byte) (int, error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.writeHeader(http.StatusOK)
+	n, err := rec.body.Write(p)
+	rec.notify()
+	return n, err
+}
+
+// Flush records a flush.
+func (rec *Recorder) Flush() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.writeHeader(http.StatusOK)
+	rec.flushes++
+	rec.notify()
+}
+
+// writeHeader records the status code and the Content-Encoding of the body,
+// unless they have been recorded already. The caller must hold rec.mu.
+func (rec *Recorder) writeHeader(code int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.code = code
+	rec.encoding = rec.header.Get("Content-Encoding")
+	rec.wroteHeader = true
+}
+
+// Code returns the recorded status code.
+func (rec *Recorder) Code() int {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.code
+}
+
+// Flushes returns the number of times the response was flushed.
+func (rec *Recorder) Flushes() int {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.flushes
+}
+
+// Body returns a copy of the body as written, before decoding.
+func (rec *Recorder) Body() []byte {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return bytes.Clone(rec.body.Bytes())
+}
+
+// Decoded returns the body decoded from its Content-Encoding.
+func (rec *Recorder) Decoded() (string, error) {
+	rec.mu.Lock()
+	encoding, body := rec.encoding, bytes.Clone(rec.body.Bytes())
+	rec.mu.Unlock()
+
+	reader, err := sse.NewDecodingReader(encoding, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	var decoded bytes.Buffer
+	_, err = decoded.ReadFrom(reader)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		// The handler is still writing; return what has been decoded so far.
+		err = nil
+	}
+	return decoded.String(), err
+}
+
+// Events returns the complete events recorded so far.
+func (rec *Recorder) Events() ([]sse.Event, error) {
+	decoded, err := rec.Decoded()
+	if err != nil {
+		return nil, err
+	}
+	return parseEvents(decoded)
+}
+
+// WaitForEvent waits until an event with the given type has been recorded and
+// returns the first such event.
+func (rec *Recorder) WaitForEvent(ctx context.Context, event string) (sse.Event, error) {
+	for {
+		rec.mu.Lock()
+		changed := rec.changed
+		rec.mu.Unlock()
+
+		events, err := rec.Events()
+		if err != nil {
+			return sse.Event{}, err
+		}
+		for _, e := range events {
+			if e.Event == event {
+				return e, nil
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return sse.Event{}, ctx.Err()
+		}
+	}
+}
+
+// notify wakes up goroutines waiting for the recorder to change. The caller
+// must hold rec.mu.
+func (rec *Recorder) notify() {
+	close(rec.changed)
+	rec.changed = make(chan struct{})
+}
+
+// AssertEvents fails the test unless the recorder holds exactly the expected
+// events. An expected event with an empty ID matches an event with any id.
+func AssertEvents(t testing.TB, rec *Recorder, expected ...sse.Event) {
+	t.Helper()
+	events, err := rec.Events()
+	if err != nil {
+		t.Fatalf("failed to read events: %v", err)
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d: %+v", len(expected), len(events), events)
+	}
+	for i, e := range expected {
+		got := events[i]
+		if e.ID == "" {
+			got.ID = ""
+		}
+		if got != e {
+			t.Errorf("event %d: expected %+v, got %+v", i, e, events[i])
+		}
+	}
+}
+
+// parseEvents parses every complete event of a decoded stream.
+func parseEvents(stream string) ([]sse.Event, error) {
+	decoder := sse.NewDecoder(bytes.NewReader([]byte(stream)))
+	var events []sse.Event
+	for {
+		event, err := decoder.Decode()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+}
@@ -0,0 +1,79 @@
+package ssetest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/floriscornel/sse"
+)
+
+func TestRecorder(t *testing.T) {
+	encodings := []string{
+		sse.EncodeNone, sse.EncodeGzip, sse.EncodeBrotli,
+		sse.EncodeDeflate, sse.EncodeCompress, sse.EncodeZstd,
+	}
+	for _, encoding := range encodings {
+		t.Run("Encoding "+encoding, func(t *testing.T) {
+			rec := NewRecorder()
+			writer := sse.NewResponseWriter(rec, sse.Options{
+				Encoding:       encoding,
+				ResponseStatus: http.StatusAccepted,
+			})
+			for _, event := range []string{"add", "update", "remove"} {
+				if err := writer.Write(event, map[string]int{"id": 1}); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+
+			if rec.Code() != http.StatusAccepted {
+				t.Errorf("expected status %d, got %d", http.StatusAccepted, rec.Code())
+			}
+			if rec.Flushes() != 4 {
+				t.Errorf("expected 4 flushes, got %d", rec.Flushes())
+			}
+			AssertEvents(t, rec,
+				sse.Event{ID: "1", Event: "add", Data: `{"id":1}`},
+				sse.Event{Event: "update", Data: `{"id":1}`},
+				sse.Event{Event: "remove", Data: `{"id":1}`},
+			)
+		})
+	}
+}
+
+func TestRecorder_WaitForEvent(t *testing.T) {
+	rec := NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writer := sse.NewResponseWriter(rec, sse.Options{Encoding: sse.EncodeGzip})
+		for i := 0; i < 3; i++ {
+			time.Sleep(5 * time.Millisecond)
+			if err := writer.Write("tick", i); err != nil {
+				t.Errorf("expected no error, got %v", err)
+				return
+			}
+		}
+		if err := writer.Write("done", nil); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	event, err := rec.WaitForEvent(ctx, "tick")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if event.Data != "0" {
+		t.Errorf("expected the first tick, got %+v", event)
+	}
+
+	<-done
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := rec.WaitForEvent(ctx, "never"); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
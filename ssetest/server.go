@@ -0,0 +1,233 @@
+package ssetest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/floriscornel/sse"
+	"github.com/floriscornel/sse/internal/contentcoding"
+)
+
+// Request describes a request received by a Server.
+type Request struct {
+	LastEventID    string
+	AcceptEncoding string
+	Header         http.Header
+}
+
+// Step is a single action of a Script.
+type Step func(c *Conn) error
+
+// Script is the sequence of steps a Server performs on a connection. Once the
+// script is done, the stream is held open until the client disconnects.
+type Script []Step
+
+// Conn is a connection served by a Server.
+type Conn struct {
+	w       http.ResponseWriter
+	opts    sse.Options
+	writer  sse.EventWriter
+	done    <-chan struct{}
+	aborted bool
+	ended   bool
+}
+
+// stream returns the writer of the connection, sending the response headers
+// on first use.
+func (c *Conn) stream() sse.EventWriter {
+	if c.writer == nil {
+		c.writer = sse.NewResponseWriter(c.w, c.opts).(sse.EventWriter)
+	}
+	return c.writer
+}
+
+// close closes the writer of the connection, if any, which stops its timers.
+func (c *Conn) close() {
+	if c.writer != nil {
+		_ = c.writer.(sse.CloseWriter).Close()
+	}
+}
+
+// Send writes events with the library's writer. Events without an id are
+// assigned one by the writer.
+func Send(events ...sse.Event) Step {
+	return func(c *Conn) error {
+		for _, e := range events {
+			if err := c.stream().WriteEvent(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Raw writes text to the stream as is, encoded with the server's encoding.
+// It can be used to send malformed lines.
+func Raw(text string) Step {
+	return func(c *Conn) error {
+		c.stream()
+		encoded, err := contentcoding.Encode(c.opts.Encoding, text)
+		if err != nil {
+			return err
+		}
+		if _, err := c.w.Write(encoded); err != nil {
+			return err
+		}
+		if flusher, ok := c.w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		return nil
+	}
+}
+
+// Delay pauses the script.
+func Delay(d time.Duration) Step {
+	return func(c *Conn) error {
+		select {
+		case <-time.After(d):
+		case <-c.done:
+		}
+		return nil
+	}
+}
+
+// Status responds with the given status code and no stream, and ends the
+// script. Use http.StatusNoContent to tell clients to stop reconnecting.
+func Status(code int) Step {
+	return func(c *Conn) error {
+		c.w.WriteHeader(code)
+		c.ended = true
+		return nil
+	}
+}
+
+// End ends the response cleanly and ends the script.
+func End() Step {
+	return func(c *Conn) error {
+		c.stream()
+		c.ended = true
+		return nil
+	}
+}
+
+// Drop aborts the connection without ending the response and ends the script,
+// as if the network failed.
+func Drop() Step {
+	return func(c *Conn) error {
+		c.stream()
+		c.aborted = true
+		c.ended = true
+		return nil
+	}
+}
+
+// Server is a scriptable Server-Sent Events server for testing clients. The
+// n-th connection performs the n-th script; connections beyond the last
+// script perform the last one.
+type Server struct {
+	*httptest.Server
+
+	opts    sse.Options
+	scripts []Script
+
+	mu       sync.Mutex
+	requests []Request
+	changed  chan struct{}
+	done     chan struct{}
+	stop     sync.Once
+}
+
+// NewServer starts a Server writing streams with the given options.
+func NewServer(opts sse.Options, scripts ...Script) *Server {
+	s := &Server{
+		opts:    opts,
+		scripts: scripts,
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Close ends all open streams and shuts the server down.
+func (s *Server) Close() {
+	s.stop.Do(func() { close(s.done) })
+	s.Server.Close()
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// WaitForRequests waits until the server has received at least n requests.
+func (s *Server) WaitForRequests(ctx context.Context, n int) ([]Request, error) {
+	for {
+		s.mu.Lock()
+		requests, changed := append([]Request(nil), s.requests...), s.changed
+		s.mu.Unlock()
+		if len(requests) >= n {
+			return requests, nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return requests, ctx.Err()
+		}
+	}
+}
+
+// serve records a request and performs its script.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	index := len(s.requests)
+	s.requests = append(s.requests, Request{
+		LastEventID:    r.Header.Get("Last-Event-ID"),
+		AcceptEncoding: r.Header.Get("Accept-Encoding"),
+		Header:         r.Header.Clone(),
+	})
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.mu.Unlock()
+
+	var script Script
+	if len(s.scripts) > 0 {
+		script = s.scripts[min(index, len(s.scripts)-1)]
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	c := &Conn{w: w, opts: s.opts, done: ctx.Done()}
+	defer c.close()
+	for _, step := range script {
+		if err := step(c); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+		if c.ended {
+			break
+		}
+	}
+	if c.aborted {
+		panic(http.ErrAbortHandler)
+	}
+	if c.ended {
+		return
+	}
+
+	c.stream()
+	<-ctx.Done()
+}
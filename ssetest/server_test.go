@@ -0,0 +1,170 @@
+package ssetest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/floriscornel/sse"
+)
+
+// get requests the server's stream and returns the decoded events and the
+// error that ended the stream.
+func get(t *testing.T, s *Server, lastEventID string) (*http.Response, []sse.Event, error) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	defer res.Body.Close()
+
+	reader, err := sse.NewDecodingReader(res.Header.Get("Content-Encoding"), res.Body)
+	if err != nil {
+		t.Fatalf("failed to create decoding reader: %v", err)
+	}
+	decoder := sse.NewDecoder(reader)
+	var events []sse.Event
+	for {
+		event, err := decoder.Decode()
+		if err != nil {
+			return res, events, err
+		}
+		events = append(events, event)
+	}
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer(sse.Options{Encoding: sse.EncodeGzip},
+		Script{
+			Send(sse.Event{ID: "a", Event: "add", Data: "1"}),
+			Delay(5 * time.Millisecond),
+			Send(sse.Event{Event: "add", Data: "2\n3"}),
+			Drop(),
+		},
+		Script{
+			Raw("data: partial\n\nevent\rdata:4\r\n\r\n"),
+			End(),
+		},
+		Script{Status(http.StatusNoContent)},
+	)
+	defer s.Close()
+
+	_, events, err := get(t, s, "")
+	if err == nil || err == io.EOF {
+		t.Errorf("expected the dropped stream to fail, got %v", err)
+	}
+	expected := []sse.Event{
+		{ID: "a", Event: "add", Data: "1"},
+		{ID: "1", Event: "add", Data: "2\n3"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %+v", len(expected), events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("event %d: expected %+v, got %+v", i, expected[i], events[i])
+		}
+	}
+
+	_, events, err = get(t, s, "1")
+	if err != io.EOF {
+		t.Errorf("expected the ended stream to return io.EOF, got %v", err)
+	}
+	if len(events) != 2 || events[0].Data != "partial" || events[1].Data != "4" {
+		t.Errorf("expected the raw events, got %+v", events)
+	}
+
+	for i := 0; i < 2; i++ {
+		res, events, _ := get(t, s, "")
+		if res.StatusCode != http.StatusNoContent || len(events) != 0 {
+			t.Errorf("expected an empty 204 response, got %d with %+v", res.StatusCode, events)
+		}
+	}
+
+	requests := s.Requests()
+	if len(requests) != 4 {
+		t.Fatalf("expected 4 requests, got %d", len(requests))
+	}
+	if requests[1].LastEventID != "1" || requests[1].AcceptEncoding != "gzip" {
+		t.Errorf("expected the request headers to be recorded, got %+v", requests[1])
+	}
+}
+
+func TestServer_Close(t *testing.T) {
+	s := NewServer(sse.Options{}, Script{Send(sse.Event{Data: "hello"})})
+
+	res, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	defer res.Body.Close()
+	decoder := sse.NewDecoder(res.Body)
+	if event, err := decoder.Decode(); err != nil || event.Data != "hello" {
+		t.Fatalf("expected the scripted event, got %+v, %v", event, err)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		s.Close()
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected Close to end the open stream")
+	}
+	if _, err := decoder.Decode(); err == nil {
+		t.Error("expected the stream to end")
+	}
+	if requests := s.Requests(); len(requests) != 1 {
+		t.Errorf("expected 1 request, got %d", len(requests))
+	}
+}
+
+func TestServer_Heartbeat(t *testing.T) {
+	s := NewServer(sse.Options{Heartbeat: time.Millisecond}, Script{
+		Send(sse.Event{Data: "hello"}),
+		End(),
+	})
+	defer s.Close()
+
+	_, events, err := get(t, s, "")
+	if err != io.EOF || len(events) != 1 {
+		t.Fatalf("expected 1 event and io.EOF, got %+v, %v", events, err)
+	}
+	// A heartbeat after the handler returned would race with the server.
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestServer_WaitForRequests(t *testing.T) {
+	s := NewServer(sse.Options{}, Script{End()})
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.WaitForRequests(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+
+	go func() {
+		if res, err := http.Get(s.URL); err == nil {
+			res.Body.Close()
+		}
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if requests, err := s.WaitForRequests(ctx, 1); err != nil || len(requests) != 1 {
+		t.Errorf("expected 1 request, got %d, %v", len(requests), err)
+	}
+}
@@ -0,0 +1,314 @@
+package sse
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/floriscornel/sse/internal/jsonpatch"
+)
+
+// Event types of a StateStream.
+const (
+	// SnapshotEvent carries the whole state as JSON.
+	SnapshotEvent = "snapshot"
+	// PatchEvent carries a JSON Patch (RFC 6902): an array of operations
+	// that turn the previous state into the new one.
+	PatchEvent = "patch"
+	// MergePatchEvent carries a JSON Merge Patch (RFC 7386).
+	MergePatchEvent = "merge-patch"
+)
+
+// ErrNoSnapshot is returned by State.Apply and CollectionState.Apply when a
+// change arrives before any snapshot.
+var ErrNoSnapshot = errors.New("sse: patch before snapshot")
+
+// ErrBackplaneUnsupported is returned by NewStateStream and NewCollection
+// when their broker options set a Backplane.
+var ErrBackplaneUnsupported = errors.New("sse: backplane not supported")
+
+// PatchMode is the kind of patch sent by a StateStream.
+type PatchMode int
+
+const (
+	// JSONPatch sends PatchEvent events.
+	JSONPatch PatchMode = iota
+	// MergePatch sends MergePatchEvent events. Merge patches are often
+	// smaller, but replace arrays as a whole and cannot set a member to
+	// null: clients leave out null members instead.
+	MergePatch
+)
+
+// StateOptions holds configuration for a StateStream.
+type StateOptions struct {
+	// Broker configures the fan-out to clients. Its replay buffer holds the
+	// recent patches, so that reconnecting clients receive the patches they
+	// missed instead of a snapshot. A Backplane is not supported: the
+	// versions are local to the stream, and backplanes may replace ids.
+	// NewStateStream returns ErrBackplaneUnsupported if one is set.
+	Broker BrokerOptions
+
+	// Mode is the kind of patch sent.
+	Mode PatchMode
+}
+
+// StateStream streams a value of type T to any number of clients. A client
+// receives a snapshot event when it connects, and a patch event each time the
+// value changes. Each event's id is the version of the state, so a client
+// reconnecting with a Last-Event-ID receives the patches it missed, or a new
+// snapshot if they are no longer buffered. Versions are prefixed with an
+// epoch chosen when the stream is created, so that a client that saw a
+// stream of an earlier process receives a snapshot.
+//
+// T is encoded with encoding/json. Use State to rebuild the value on a Go
+// client.
+type StateStream[T any] struct {
+	broker *Broker
+	mode   PatchMode
+	ids    versionIDs
+
+	mu      sync.Mutex
+	value   T
+	doc     any // value as a JSON document
+	version uint64
+}
+
+// NewStateStream creates a StateStream with the initial value at version 0.
+// It returns an error if the value cannot be encoded, or
+// ErrBackplaneUnsupported if opts.Broker sets a Backplane.
+func NewStateStream[T any](initial T, opts StateOptions) (*StateStream[T], error) {
+	if opts.Broker.Backplane != nil {
+		return nil, ErrBackplaneUnsupported
+	}
+	doc, err := toDocument(initial)
+	if err != nil {
+		return nil, err
+	}
+	return &StateStream[T]{
+		broker: NewBroker(opts.Broker),
+		mode:   opts.Mode,
+		ids:    newVersionIDs(),
+		value:  initial,
+		doc:    doc,
+	}, nil
+}
+
+// Set replaces the value and sends a patch to every client. If the value did
+// not change, nothing is sent.
+func (s *StateStream[T]) Set(value T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.set(value)
+}
+
+// Update calls fn with the value and sends a patch with its changes. fn may
+// modify the value in place: the previous version is kept in encoded form.
+func (s *StateStream[T]) Update(fn func(value *T)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value := s.value
+	fn(&value)
+	return s.set(value)
+}
+
+// set replaces the value. The caller must hold s.mu.
+func (s *StateStream[T]) set(value T) error {
+	doc, err := toDocument(value)
+	if err != nil {
+		return err
+	}
+
+	var (
+		event = PatchEvent
+		patch any
+	)
+	switch s.mode {
+	case MergePatch:
+		var changed bool
+		if patch, changed = jsonpatch.MergeDiff(s.doc, doc); !changed {
+			s.value = value
+			return nil
+		}
+		event = MergePatchEvent
+	default:
+		ops := jsonpatch.Diff(s.doc, doc)
+		if len(ops) == 0 {
+			s.value = value
+			return nil
+		}
+		patch = ops
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	version := s.ids.id(s.version + 1)
+	if err := s.broker.PublishEvent(Event{ID: version, Event: event, Data: string(data)}); err != nil {
+		return err
+	}
+	s.version++
+	s.value, s.doc = value, doc
+	return nil
+}
+
+// Version returns the version of the state, which starts at 0 and grows by one
+// with each patch. Event ids carry it after the epoch of the stream.
+func (s *StateStream[T]) Version() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.version
+}
+
+// subscribe subscribes w to the patches after lastEventID, starting with a
+// snapshot if they are not all buffered.
+func (s *StateStream[T]) subscribe(w EventWriter, lastEventID string) (func(), bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unsubscribe, _ := s.broker.subscribe(w, lastEventID, nil, resume(s.ids, lastEventID, s.version, -1, func() Event {
+		data, _ := json.Marshal(s.doc)
+		return Event{ID: s.ids.id(s.version), Event: SnapshotEvent, Data: string(data)}
+	}))
+	return unsubscribe, true
+}
+
+// versionIDs turns versions into event ids prefixed with an epoch.
+type versionIDs struct {
+	epoch string
+}
+
+// newVersionIDs returns versionIDs with a random epoch.
+func newVersionIDs() versionIDs {
+	return versionIDs{epoch: strconv.FormatUint(rand.Uint64(), 36)}
+}
+
+// id returns the event id of a version.
+func (v versionIDs) id(version uint64) string {
+	return v.epoch + "-" + strconv.FormatUint(version, 10)
+}
+
+// owns reports whether id is the id of a version of this epoch.
+func (v versionIDs) owns(id string) bool {
+	return strings.HasPrefix(id, v.epoch+"-")
+}
+
+// resume returns a resume function for Broker.subscribe that writes the missed
+// events if lastEventID is of the same epoch, they are all buffered and there
+// are at most maxMissed of them, or else the snapshot. A negative maxMissed
+// means no limit. Nothing is written to a client that is already at version.
+func resume(ids versionIDs, lastEventID string, version uint64, maxMissed int, snapshot func() Event) func([]Event, bool) []Event {
+	return func(missed []Event, complete bool) []Event {
+		switch {
+		case lastEventID == ids.id(version):
+			return nil
+		case lastEventID != "" && ids.owns(lastEventID) && complete && (maxMissed < 0 || len(missed) <= maxMissed):
+			return missed
+		}
+		return []Event{snapshot()}
+	}
+}
+
+// ServeHTTP streams the state to a client.
+func (s *StateStream[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.broker.serve(w, r, s.subscribe)
+}
+
+// Len returns the number of clients.
+func (s *StateStream[T]) Len() int {
+	return s.broker.Len()
+}
+
+// Close ends the streams of all clients and rejects further changes with
+// ErrClosed.
+func (s *StateStream[T]) Close() error {
+	return s.broker.Close()
+}
+
+// State rebuilds the value of a StateStream from its events. The zero value
+// is ready to use. A State is not safe for concurrent use.
+//
+//	var state sse.State[Scores]
+//	for {
+//		event, err := client.Next(ctx)
+//		...
+//		if changed, err := state.Apply(event); changed {
+//			render(state.Value())
+//		}
+//	}
+type State[T any] struct {
+	doc     any
+	value   T
+	version string
+	loaded  bool
+}
+
+// Apply applies a snapshot or patch event and reports whether it changed the
+// state. Other events are ignored. If the event cannot be applied, the state
+// is left as it was and an error is returned; the client should then
+// reconnect without a Last-Event-ID to receive a new snapshot.
+func (s *State[T]) Apply(e Event) (bool, error) {
+	var (
+		doc any
+		err error
+	)
+	switch e.Event {
+	case SnapshotEvent:
+		doc, err = jsonpatch.Decode([]byte(e.Data))
+	case PatchEvent:
+		if !s.loaded {
+			return false, ErrNoSnapshot
+		}
+		var ops []jsonpatch.Operation
+		if err = json.Unmarshal([]byte(e.Data), &ops); err == nil {
+			doc, err = jsonpatch.Apply(s.doc, ops)
+		}
+	case MergePatchEvent:
+		if !s.loaded {
+			return false, ErrNoSnapshot
+		}
+		var patch any
+		if patch, err = jsonpatch.Decode([]byte(e.Data)); err == nil {
+			doc = jsonpatch.MergeApply(s.doc, patch)
+		}
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return false, err
+	}
+	var value T
+	if err := json.Unmarshal(data, &value); err != nil {
+		return false, err
+	}
+	s.doc, s.value, s.version, s.loaded = doc, value, e.ID, true
+	return true, nil
+}
+
+// Value returns the current value.
+func (s *State[T]) Value() T {
+	return s.value
+}
+
+// Version returns the id of the last event applied, which a client passes as
+// its Last-Event-ID to resume the stream.
+func (s *State[T]) Version() string {
+	return s.version
+}
+
+// toDocument encodes a value as a JSON document.
+func toDocument(value any) (any, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return jsonpatch.Decode(data)
+}
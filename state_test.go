@@ -0,0 +1,169 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// scores is the state used in tests.
+type scores struct {
+	Players map[string]int `json:"players"`
+	Round   int            `json:"round"`
+}
+
+func TestStateStream(t *testing.T) {
+	stream, err := NewStateStream(scores{Players: map[string]int{"alice": 1}}, StateOptions{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer stream.Close()
+	server := httptest.NewServer(stream)
+	defer server.Close()
+
+	client := NewClient(server.URL, ClientOptions{})
+	defer client.Close()
+	var state State[scores]
+	next := func() Event {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		event, err := client.Next(ctx)
+		if err != nil {
+			t.Fatalf("expected an event, got %v", err)
+		}
+		if _, err := state.Apply(event); err != nil {
+			t.Fatalf("expected %+v to apply, got %v", event, err)
+		}
+		return event
+	}
+
+	if event := next(); event.Event != SnapshotEvent || event.ID != stream.ids.id(0) {
+		t.Errorf("expected a snapshot at version 0, got %+v", event)
+	}
+	err = stream.Update(func(s *scores) {
+		s.Players["bob"] = 2
+		s.Round++
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := stream.Update(func(s *scores) {}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := stream.Set(scores{Players: map[string]int{"bob": 3}, Round: 1}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	event := next()
+	expected := `[{"op":"add","path":"/players/bob","value":2},{"op":"replace","path":"/round","value":1}]`
+	if event.Event != PatchEvent || event.ID != stream.ids.id(1) || event.Data != expected {
+		t.Errorf("expected patch %s at version 1, got %+v", expected, event)
+	}
+	next()
+	if expected := (scores{Players: map[string]int{"bob": 3}, Round: 1}); !reflect.DeepEqual(state.Value(), expected) {
+		t.Errorf("expected %+v, got %+v", expected, state.Value())
+	}
+	if state.Version() != stream.ids.id(2) || stream.Version() != 2 {
+		t.Errorf("expected version 2, got %q and %d", state.Version(), stream.Version())
+	}
+}
+
+func TestStateStream_Resume(t *testing.T) {
+	stream, err := NewStateStream([]int{}, StateOptions{Broker: BrokerOptions{ReplaySize: 2}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		if err := stream.Update(func(v *[]int) { *v = append(*v, i) }); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	id := stream.ids.id
+	snapshot := []Event{{ID: id(3), Event: SnapshotEvent, Data: "[1,2,3]"}}
+	tests := []struct {
+		lastEventID string
+		events      []Event
+	}{
+		{"", snapshot},
+		{id(3), nil},
+		{id(2), []Event{{ID: id(3), Event: PatchEvent, Data: `[{"op":"add","path":"/2","value":3}]`}}},
+		{id(1), snapshot},
+		{id(42), snapshot},
+		// Versions of an earlier process.
+		{"3", snapshot},
+		{"earlier-3", snapshot},
+	}
+	for _, tc := range tests {
+		var c eventCollector
+		unsubscribe, complete := stream.subscribe(&c, tc.lastEventID)
+		if !complete || !reflect.DeepEqual(c.events, tc.events) {
+			t.Errorf("%q: expected %+v, got %+v", tc.lastEventID, tc.events, c.events)
+		}
+		unsubscribe()
+	}
+}
+
+func TestStateStream_Backplane(t *testing.T) {
+	_, err := NewStateStream(scores{}, StateOptions{Broker: BrokerOptions{Backplane: &Loopback{}}})
+	if !errors.Is(err, ErrBackplaneUnsupported) {
+		t.Errorf("expected ErrBackplaneUnsupported, got %v", err)
+	}
+}
+
+func TestStateStream_MergePatch(t *testing.T) {
+	stream, err := NewStateStream(scores{Players: map[string]int{"alice": 1, "bob": 2}}, StateOptions{Mode: MergePatch})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var c eventCollector
+	stream.subscribe(&c, "")
+	if err := stream.Set(scores{Players: map[string]int{"alice": 5}, Round: 2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	expected := Event{ID: stream.ids.id(1), Event: MergePatchEvent, Data: `{"players":{"alice":5,"bob":null},"round":2}`}
+	if len(c.events) != 2 || c.events[1] != expected {
+		t.Fatalf("expected a snapshot and %+v, got %+v", expected, c.events)
+	}
+	var state State[scores]
+	for _, e := range c.events {
+		if _, err := state.Apply(e); err != nil {
+			t.Fatalf("expected %+v to apply, got %v", e, err)
+		}
+	}
+	if expected := (scores{Players: map[string]int{"alice": 5}, Round: 2}); !reflect.DeepEqual(state.Value(), expected) {
+		t.Errorf("expected %+v, got %+v", expected, state.Value())
+	}
+}
+
+func TestState_Apply(t *testing.T) {
+	var state State[[]int]
+	if _, err := state.Apply(Event{Event: PatchEvent, Data: "[]"}); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("expected ErrNoSnapshot, got %v", err)
+	}
+	if changed, err := state.Apply(Event{Event: "other", Data: "x"}); changed || err != nil {
+		t.Errorf("expected other events to be ignored, got %v, %v", changed, err)
+	}
+	if _, err := state.Apply(Event{ID: "1", Event: SnapshotEvent, Data: "[1]"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, e := range []Event{
+		{ID: "2", Event: PatchEvent, Data: `[{"op":"remove","path":"/1"}]`},
+		{ID: "2", Event: PatchEvent, Data: `{}`},
+		{ID: "2", Event: PatchEvent, Data: `[{"op":"replace","path":"","value":"x"}]`},
+		{ID: "2", Event: SnapshotEvent, Data: `[`},
+	} {
+		if _, err := state.Apply(e); err == nil {
+			t.Errorf("%+v: expected an error", e)
+		}
+	}
+	if !reflect.DeepEqual(state.Value(), []int{1}) || state.Version() != "1" {
+		t.Errorf("expected the state to be left as it was, got %v at %q", state.Value(), state.Version())
+	}
+}
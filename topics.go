@@ -0,0 +1,102 @@
+package sse
+
+import "strings"
+
+// topicTrie indexes subscribers by the segments of their topic patterns, so
+// that the subscribers of a topic are found without matching every pattern.
+type topicTrie struct {
+	root topicNode
+}
+
+// topicNode is a node of a topicTrie. Its children are keyed by segment,
+// including "*".
+type topicNode struct {
+	children map[string]*topicNode
+	subs     map[*subscriber]struct{} // patterns ending at this node
+	rest     map[*subscriber]struct{} // patterns ending with ">" after this node
+}
+
+// add adds a subscriber for a pattern.
+func (t *topicTrie) add(pattern []string, sub *subscriber) {
+	n := &t.root
+	for _, segment := range pattern {
+		if segment == ">" {
+			n.rest = addSubscriber(n.rest, sub)
+			return
+		}
+		child, ok := n.children[segment]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[string]*topicNode)
+			}
+			child = &topicNode{}
+			n.children[segment] = child
+		}
+		n = child
+	}
+	n.subs = addSubscriber(n.subs, sub)
+}
+
+// remove removes a subscriber for a pattern, pruning the nodes left empty.
+func (t *topicTrie) remove(pattern []string, sub *subscriber) {
+	t.root.remove(pattern, sub)
+}
+
+// remove removes a subscriber for a pattern below n, and reports whether n
+// is left empty.
+func (n *topicNode) remove(pattern []string, sub *subscriber) bool {
+	switch {
+	case len(pattern) == 0:
+		delete(n.subs, sub)
+	case pattern[0] == ">":
+		delete(n.rest, sub)
+	default:
+		if child, ok := n.children[pattern[0]]; ok && child.remove(pattern[1:], sub) {
+			delete(n.children, pattern[0])
+		}
+	}
+	return len(n.children) == 0 && len(n.subs) == 0 && len(n.rest) == 0
+}
+
+// match adds the subscribers of the patterns matching a topic to matched.
+func (t *topicTrie) match(topic string, matched map[*subscriber]struct{}) {
+	t.root.match(strings.Split(topic, "."), matched)
+}
+
+func (n *topicNode) match(topic []string, matched map[*subscriber]struct{}) {
+	if len(topic) == 0 {
+		for sub := range n.subs {
+			matched[sub] = struct{}{}
+		}
+		return
+	}
+	for sub := range n.rest {
+		matched[sub] = struct{}{}
+	}
+	if child, ok := n.children[topic[0]]; ok {
+		child.match(topic[1:], matched)
+	}
+	if child, ok := n.children["*"]; ok {
+		child.match(topic[1:], matched)
+	}
+}
+
+// addSubscriber adds a subscriber to a set, creating it if needed.
+func addSubscriber(set map[*subscriber]struct{}, sub *subscriber) map[*subscriber]struct{} {
+	if set == nil {
+		set = make(map[*subscriber]struct{})
+	}
+	set[sub] = struct{}{}
+	return set
+}
+
+// validTopic reports whether a topic can be published on: it has no empty
+// segments and no wildcards.
+func validTopic(topic string) bool {
+	for _, segment := range strings.Split(topic, ".") {
+		if segment == "" || segment == "*" || segment == ">" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package sse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTopicTrie(t *testing.T) {
+	subs := map[string]*subscriber{}
+	var trie topicTrie
+	for _, pattern := range []string{
+		"orders.*",
+		"orders.>",
+		"orders.42",
+		"tenant.*.orders.>",
+		"tenant.42.>",
+		"*",
+	} {
+		subs[pattern] = &subscriber{}
+		trie.add(strings.Split(pattern, "."), subs[pattern])
+	}
+
+	tests := map[string][]string{
+		"orders":                 {"*"},
+		"orders.42":              {"orders.*", "orders.>", "orders.42"},
+		"orders.7":               {"orders.*", "orders.>"},
+		"orders.42.items":        {"orders.>"},
+		"tenant.42":              nil,
+		"tenant.42.orders":       {"tenant.42.>"},
+		"tenant.42.orders.1":     {"tenant.*.orders.>", "tenant.42.>"},
+		"tenant.7.orders.1.item": {"tenant.*.orders.>"},
+		"users.1":                nil,
+	}
+	for topic, patterns := range tests {
+		matched := make(map[*subscriber]struct{})
+		trie.match(topic, matched)
+		if len(matched) != len(patterns) {
+			t.Errorf("%s: expected %v, got %d subscribers", topic, patterns, len(matched))
+		}
+		for _, pattern := range patterns {
+			if _, ok := matched[subs[pattern]]; !ok {
+				t.Errorf("%s: expected %s to match", topic, pattern)
+			}
+		}
+	}
+
+	for pattern, sub := range subs {
+		trie.remove(strings.Split(pattern, "."), sub)
+	}
+	if len(trie.root.children) != 0 || len(trie.root.subs) != 0 || len(trie.root.rest) != 0 {
+		t.Errorf("expected the trie to be pruned, got %+v", trie.root)
+	}
+}
+
+func TestValidTopic(t *testing.T) {
+	for topic, expected := range map[string]bool{
+		"orders":       true,
+		"orders.42":    true,
+		"orders.*":     false,
+		"orders.>":     false,
+		"orders..42":   false,
+		".orders":      false,
+		"orders.4*2.x": true,
+	} {
+		if actual := validTopic(topic); actual != expected {
+			t.Errorf("%q: expected %v, got %v", topic, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package sse
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidTraceParent is returned when parsing a malformed traceparent.
+var ErrInvalidTraceParent = errors.New("sse: invalid traceparent")
+
+// Tracer starts spans. It mirrors the part of the OpenTelemetry tracing API
+// the writer needs, so that an adapter for any tracing library is a few lines
+// long. A tracer should use SpanContextFromContext to find the parent of a
+// new span.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a single operation within a trace.
+type Span interface {
+	// SpanContext returns the identity of the span.
+	SpanContext() SpanContext
+
+	// SetAttributes records attributes on the span.
+	SetAttributes(attrs ...Attribute)
+
+	// RecordError records an error on the span.
+	RecordError(err error)
+
+	// End completes the span.
+	End()
+}
+
+// Attribute is a key-value pair describing a span.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// SpanContext identifies a span, as propagated by the W3C Trace Context
+// traceparent header.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+// IsValid reports whether both the trace id and the span id are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent formats the span context as a traceparent value.
+func (sc SpanContext) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-%02x",
+		hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), sc.Flags)
+}
+
+// ParseTraceParent parses a traceparent value.
+func ParseTraceParent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(s, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, ErrInvalidTraceParent
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, ErrInvalidTraceParent
+	}
+	if !decodeLowerHex(sc.TraceID[:], parts[1]) || !decodeLowerHex(sc.SpanID[:], parts[2]) {
+		return sc, ErrInvalidTraceParent
+	}
+	var flags [1]byte
+	if !decodeLowerHex(flags[:], parts[3]) {
+		return sc, ErrInvalidTraceParent
+	}
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		return sc, ErrInvalidTraceParent
+	}
+	return sc, nil
+}
+
+// decodeLowerHex decodes s into dst, which must be filled exactly. Only lower
+// case hexadecimal digits are accepted.
+func decodeLowerHex(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// spanContextKey is the context key of the current span context.
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx carrying the span context.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context carried by ctx, if any.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
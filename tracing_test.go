@@ -0,0 +1,194 @@
+package sse
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// spanRecorder is an in-memory Tracer recording every span it starts.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	recorder *spanRecorder
+	name     string
+	parent   SpanContext
+	sc       SpanContext
+	attrs    map[string]interface{}
+	errs     []error
+	ended    bool
+}
+
+func (sr *spanRecorder) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	s := &recordedSpan{recorder: sr, name: name, attrs: make(map[string]interface{})}
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		s.parent = parent
+		s.sc.TraceID = parent.TraceID
+	} else {
+		binary.BigEndian.PutUint64(s.sc.TraceID[8:], uint64(len(sr.spans)+1))
+	}
+	binary.BigEndian.PutUint64(s.sc.SpanID[:], uint64(len(sr.spans)+1))
+	s.sc.Flags = 1
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+	sr.spans = append(sr.spans, s)
+	return ContextWithSpanContext(ctx, s.sc), s
+}
+
+func (sr *spanRecorder) byName(name string) []*recordedSpan {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	var spans []*recordedSpan
+	for _, s := range sr.spans {
+		if s.name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func (s *recordedSpan) SpanContext() SpanContext { return s.sc }
+
+func (s *recordedSpan) SetAttributes(attrs ...Attribute) {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recordedSpan) RecordError(err error) {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	s.errs = append(s.errs, err)
+}
+
+func (s *recordedSpan) End() {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	s.ended = true
+}
+
+func TestTracing(t *testing.T) {
+	var tracer spanRecorder
+	publisherCtx, publisher := tracer.Start(context.Background(), "publish")
+
+	rec := httptest.NewRecorder()
+	writer := NewResponseWriterContext(context.Background(), rec, Options{Tracer: &tracer})
+	cw := writer.(ContextWriter)
+	if err := cw.WriteContext(publisherCtx, "update", "test"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := writer.Write("ping", nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := writer.(CloseWriter).Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	connections := tracer.byName("sse.connection")
+	if len(connections) != 1 || !connections[0].ended {
+		t.Fatalf("expected an ended connection span, got %+v", connections)
+	}
+	connection := connections[0]
+	if connection.attrs["sse.close_reason"] != string(CloseReasonClosed) {
+		t.Errorf("unexpected connection attributes: %v", connection.attrs)
+	}
+
+	writes := tracer.byName("sse.write")
+	if len(writes) != 2 {
+		t.Fatalf("expected 2 write spans, got %d", len(writes))
+	}
+	if writes[0].parent != publisher.SpanContext() {
+		t.Errorf("expected first write to be a child of the publisher")
+	}
+	if writes[1].parent != connection.sc {
+		t.Errorf("expected second write to be a child of the connection")
+	}
+	for _, w := range writes {
+		if !w.ended || len(w.errs) != 0 {
+			t.Errorf("expected write span to end without errors, got %+v", w)
+		}
+	}
+
+	// The client continues the trace of the first write.
+	decoder := NewDecoder(strings.NewReader(rec.Body.String()))
+	event, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if event.TraceParent != writes[0].sc.TraceParent() {
+		t.Fatalf("expected traceparent %q, got %q", writes[0].sc.TraceParent(), event.TraceParent)
+	}
+	_, consumer := tracer.Start(event.Context(context.Background()), "consume")
+	if consumer.SpanContext().TraceID != publisher.SpanContext().TraceID {
+		t.Errorf("expected the consumer to continue the publisher's trace")
+	}
+}
+
+func TestTracing_Dropped(t *testing.T) {
+	var tracer spanRecorder
+	writer := NewResponseWriter(httptest.NewRecorder(), Options{
+		Tracer:        &tracer,
+		FlushInterval: time.Hour,
+		Coalesce:      CoalesceByEvent,
+	}).(CloseWriter)
+
+	for i := 0; i < 2; i++ {
+		if err := writer.Write("tick", i); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	writes := tracer.byName("sse.write")
+	if len(writes) != 2 || !writes[0].ended || !writes[1].ended {
+		t.Fatalf("expected 2 ended write spans, got %+v", writes)
+	}
+	if writes[0].attrs["sse.dropped"] != string(DropReasonCoalesced) {
+		t.Errorf("expected first write to be dropped, got %v", writes[0].attrs)
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"Valid", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"Future Version", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"Invalid Version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"Extra Fields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"Upper Case", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", true},
+		{"Zero Trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", true},
+		{"Zero Span ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", true},
+		{"Short", "00-4bf92f35-00f067aa0ba902b7-01", true},
+		{"Empty", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceParent(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTraceParent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && tt.name == "Valid" && sc.TraceParent() != tt.input {
+				t.Errorf("TraceParent() = %s, want %s", sc.TraceParent(), tt.input)
+			}
+		})
+	}
+}
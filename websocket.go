@@ -0,0 +1,335 @@
+package sse
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBadHandshake is returned when a request to upgrade to WebSocket is
+// invalid. The client has been sent an error response.
+var ErrBadHandshake = errors.New("sse: bad WebSocket handshake")
+
+// webSocketGUID is appended to the key of a handshake to compute the accept
+// key (RFC 6455, section 1.3).
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC11B85"
+
+// maxClientFrame is the maximum size of a frame sent by a client. Clients
+// are not expected to send anything but control frames.
+const maxClientFrame = 64 << 10
+
+// writeTimeout bounds the time to write frames to a client.
+const writeTimeout = 10 * time.Second
+
+// closeTimeout bounds the time to send a close frame.
+const closeTimeout = time.Second
+
+// WebSocket opcodes (RFC 6455, section 5.2).
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xa
+)
+
+// WebSocket close codes (RFC 6455, section 7.4.1).
+const (
+	closeNormal    = 1000
+	closeGoingAway = 1001
+	closeProtocol  = 1002
+	closeTooBig    = 1009
+	closeInternal  = 1011
+)
+
+// IsWebSocket reports whether r asks to upgrade the connection to
+// WebSocket.
+func IsWebSocket(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") &&
+		headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// NewRequestWriter creates a Writer for the request r. If opts.WebSocket is
+// set and r asks to upgrade to WebSocket, the stream is served over
+// WebSocket; otherwise it is like NewResponseWriterContext with the request's
+// context. The returned Writer also implements CloseWriter, ContextWriter and
+// EventWriter.
+//
+// If the upgrade fails, the client has been sent an error response and an
+// error is returned.
+func NewRequestWriter(w http.ResponseWriter, r *http.Request, opts Options) (Writer, error) {
+	return newRequestWriter(w, r, opts)
+}
+
+// NewAsyncRequestWriter is like NewAsyncWriter, but serves the stream over
+// WebSocket if opts.WebSocket is set and r asks to upgrade, as
+// NewRequestWriter does.
+func NewAsyncRequestWriter(w http.ResponseWriter, r *http.Request, opts Options, queueSize int) (*AsyncWriter, error) {
+	rw, err := newRequestWriter(w, r, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newAsyncWriter(rw, queueSize), nil
+}
+
+// newRequestWriter creates a responseWriter for a request, upgrading the
+// connection if needed.
+func newRequestWriter(w http.ResponseWriter, r *http.Request, opts Options) (*responseWriter, error) {
+	if !opts.WebSocket || !IsWebSocket(r) {
+		return newWriter(r.Context(), w, nil, r.RemoteAddr, opts), nil
+	}
+	ws, err := upgrade(w, r, opts.CheckOrigin)
+	if err != nil {
+		return nil, err
+	}
+	// Frames are not compressed.
+	opts.Encoding = EncodeNone
+	rw := newWriter(r.Context(), nil, ws, r.RemoteAddr, opts)
+	go rw.readWebSocket()
+	return rw, nil
+}
+
+// upgrade performs the server side of the WebSocket handshake and takes over
+// the connection. checkOrigin is Options.CheckOrigin.
+func upgrade(w http.ResponseWriter, r *http.Request, checkOrigin func(*http.Request) bool) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); r.Method != http.MethodGet || err != nil || len(decoded) != 16 {
+		http.Error(w, ErrBadHandshake.Error(), http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, ErrBadHandshake.Error(), http.StatusUpgradeRequired)
+		return nil, ErrBadHandshake
+	}
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		http.Error(w, ErrBadHandshake.Error(), http.StatusForbidden)
+		return nil, ErrBadHandshake
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "sse: connection cannot be upgraded", http.StatusInternalServerError)
+		return nil, errors.New("sse: ResponseWriter is not a Hijacker")
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, r: brw.Reader, w: brw.Writer}, nil
+}
+
+// sameOrigin reports whether r has no Origin header, or an origin whose host
+// is the Host of r.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// headerContains reports whether a header holds a comma-separated token,
+// ignoring case.
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn is the server side of a WebSocket connection.
+type wsConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	closing atomic.Bool
+
+	mu sync.Mutex // guards writes
+	w  *bufio.Writer
+}
+
+// writeText writes each payload as a text frame, and flushes them together.
+func (c *wsConn) writeText(payloads []string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing.Load() {
+		return 0, ErrClosed
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	n := 0
+	for _, p := range payloads {
+		n += c.writeFrame(opText, []byte(p))
+	}
+	return n, c.w.Flush()
+}
+
+// writeControl writes a control frame.
+func (c *wsConn) writeControl(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing.Load() {
+		return ErrClosed
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	c.writeFrame(opcode, payload)
+	return c.w.Flush()
+}
+
+// writeFrame buffers a final, unmasked frame and returns its size. The
+// caller must hold c.mu.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) int {
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	_, _ = c.w.Write(header)
+	_, _ = c.w.Write(payload)
+	return len(header) + len(payload)
+}
+
+// readFrame reads a frame sent by the client, which must be masked.
+func (c *wsConn) readFrame() (opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode = header[0] & 0x0f
+	if header[1]&0x80 == 0 {
+		return 0, nil, errWebSocketProtocol
+	}
+	size := uint64(header[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if size > maxClientFrame {
+		return 0, nil, errWebSocketTooBig
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// close sends a close frame with the given code, if the connection is still
+// open, and closes the connection.
+func (c *wsConn) close(code uint16) {
+	if c.closing.Swap(true) {
+		return
+	}
+	// The deadline also cuts short a write in progress, which holds c.mu.
+	_ = c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeFrame(opClose, binary.BigEndian.AppendUint16(nil, code))
+	_ = c.w.Flush()
+	c.conn.Close()
+}
+
+var (
+	errWebSocketProtocol = errors.New("sse: unmasked WebSocket frame")
+	errWebSocketTooBig   = errors.New("sse: WebSocket frame too big")
+)
+
+// readWebSocket reads the frames sent by the client until the connection is
+// closed: it answers pings, and closes the stream when the client closes the
+// connection.
+func (rw *responseWriter) readWebSocket() {
+	for {
+		opcode, payload, err := rw.ws.readFrame()
+		switch {
+		case errors.Is(err, errWebSocketTooBig):
+			rw.closeWebSocket(closeTooBig)
+			return
+		case err != nil:
+			rw.closeWebSocket(closeProtocol)
+			return
+		case opcode == opClose:
+			rw.closeWebSocket(closeNormal)
+			return
+		case opcode == opPing:
+			_ = rw.ws.writeControl(opPong, payload)
+		}
+	}
+}
+
+// closeWebSocket closes the stream because of the client, answering with
+// the given close code. The connection is closed before taking rw.mu, which
+// a write to a client that stopped reading holds until the write fails.
+func (rw *responseWriter) closeWebSocket(code uint16) {
+	rw.ws.close(code)
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.closed {
+		return
+	}
+	for _, m := range rw.pending {
+		m.end(ErrClosed)
+	}
+	rw.pending = nil
+	rw.close(CloseReasonClient)
+}
+
+// webSocketCloseCode returns the close code sent when a stream closes for a
+// reason.
+func webSocketCloseCode(reason CloseReason) uint16 {
+	switch reason {
+	case CloseReasonClosed:
+		return closeNormal
+	case CloseReasonError:
+		return closeInternal
+	default:
+		return closeGoingAway
+	}
+}
@@ -0,0 +1,319 @@
+package sse
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// wsClient is a minimal WebSocket client.
+type wsClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialWebSocket opens a WebSocket connection to the path of server.
+func dialWebSocket(t *testing.T, server *httptest.Server, path string) *wsClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	c := &wsClient{conn: conn, r: bufio.NewReader(conn)}
+	resp, err := http.ReadResponse(c.r, req)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		t.Fatalf("expected the upgrade to be accepted, got %s %v", resp.Status, resp.Header)
+	}
+	return c
+}
+
+// read reads a frame sent by the server.
+func (c *wsClient) read(t *testing.T) (byte, string) {
+	t.Helper()
+	_ = c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		t.Fatalf("expected a frame, got %v", err)
+	}
+	if header[0]&0x80 == 0 || header[1]&0x80 != 0 {
+		t.Fatalf("expected a final, unmasked frame, got %x", header)
+	}
+	size := int(header[1])
+	if size == 126 {
+		var ext [2]byte
+		_, _ = io.ReadFull(c.r, ext[:])
+		size = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		t.Fatalf("expected a payload, got %v", err)
+	}
+	return header[0] & 0x0f, string(payload)
+}
+
+// write writes a masked frame.
+func (c *wsClient) write(t *testing.T, opcode byte, payload string) {
+	t.Helper()
+	var mask [4]byte
+	_, _ = rand.Read(mask[:])
+	frame := append([]byte{0x80 | opcode, 0x80 | byte(len(payload))}, mask[:]...)
+	for i := range payload {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestIsWebSocket(t *testing.T) {
+	tests := []struct {
+		connection, upgrade string
+		expected            bool
+	}{
+		{"Upgrade", "websocket", true},
+		{"keep-alive, upgrade", "WebSocket", true},
+		{"keep-alive", "websocket", false},
+		{"Upgrade", "h2c", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Connection", test.connection)
+		r.Header.Set("Upgrade", test.upgrade)
+		if actual := IsWebSocket(r); actual != test.expected {
+			t.Errorf("%q, %q: expected %v, got %v", test.connection, test.upgrade, test.expected, actual)
+		}
+	}
+}
+
+func TestRequestWriter_WebSocket(t *testing.T) {
+	closed := make(chan CloseInfo, 1)
+	opts := Options{
+		WebSocket: true,
+		Encoding:  EncodeGzip,
+		Heartbeat: 50 * time.Millisecond,
+		Hooks:     Hooks{OnClose: func(info CloseInfo) { closed <- info }},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writer, err := NewRequestWriter(w, r, opts)
+		if err != nil {
+			return
+		}
+		_ = writer.Write("add", 1)
+		<-writer.(CloseWriter).Done()
+	}))
+	defer server.Close()
+
+	client := dialWebSocket(t, server, "/")
+	if opcode, payload := client.read(t); opcode != opText || payload != "id: 1\nevent: add\ndata: 1\n\n" {
+		t.Errorf("expected the event in a text frame, got %x %q", opcode, payload)
+	}
+	if opcode, payload := client.read(t); opcode != opText || payload != ": heartbeat\n\n" {
+		t.Errorf("expected a heartbeat in a text frame, got %x %q", opcode, payload)
+	}
+
+	client.write(t, opPing, "hello")
+	for {
+		opcode, payload := client.read(t)
+		if opcode == opPong {
+			if payload != "hello" {
+				t.Errorf("expected the ping payload, got %q", payload)
+			}
+			break
+		}
+	}
+
+	client.write(t, opClose, "\x03\xe8")
+	for {
+		opcode, payload := client.read(t)
+		if opcode == opClose {
+			if payload != "\x03\xe8" {
+				t.Errorf("expected close code 1000, got %x", payload)
+			}
+			break
+		}
+	}
+	select {
+	case info := <-closed:
+		if info.Reason != CloseReasonClient || info.Events != 1 {
+			t.Errorf("expected the client to close the stream after 1 event, got %+v", info)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the stream to close")
+	}
+}
+
+func TestRequestWriter_WebSocketLifetime(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writer, err := NewAsyncRequestWriter(w, r, Options{WebSocket: true, MaxLifetime: 50 * time.Millisecond, Retry: time.Second}, 4)
+		if err != nil {
+			return
+		}
+		defer writer.Close()
+		<-writer.Done()
+	}))
+	defer server.Close()
+
+	client := dialWebSocket(t, server, "/")
+	if opcode, payload := client.read(t); opcode != opText || !strings.Contains(payload, "retry: 1000\n") {
+		t.Errorf("expected a retry hint, got %x %q", opcode, payload)
+	}
+	if opcode, payload := client.read(t); opcode != opClose || payload != "\x03\xe9" {
+		t.Errorf("expected close code 1001, got %x %q", opcode, payload)
+	}
+}
+
+func TestRequestWriter_WebSocketStalled(t *testing.T) {
+	var written atomic.Int64
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writer, err := NewRequestWriter(w, r, Options{WebSocket: true})
+		if err != nil {
+			return
+		}
+		data := strings.Repeat("x", 64<<10)
+		for writer.Write("add", data) == nil {
+			written.Add(1)
+		}
+		<-writer.(CloseWriter).Done()
+		close(done)
+	}))
+	defer server.Close()
+	client := dialWebSocket(t, server, "/")
+	_ = client.conn.(*net.TCPConn).SetReadBuffer(4 << 10)
+
+	// The client stops reading until the writes block, then closes the
+	// connection: the blocked write fails and the stream closes.
+	for n := int64(-1); n != written.Load(); {
+		n = written.Load()
+		time.Sleep(100 * time.Millisecond)
+	}
+	client.write(t, opClose, "\x03\xe8")
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the stream to close")
+	}
+}
+
+func TestRequestWriter_NotWebSocket(t *testing.T) {
+	// Without the WebSocket option, an upgrade request gets an event stream.
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	w := httptest.NewRecorder()
+	if _, err := NewRequestWriter(w, r, Options{}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected an event stream, got %q", ct)
+	}
+
+	tests := map[string]struct {
+		version, key string
+		status       int
+	}{
+		"no key":      {"13", "", http.StatusBadRequest},
+		"invalid key": {"13", "abc", http.StatusBadRequest},
+		"old version": {"8", base64.StdEncoding.EncodeToString(make([]byte, 16)), http.StatusUpgradeRequired},
+	}
+	for name, test := range tests {
+		r.Header.Set("Sec-WebSocket-Version", test.version)
+		r.Header.Set("Sec-WebSocket-Key", test.key)
+		w := httptest.NewRecorder()
+		if _, err := NewRequestWriter(w, r, Options{WebSocket: true}); err != ErrBadHandshake {
+			t.Errorf("%s: expected ErrBadHandshake, got %v", name, err)
+		}
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", name, test.status, w.Code)
+		}
+	}
+}
+
+func TestRequestWriter_WebSocketOrigin(t *testing.T) {
+	trusted := func(r *http.Request) bool { return r.Header.Get("Origin") == "https://app.example.org" }
+	tests := []struct {
+		origin      string
+		checkOrigin func(*http.Request) bool
+		allowed     bool
+	}{
+		{"", nil, true},
+		{"http://example.com", nil, true},
+		{"https://Example.com", nil, true},
+		{"https://evil.example", nil, false},
+		{"https://example.com.evil.example", nil, false},
+		{"null", nil, false},
+		{"https://app.example.org", trusted, true},
+		{"http://example.com", trusted, false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(make([]byte, 16)))
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		// A recorder cannot be hijacked, so allowed upgrades fail later.
+		w := httptest.NewRecorder()
+		_, err := NewRequestWriter(w, r, Options{WebSocket: true, CheckOrigin: test.checkOrigin})
+		if refused := err == ErrBadHandshake && w.Code == http.StatusForbidden; refused == test.allowed {
+			t.Errorf("%q: expected allowed %v, got %v (%d)", test.origin, test.allowed, err, w.Code)
+		}
+	}
+}
+
+func TestBroker_WebSocket(t *testing.T) {
+	broker := NewBroker(BrokerOptions{Options: Options{WebSocket: true}})
+	defer broker.Close()
+	server := httptest.NewServer(broker)
+	defer server.Close()
+	for i := 1; i <= 3; i++ {
+		if err := broker.Publish("add", i); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	client := dialWebSocket(t, server, "/?lastEventId=1&event=add")
+	for _, expected := range []string{"id: 2\nevent: add\ndata: 2\n\n", "id: 3\nevent: add\ndata: 3\n\n"} {
+		if _, payload := client.read(t); payload != expected {
+			t.Errorf("expected %q to be replayed, got %q", expected, payload)
+		}
+	}
+	waitForSubscribers(t, broker, 1)
+	if err := broker.Publish("add", 4); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, payload := client.read(t); payload != "id: 4\nevent: add\ndata: 4\n\n" {
+		t.Errorf("expected event 4, got %q", payload)
+	}
+
+	client.write(t, opClose, "")
+	waitForSubscribers(t, broker, 0)
+}
@@ -1,74 +1,618 @@
 package sse
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math/rand"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	// NonceMax is the maximum value of the `id` field in SSE before it resets to 0.
 	NonceMax = 1<<63 - 1
+
+	// DefaultRetry is the reconnection delay hinted to clients when the writer
+	// closes the stream by itself and Options.Retry is not set.
+	DefaultRetry = 3 * time.Second
 )
 
+// ErrClosed is returned when writing to a stream that has been closed.
+var ErrClosed = errors.New("sse: stream closed")
+
+// ErrInvalidEvent is returned when an event cannot be represented in the event
+// stream format, because its id or type contains a line break or its id
+// contains a NUL character.
+var ErrInvalidEvent = errors.New("sse: invalid event")
+
 // Writer is the interface for writing Server-Sent Events.
 type Writer interface {
 	Write(event string, data interface{}) error
 }
 
+// CloseWriter is a Writer whose stream can be ended from the server side.
+// Handlers should return once Done is closed so the HTTP server can reclaim
+// the connection.
+type CloseWriter interface {
+	Writer
+
+	// Close ends the stream. Subsequent writes return ErrClosed.
+	Close() error
+
+	// Done returns a channel that is closed once the stream has been closed.
+	Done() <-chan struct{}
+}
+
+// ContextWriter is a Writer that can associate an event with a context, so
+// that the event is traced as part of the operation that produced it.
+type ContextWriter interface {
+	Writer
+
+	// WriteContext sends a message to the client on behalf of ctx.
+	WriteContext(ctx context.Context, event string, data interface{}) error
+}
+
+// EventWriter is a Writer that can write events verbatim, for example to
+// forward events read by a Decoder.
+type EventWriter interface {
+	Writer
+
+	// WriteEvent sends an event with the given id, type and data as is. If
+	// the event has no id, the writer assigns one.
+	WriteEvent(e Event) error
+}
+
 // Options holds configuration for the SSE writer.
 type Options struct {
 	ResponseStatus int
 	Encoding       string
+
+	// MaxLifetime closes the stream after a randomised duration between 90%
+	// and 100% of MaxLifetime, so clients reconnect and are rebalanced.
+	MaxLifetime time.Duration
+
+	// IdleTimeout closes the stream when no event other than a heartbeat has
+	// been written for this long.
+	IdleTimeout time.Duration
+
+	// Heartbeat sends a comment line at this interval to keep idle
+	// connections open through proxies.
+	Heartbeat time.Duration
+
+	// Retry is the reconnection delay hinted to the client when the writer
+	// closes the stream because of MaxLifetime or IdleTimeout. If zero,
+	// DefaultRetry is used.
+	Retry time.Duration
+
+	// Overflow decides what an AsyncWriter does when its queue is full.
+	Overflow OverflowPolicy
+
+	// FlushInterval buffers events and writes them to the client together,
+	// at most once per interval, instead of flushing after every event.
+	// Heartbeats are written on their own and leave the buffer pending.
+	FlushInterval time.Duration
+
+	// MaxBatch writes the buffered events as soon as this many are pending.
+	// It only has an effect together with FlushInterval.
+	MaxBatch int
+
+	// Coalesce returns the key of an event. When an event is written while
+	// an earlier event with the same non-empty key is still pending, only the
+	// newest one is sent. Pending events are those buffered by FlushInterval
+	// or queued by an AsyncWriter.
+	Coalesce func(event string, data interface{}) string
+
+	// Hooks are callbacks for logging and metrics.
+	Hooks Hooks
+
+	// Tracer, if set, traces the lifetime of the connection and every event
+	// written. Each event carries the traceparent of its span in an extra
+	// `traceparent` field, which EventSource ignores and Decoder extracts.
+	Tracer Tracer
+
+	// WebSocket serves the stream over WebSocket to clients that ask to
+	// upgrade, for networks whose proxies buffer text/event-stream. Each
+	// event, comment and retry hint is sent as a text frame holding it in the
+	// event stream format, so clients parse frames as they would parse the
+	// stream. Encoding is ignored. It applies to writers created with
+	// NewRequestWriter or NewAsyncRequestWriter, and to Broker.
+	WebSocket bool
+
+	// CheckOrigin reports whether a WebSocket upgrade of r is allowed.
+	// Browsers open WebSockets from any site, with the user's cookies, so if
+	// nil an upgrade is only allowed without an Origin header or from an
+	// origin whose host is the Host of the request. Refused upgrades get a
+	// 403 Forbidden response.
+	CheckOrigin func(r *http.Request) bool
+
+	// Logger receives structured records about connections, events and
+	// errors. Every record carries the connection id and encoding, and the
+	// remote address for writers created by NewRequestWriter. If nil,
+	// nothing is logged.
+	Logger *slog.Logger
 }
 
 // NewResponseWriter creates a new Writer for Server-Sent Events.
+// The returned Writer also implements CloseWriter, ContextWriter and
+// EventWriter.
+//
+// When MaxLifetime, IdleTimeout or Heartbeat are set, the writer writes to the
+// stream on its own. The handler must then close the writer before it returns.
 func NewResponseWriter(w http.ResponseWriter, opts Options) Writer {
+	return newResponseWriter(context.Background(), w, opts)
+}
+
+// NewResponseWriterContext is like NewResponseWriter, but the span of the
+// connection is started as a child of the span carried by ctx, usually the
+// request's context.
+func NewResponseWriterContext(ctx context.Context, w http.ResponseWriter, opts Options) Writer {
+	return newResponseWriter(ctx, w, opts)
+}
+
+// newResponseWriter creates a responseWriter and sends the response headers.
+func newResponseWriter(ctx context.Context, w http.ResponseWriter, opts Options) *responseWriter {
+	return newWriter(ctx, w, nil, "", opts)
+}
+
+// newWriter creates a responseWriter writing to w, or to ws if it is not nil,
+// and sends the response headers. remoteAddr is the address of the client,
+// if known.
+func newWriter(ctx context.Context, w http.ResponseWriter, ws *wsConn, remoteAddr string, opts Options) *responseWriter {
 	rw := &responseWriter{
+		id:      connIDs.Add(1),
 		writer:  w,
+		ws:      ws,
 		nonce:   0,
 		options: opts,
+		done:    make(chan struct{}),
+		opened:  time.Now(),
+		ctx:     ctx,
+	}
+	rw.log = newLogger(opts.Logger, rw.id, remoteAddr, opts.Encoding)
+	if opts.Tracer != nil {
+		rw.ctx, rw.span = opts.Tracer.Start(ctx, "sse.connection",
+			Attribute{Key: "sse.conn_id", Value: rw.id},
+			Attribute{Key: "sse.encoding", Value: opts.Encoding},
+		)
 	}
 	rw.sendHeaders()
+	rw.startTimers()
 	return rw
 }
 
 type responseWriter struct {
-	writer  http.ResponseWriter
-	nonce   uint64
-	options Options
+	mu        sync.Mutex
+	id        uint64
+	writer    http.ResponseWriter
+	ws        *wsConn // set instead of writer for WebSocket
+	nonce     uint64
+	options   Options
+	closed    bool
+	done      chan struct{}
+	onClose   func()
+	lastEvent time.Time
+	timers    []*time.Timer
+	pending   []message
+	batch     *time.Timer
+	err       error
+	opened    time.Time
+	events    int64
+	bytes     int64
+	ctx       context.Context
+	span      Span
+	log       *slog.Logger
 }
 
-// Write sends a message to the client.
-func (rw *responseWriter) Write(event string, data interface{}) error {
-	rw.nonce = (rw.nonce + 1) % NonceMax
+// message is a single event waiting to be framed.
+type message struct {
+	id          string
+	event       string
+	data        []byte
+	retry       time.Duration
+	comment     string
+	key         string
+	span        Span
+	traceParent string
+	topic       string
+}
 
-	output := fmt.Sprintf("id: %d\n", rw.nonce)
-	if event != "" {
-		output += fmt.Sprintf("event: %s\n", event)
+// end ends the span of the message, if any, recording err.
+func (m message) end(err error) {
+	if m.span == nil {
+		return
+	}
+	if err != nil {
+		m.span.RecordError(err)
 	}
+	m.span.End()
+}
+
+// drop ends the span of a message that is discarded before reaching the
+// client.
+func (m message) drop(reason DropReason) {
+	if m.span != nil {
+		m.span.SetAttributes(Attribute{Key: "sse.dropped", Value: string(reason)})
+	}
+	m.end(nil)
+}
+
+// newMessage validates the event type and marshals data into a message.
+func newMessage(event string, data interface{}) (message, error) {
+	if strings.ContainsAny(event, "\r\n") {
+		return message{}, ErrInvalidEvent
+	}
+	m := message{event: event}
 	if data != nil {
 		encodedData, err := json.Marshal(data)
 		if err != nil {
+			return message{}, err
+		}
+		m.data = encodedData
+	}
+	return m, nil
+}
+
+// newEventMessage validates an event and turns it into a message.
+func newEventMessage(e Event) (message, error) {
+	if strings.ContainsAny(e.ID, "\r\n\x00") || strings.ContainsAny(e.Event+e.Topic, "\r\n") {
+		return message{}, ErrInvalidEvent
+	}
+	return message{id: e.ID, event: e.Event, data: []byte(e.Data), topic: e.Topic}, nil
+}
+
+// Write sends a message to the client.
+func (rw *responseWriter) Write(event string, data interface{}) error {
+	return rw.WriteContext(rw.ctx, event, data)
+}
+
+// WriteContext sends a message to the client on behalf of ctx.
+func (rw *responseWriter) WriteContext(ctx context.Context, event string, data interface{}) error {
+	m, err := rw.newMessage(ctx, event, data)
+	if err != nil {
+		return err
+	}
+	return rw.write(m)
+}
+
+// WriteEvent sends an event to the client as is.
+func (rw *responseWriter) WriteEvent(e Event) error {
+	m, err := rw.newEventMessage(e)
+	if err != nil {
+		return err
+	}
+	return rw.write(m)
+}
+
+// write sends or buffers a message.
+func (rw *responseWriter) write(m message) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.err != nil {
+		m.end(rw.err)
+		return rw.err
+	}
+	if rw.closed {
+		m.end(ErrClosed)
+		return ErrClosed
+	}
+	if rw.options.FlushInterval > 0 {
+		return rw.buffer(m)
+	}
+	return rw.send(m)
+}
+
+// newMessage marshals data into a message, sets its coalescing key and starts
+// its span.
+func (rw *responseWriter) newMessage(ctx context.Context, event string, data interface{}) (message, error) {
+	m, err := newMessage(event, data)
+	if errors.Is(err, ErrInvalidEvent) {
+		return message{}, err
+	}
+	if err != nil {
+		rw.mu.Lock()
+		rw.reportError("marshal", event, err)
+		rw.mu.Unlock()
+		return message{}, err
+	}
+	if rw.options.Coalesce != nil {
+		m.key = rw.options.Coalesce(event, data)
+	}
+	return rw.startSpan(ctx, m), nil
+}
+
+// newEventMessage turns an event into a message, sets its coalescing key and
+// starts its span.
+func (rw *responseWriter) newEventMessage(e Event) (message, error) {
+	m, err := newEventMessage(e)
+	if err != nil {
+		return message{}, err
+	}
+	if rw.options.Coalesce != nil {
+		m.key = rw.options.Coalesce(e.Event, e.Data)
+	}
+	return rw.startSpan(rw.ctx, m), nil
+}
+
+// startSpan starts the span of a message, if tracing is enabled.
+func (rw *responseWriter) startSpan(ctx context.Context, m message) message {
+	if rw.options.Tracer == nil {
+		return m
+	}
+	_, m.span = rw.options.Tracer.Start(ctx, "sse.write",
+		Attribute{Key: "sse.conn_id", Value: rw.id},
+		Attribute{Key: "sse.event", Value: m.event},
+	)
+	if sc := m.span.SpanContext(); sc.IsValid() {
+		m.traceParent = sc.TraceParent()
+	}
+	return m
+}
+
+// Close sends the buffered events, if any, and ends the stream.
+func (rw *responseWriter) Close() error {
+	return rw.closeReason(CloseReasonClosed)
+}
+
+// closeReason sends the buffered events, if any, and ends the stream for the
+// given reason.
+func (rw *responseWriter) closeReason(reason CloseReason) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.closed {
+		return nil
+	}
+	err := rw.send()
+	if err != nil {
+		reason = CloseReasonError
+	}
+	rw.close(reason)
+	return err
+}
+
+// Done returns a channel that is closed once the stream has been closed.
+func (rw *responseWriter) Done() <-chan struct{} {
+	return rw.done
+}
+
+// closeWith sends a final message and closes the stream.
+func (rw *responseWriter) closeWith(m message, reason CloseReason) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.closed {
+		return ErrClosed
+	}
+	err := rw.send(m)
+	if err != nil {
+		reason = CloseReasonError
+	}
+	rw.close(reason)
+	return err
+}
+
+// close marks the stream as closed. The caller must hold rw.mu.
+func (rw *responseWriter) close(reason CloseReason) {
+	if rw.closed {
+		return
+	}
+	rw.closed = true
+	for _, t := range rw.timers {
+		t.Stop()
+	}
+	if rw.ws != nil {
+		// The close frame is sent without holding rw.mu.
+		go rw.ws.close(webSocketCloseCode(reason))
+	}
+	if rw.onClose != nil {
+		rw.onClose()
+	}
+	rw.log.Info("sse stream closed",
+		slog.String("reason", string(reason)),
+		slog.Int64("events", rw.events),
+		slog.Int64("bytes", rw.bytes),
+		slog.Duration("duration", time.Since(rw.opened)),
+	)
+	if rw.options.Hooks.OnClose != nil {
+		rw.options.Hooks.OnClose(CloseInfo{
+			ConnID:   rw.id,
+			Reason:   reason,
+			Events:   rw.events,
+			Bytes:    rw.bytes,
+			Duration: time.Since(rw.opened),
+		})
+	}
+	if rw.span != nil {
+		rw.span.SetAttributes(
+			Attribute{Key: "sse.close_reason", Value: string(reason)},
+			Attribute{Key: "sse.events", Value: rw.events},
+			Attribute{Key: "sse.bytes", Value: rw.bytes},
+		)
+		rw.span.End()
+	}
+	close(rw.done)
+}
+
+// send frames the buffered messages followed by msgs, and encodes and flushes
+// them as a single write. The caller must hold rw.mu.
+func (rw *responseWriter) send(msgs ...message) error {
+	if len(rw.pending) > 0 {
+		msgs = append(rw.pending, msgs...)
+		rw.pending = nil
+	}
+	return rw.sendMessages(msgs)
+}
+
+// sendMessages frames msgs, leaving the buffered messages pending, and
+// encodes and flushes them as a single write. The caller must hold rw.mu.
+func (rw *responseWriter) sendMessages(msgs []message) (err error) {
+	if len(msgs) == 0 {
+		return nil
+	}
+	defer func() {
+		for _, m := range msgs {
+			m.end(err)
+		}
+	}()
+
+	start := time.Now()
+	var output string
+	texts := make([]string, 0, len(msgs))
+	frames := make([]frameInfo, 0, len(msgs))
+	for _, m := range msgs {
+		frame := rw.frame(m)
+		output += frame
+		texts = append(texts, frame)
+		if m.comment == "" {
+			frames = append(frames, frameInfo{event: m.event, size: len(frame)})
+		}
+	}
+	event := msgs[len(msgs)-1].event
+
+	if rw.ws != nil {
+		n, err := rw.ws.writeText(texts)
+		rw.bytes += int64(n)
+		if err != nil {
+			rw.reportError("write", event, err)
 			return err
 		}
-		output += fmt.Sprintf("data: %s\n", encodedData)
+		rw.events += int64(len(frames))
+		rw.reportFlush(frames, len(output), n, time.Since(start))
+		return nil
 	}
-	output += "\n"
 
 	encodedOutput, err := encode(rw.options.Encoding, output)
 	if err != nil {
+		rw.reportError("encode", event, err)
 		return err
 	}
 
-	if _, err := rw.writer.Write(encodedOutput); err != nil {
+	n, err := rw.writer.Write(encodedOutput)
+	rw.bytes += int64(n)
+	if err != nil {
+		rw.reportError("write", event, err)
+		return err
+	}
+	if err := rw.flush(); err != nil {
+		rw.reportError("flush", event, err)
 		return err
 	}
-	return rw.flush()
+
+	rw.events += int64(len(frames))
+	rw.reportFlush(frames, len(output), len(encodedOutput), time.Since(start))
+	return nil
+}
+
+// frame formats a message in the event stream format. The caller must hold
+// rw.mu.
+func (rw *responseWriter) frame(m message) string {
+	if m.comment != "" {
+		return fmt.Sprintf(": %s\n\n", m.comment)
+	}
+
+	id := m.id
+	if id == "" {
+		rw.nonce = (rw.nonce + 1) % NonceMax
+		id = strconv.FormatUint(rw.nonce, 10)
+	}
+	rw.lastEvent = time.Now()
+
+	output := fmt.Sprintf("id: %s\n", id)
+	if m.event != "" {
+		output += fmt.Sprintf("event: %s\n", m.event)
+	}
+	if m.topic != "" {
+		output += fmt.Sprintf("topic: %s\n", m.topic)
+	}
+	if m.traceParent != "" {
+		output += fmt.Sprintf("traceparent: %s\n", m.traceParent)
+		m.span.SetAttributes(Attribute{Key: "sse.id", Value: id})
+	}
+	if m.retry > 0 {
+		output += fmt.Sprintf("retry: %d\n", m.retry.Milliseconds())
+	}
+	if m.data != nil {
+		for _, line := range lineBreaks.Split(string(m.data), -1) {
+			output += fmt.Sprintf("data: %s\n", line)
+		}
+	}
+	output += "\n"
+	return output
 }
 
-// sendHeaders sends the headers for Server-Sent Events.
+// lineBreaks matches the line endings of the event stream format.
+var lineBreaks = regexp.MustCompile("\r\n|\r|\n")
+
+// startTimers arms the timers that enforce MaxLifetime and IdleTimeout and
+// send heartbeats.
+func (rw *responseWriter) startTimers() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.lastEvent = time.Now()
+
+	if d := rw.options.MaxLifetime; d > 0 {
+		lifetime := d - time.Duration(rand.Int63n(int64(d)/10+1))
+		rw.timers = append(rw.timers, time.AfterFunc(lifetime, func() {
+			rw.expire(CloseReasonLifetime)
+		}))
+	}
+	if d := rw.options.IdleTimeout; d > 0 {
+		var t *time.Timer
+		t = time.AfterFunc(d, func() {
+			rw.mu.Lock()
+			closed, idle := rw.closed, time.Since(rw.lastEvent)
+			rw.mu.Unlock()
+			if closed {
+				return
+			}
+			if idle < d {
+				t.Reset(d - idle)
+				return
+			}
+			rw.expire(CloseReasonIdle)
+		})
+		rw.timers = append(rw.timers, t)
+	}
+	if d := rw.options.Heartbeat; d > 0 {
+		var t *time.Timer
+		t = time.AfterFunc(d, func() {
+			rw.mu.Lock()
+			defer rw.mu.Unlock()
+			if rw.closed {
+				return
+			}
+			// The pending batch keeps waiting for FlushInterval.
+			if err := rw.sendMessages([]message{{comment: "heartbeat"}}); err != nil {
+				rw.close(CloseReasonError)
+				return
+			}
+			t.Reset(d)
+		})
+		rw.timers = append(rw.timers, t)
+	}
+}
+
+// expire closes the stream with a retry hint.
+func (rw *responseWriter) expire(reason CloseReason) {
+	retry := rw.options.Retry
+	if retry <= 0 {
+		retry = DefaultRetry
+	}
+	_ = rw.closeWith(message{retry: retry}, reason)
+}
+
+// sendHeaders sends the headers for Server-Sent Events. A WebSocket stream
+// has already sent its handshake response.
 func (rw *responseWriter) sendHeaders() {
+	if rw.ws != nil {
+		rw.reportOpen(http.StatusSwitchingProtocols)
+		return
+	}
 	headers := rw.writer.Header()
 	headers.Set("Content-Type", "text/event-stream")
 	headers.Set("Cache-Control", "no-store")
@@ -87,6 +631,19 @@ func (rw *responseWriter) sendHeaders() {
 	if err := rw.flush(); err != nil {
 		// Intentionally ignored: cannot recover from flush error after headers are sent
 	}
+	rw.reportOpen(status)
+}
+
+// reportOpen reports that the stream was opened with the given status.
+func (rw *responseWriter) reportOpen(status int) {
+	rw.log.Info("sse stream opened", slog.Int("status", status))
+	if rw.options.Hooks.OnConnect != nil {
+		rw.options.Hooks.OnConnect(ConnectInfo{
+			ConnID:   rw.id,
+			Encoding: rw.options.Encoding,
+			Status:   status,
+		})
+	}
 }
 
 // flush flushes the response.
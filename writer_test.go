@@ -6,7 +6,9 @@ import (
 	"compress/gzip"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/andybalholm/brotli"
 	"github.com/klauspost/compress/zstd"
@@ -201,3 +203,109 @@ func TestResponseWriter_Flush(t *testing.T) {
 type nonFlusherWriter struct {
 	http.ResponseWriter
 }
+
+func TestResponseWriter_Close(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writer := NewResponseWriter(rec, Options{}).(CloseWriter)
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	select {
+	case <-writer.Done():
+	default:
+		t.Fatalf("expected Done to be closed")
+	}
+	if err := writer.Write("test", "test"); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("expected closing twice to succeed, got %v", err)
+	}
+	if rec.Body.Len() != 0 {
+		t.Fatalf("expected no data to be written, got %q", rec.Body.String())
+	}
+}
+
+func TestResponseWriter_MaxLifetime(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writer := NewResponseWriter(rec, Options{
+		MaxLifetime: 20 * time.Millisecond,
+		Retry:       2 * time.Second,
+	}).(CloseWriter)
+
+	select {
+	case <-writer.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expected stream to be closed after MaxLifetime")
+	}
+
+	expected := "id: 1\nretry: 2000\n\n"
+	if rec.Body.String() != expected {
+		t.Fatalf("expected data: %q, got: %q", expected, rec.Body.String())
+	}
+}
+
+func TestResponseWriter_IdleTimeout(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writer := NewResponseWriter(rec, Options{
+		IdleTimeout: 50 * time.Millisecond,
+		Heartbeat:   10 * time.Millisecond,
+	}).(CloseWriter)
+
+	// Writing an event postpones the idle timeout, heartbeats do not.
+	time.Sleep(30 * time.Millisecond)
+	if err := writer.Write("test", nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	start := time.Now()
+
+	select {
+	case <-writer.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expected stream to be closed after IdleTimeout")
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("expected stream to stay open after an event, closed after %v", elapsed)
+	}
+
+	body := rec.Body.String()
+	if !strings.Contains(body, ": heartbeat\n\n") {
+		t.Errorf("expected heartbeats in %q", body)
+	}
+	if !strings.Contains(body, "id: 1\nevent: test\n\n") {
+		t.Errorf("expected event in %q", body)
+	}
+	if !strings.HasSuffix(body, "id: 2\nretry: 3000\n\n") {
+		t.Errorf("expected stream to end with the default retry hint, got %q", body)
+	}
+}
+
+func TestResponseWriter_WriteEvent(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writer := NewResponseWriter(rec, Options{}).(EventWriter)
+
+	events := []Event{
+		{ID: "42", Event: "update", Data: "first\nsecond\r\nthird"},
+		{Data: ""},
+		{Event: "ping", Data: "pong"},
+	}
+	for _, e := range events {
+		if err := writer.WriteEvent(e); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	expected := "id: 42\nevent: update\ndata: first\ndata: second\ndata: third\n\n" +
+		"id: 1\ndata: \n\n" +
+		"id: 2\nevent: ping\ndata: pong\n\n"
+	if rec.Body.String() != expected {
+		t.Fatalf("expected data: %q, got: %q", expected, rec.Body.String())
+	}
+
+	for _, e := range []Event{{ID: "1\n2"}, {ID: "1\x002"}, {Event: "a\rb"}} {
+		if err := writer.WriteEvent(e); err != ErrInvalidEvent {
+			t.Errorf("expected ErrInvalidEvent for %+v, got %v", e, err)
+		}
+	}
+}